@@ -1,144 +1,169 @@
 // Package main is the entry point for our Redis-like server implementation.
 // In Go, the main package is special - it defines a standalone executable program, not a library.
+// It turns the command-line flags into server options and runs the server until it's
+// told to stop; the server itself lives in the server package, so other programs can embed it
 package main
 
 // Import necessary standard library packages:
-// - fmt: for printing messages and errors
-// - net: for network functionality (TCP server)
-// - strings: for string manipulation (converting commands to uppercase)
+// - flag: for parsing command-line options
+// - fmt: for printing errors
+// - net, strconv, strings: for building the TCP addresses from -bind and -port
+// - os, os/signal, syscall: for graceful shutdown on SIGINT/SIGTERM
+// - time: for the keepalive interval
 import (
+    "flag"
     "fmt"
     "net"
+    "os"
+    "os/signal"
+    "strconv"
     "strings"
+    "syscall"
+    "time"
+
+    "RedisFromScratch/server"
 )
 
 // main is the entry point of our program. When you run the program, this function
-// gets called first. It sets up our Redis-like server and contains the main server loop.
+// gets called first. It parses the flags, then starts the server and waits for it to stop.
 func main() {
-    // Print a message indicating that our server is starting up
-    // This will help users know the server is running
-    fmt.Println("Listening on port :6379")
-
-    // Create a TCP listener on port 6379 (the default Redis port)
-    // net.Listen creates a server that can accept incoming connections
-    // "tcp" specifies we want a TCP connection (as opposed to UDP)
-    // The second argument ":6379" means listen on all network interfaces on port 6379
-    l, err := net.Listen("tcp", ":6379")
-    
-    // Error handling: if we couldn't create the listener (e.g., port is already in use)
-    // print the error and exit the program
-    if err != nil {
-        fmt.Println(err)
-        return
-    }
+    // Parse command-line options
+    // The protocol limits protect the server from clients announcing huge
+    // bulk strings or arrays that would otherwise be allocated up front
+    flag.IntVar(&server.MaxBulkLen, "maxbulklen", server.MaxBulkLen, "maximum size of a bulk string in bytes")
+    flag.IntVar(&server.MaxArrayLen, "maxarraylen", server.MaxArrayLen, "maximum number of elements in an array")
+    flag.IntVar(&server.MaxQueryLen, "maxquerylen", server.MaxQueryLen, "maximum size of a whole command in bytes")
 
-    // Create a new Append-Only File (AOF) for persistence
-    // This is how Redis maintains data across server restarts
-    // The file will be named "database.aof"
-    aof, err := NewAof("database.aof")
-    
-    // If we couldn't create/open the AOF file, print the error and exit
-    if err != nil {
-        fmt.Println(err)
-        return
-    }
-    
-    // Make sure we close the AOF file when the program exits
-    // defer ensures this happens even if we encounter an error
-    defer aof.Close()
-
-    // Read existing commands from the AOF file and replay them
-    // This restores our database to its state before the last shutdown
-    aof.Read(func(value Value) {
-        // Extract the command name (like "SET", "GET", etc.) and convert to uppercase
-        command := strings.ToUpper(value.array[0].bulk)
-        
-        // Get the command arguments (everything after the command name)
-        args := value.array[1:]
-
-        // Look up the handler function for this command
-        handler, ok := Handlers[command]
-        
-        // If we don't recognize the command, print an error and skip it
-        if !ok {
-            fmt.Println("Invalid command: ", command)
-            return
-        }
+    // Memory cap for using the server as a bounded cache
+    // These seed the runtime config, so CONFIG SET can still change them later
+    maxmemory := flag.String("maxmemory", "0", "memory limit (e.g. 100mb), 0 means unlimited")
+    maxmemoryPolicy := flag.String("maxmemory-policy", "noeviction", "eviction policy: noeviction, allkeys-lru or allkeys-random")
 
-        // Execute the command with its arguments
-        handler(args)
-    })
+    // Where to accept connections
+    // TCP and the Unix socket can run side by side; -port 0 turns TCP off
+    port := flag.Int("port", 6379, "TCP port to listen on, 0 disables TCP")
+    bind := flag.String("bind", "", "comma-separated addresses to listen on for TCP, empty means all interfaces")
+    unixSocket := flag.String("unixsocket", "", "path of a Unix domain socket to listen on")
 
-    // Accept a new connection from a client
-    // This blocks until a client connects
-    conn, err := l.Accept()
-    
-    // If we couldn't accept the connection, print the error and exit
-    if err != nil {
-        fmt.Println(err)
+    // Accept queue and port sharing for the TCP listener, see server/listen.go
+    tcpBacklog := flag.Int("tcp-backlog", 0, "TCP accept queue length, 0 for the default; a hint, capped by the kernel limit")
+    reusePort := flag.Bool("reuseport", false, "set SO_REUSEPORT so several servers can share the TCP port")
+    tcpKeepalive := flag.Int("tcp-keepalive", 300, "seconds between TCP keepalive probes on client connections, 0 disables them")
+
+    // TLS for the TCP listener
+    // Setting both a certificate and a key turns it on; adding a CA bundle
+    // also requires clients to present a certificate signed by it (mTLS)
+    tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM)")
+    tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+    tlsCA := flag.String("tls-ca", "", "CA certificate file (PEM) used to verify client certificates")
+
+    // Slow log threshold, also adjustable at runtime with CONFIG SET
+    slowlogLogSlowerThan := flag.String("slowlog-log-slower-than", "10000", "log commands slower than this many microseconds, negative disables")
+
+    // Keyspace notification classes, e.g. "KEA" for everything
+    notifyKeyspaceEvents := flag.String("notify-keyspace-events", "", "keyspace events to publish (K, E, g, $, h, z, x, e, A, ...)")
+
+    // Prometheus metrics are served over HTTP on their own port, if one is given
+    metricsPort := flag.Int("metrics-port", 0, "HTTP port serving Prometheus metrics on /metrics, 0 disables it")
+
+    // HTTP health checks are served on the metrics port, and on a port of their own if one is given
+    healthzPort := flag.Int("healthz-port", 0, "HTTP port serving only the /healthz health check, 0 disables it")
+
+    // Automatic AOF rewrites, also adjustable with CONFIG SET
+    autoAofRewritePercentage := flag.String("auto-aof-rewrite-percentage", "100", "rewrite the AOF when it grows by this percentage, 0 disables")
+    autoAofRewriteMinSize := flag.String("auto-aof-rewrite-min-size", "64mb", "minimum AOF size for an automatic rewrite")
+
+    // How far a pub/sub subscriber may fall behind, also adjustable with CONFIG SET
+    clientOutputBufferLimit := flag.String("client-output-buffer-limit", "pubsub 32mb 8mb 60", "output buffer limits as 'class hard soft seconds'; only pubsub is enforced")
+
+    // Where data files such as the AOF are kept
+    dir := flag.String("dir", ".", "directory for data files, created if missing")
+
+    // Connections beyond this many are refused, also adjustable with CONFIG SET
+    maxclients := flag.String("maxclients", "10000", "maximum number of connected clients")
+
+    // Active expiry frequency and sample size, also adjustable with CONFIG SET
+    hz := flag.String("hz", "10", "active expire cycles per second (1-500)")
+    activeExpireSamples := flag.String("active-expire-samples", "20", "keys with an expiry sampled per active expire pass")
+
+    // Insertion-ordered hashes, mostly for tests, also adjustable with CONFIG SET
+    hashPreserveOrder := flag.Bool("hash-preserve-order", false, "return hash fields in the order they were added")
+
+    // Whether writes are refused while the AOF can't be written, also adjustable with CONFIG SET
+    stopWritesOnPersistenceError := flag.String("stop-writes-on-persistence-error", "yes", "refuse writes while the AOF can't be written (yes or no)")
+
+    // Where log messages go; SIGHUP reopens the file, for log rotation
+    logfile := flag.String("logfile", "", "file to append log messages to, empty means stdout")
+    flag.Parse()
+
+    if *tcpKeepalive < 0 {
+        fmt.Println("Invalid -tcp-keepalive:", *tcpKeepalive)
+        return
+    }
+    if *port == 0 && *unixSocket == "" {
+        fmt.Println("Nothing to listen on: set -port or -unixsocket")
         return
     }
 
-    // Ensure we close the connection when we're done with it
-    defer conn.Close()
-
-    // Main server loop - this runs forever, processing client commands
-    for {
-        // Create a new RESP (Redis Serialization Protocol) reader for this connection
-        resp := NewResp(conn)
-        
-        // Read the next command from the client
-        value, err := resp.Read()
-        
-        // If there was an error reading (e.g., client disconnected),
-        // print it and exit
-        if err != nil {
-            fmt.Println(err)
-            return
-        }
+    opts := server.Options{
+        UnixSocket:   *unixSocket,
+        TCPBacklog:   *tcpBacklog,
+        ReusePort:    *reusePort,
+        TCPKeepalive: time.Duration(*tcpKeepalive) * time.Second,
+        TLSCert:      *tlsCert,
+        TLSKey:       *tlsKey,
+        TLSCA:        *tlsCA,
+        Dir:          *dir,
+        MetricsPort:  *metricsPort,
+        HealthzPort:  *healthzPort,
+        LogFile:      *logfile,
+
+        // The flags for runtime parameters are named after them
+        Config: map[string]string{
+            "maxmemory":                        *maxmemory,
+            "maxmemory-policy":                 *maxmemoryPolicy,
+            "slowlog-log-slower-than":          *slowlogLogSlowerThan,
+            "auto-aof-rewrite-percentage":      *autoAofRewritePercentage,
+            "auto-aof-rewrite-min-size":        *autoAofRewriteMinSize,
+            "maxclients":                       *maxclients,
+            "hz":                               *hz,
+            "active-expire-samples":            *activeExpireSamples,
+            "client-output-buffer-limit":       *clientOutputBufferLimit,
+            "stop-writes-on-persistence-error": *stopWritesOnPersistenceError,
+            "notify-keyspace-events":           *notifyKeyspaceEvents,
+        },
+    }
+    if *hashPreserveOrder {
+        opts.Config["hash-preserve-order"] = "yes"
+    }
 
-        // Commands should be arrays in RESP format
-        // Check that we received an array
-        if value.typ != "array" {
-            fmt.Println("Invalid request, expected array")
-            continue  // Skip this command and wait for the next one
+    // One TCP address per bind address, or a single one on every interface
+    if *port != 0 {
+        hosts := []string{""}
+        if *bind != "" {
+            hosts = strings.Split(*bind, ",")
         }
-
-        // Check that the array isn't empty
-        // (Every command needs at least a command name)
-        if len(value.array) == 0 {
-            fmt.Println("Invalid request, expected array length > 0")
-            continue
+        for _, host := range hosts {
+            opts.Addrs = append(opts.Addrs, net.JoinHostPort(strings.TrimSpace(host), strconv.Itoa(*port)))
         }
+    }
 
-        // Extract the command name and convert to uppercase
-        // Commands in Redis are case-insensitive
-        command := strings.ToUpper(value.array[0].bulk)
-        
-        // Get the command arguments
-        args := value.array[1:]
-
-        // Create a writer to send responses back to the client
-        writer := NewWriter(conn)
-
-        // Look up the handler function for this command
-        handler, ok := Handlers[command]
-        
-        // If we don't recognize the command, send an empty response
-        if !ok {
-            fmt.Println("Invalid command: ", command)
-            writer.Write(Value{typ: "string", str: ""})
-            continue
-        }
+    srv, err := server.NewServer(opts)
+    if err != nil {
+        fmt.Println(err)
+        return
+    }
 
-        // If this is a write command (SET or HSET),
-        // write it to the AOF file for persistence
-        if command == "SET" || command == "HSET" {
-            aof.Write(value)
-        }
+    // SIGINT and SIGTERM shut the server down like SHUTDOWN does
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+    go func() {
+        <-stop
+        srv.Close()
+    }()
 
-        // Execute the command and send the result back to the client
-        result := handler(args)
-        writer.Write(result)
+    // Serve until we're asked to stop, by a signal or a SHUTDOWN command
+    if err := srv.ListenAndServe(); err != nil {
+        fmt.Println(err)
     }
-}
\ No newline at end of file
+}
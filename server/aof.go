@@ -0,0 +1,437 @@
+// Package server implements AOF (Append Only File) persistence
+// AOF is Redis's primary persistence mechanism, logging all write commands
+package server
+
+// Import required packages
+import (
+    "bufio"    // For buffered I/O operations
+    "errors"   // For recognizing a truncated final record
+    "fmt"      // For reporting a truncated file
+    "io"       // For basic I/O interfaces
+    "os"       // For file operations
+    "sync"     // For mutex synchronization
+    "time"     // For sleep operations
+)
+
+// aofFilename is the name of the AOF inside the data directory (-dir)
+const aofFilename = "database.aof"
+
+// Aof represents an Append Only File
+// It handles persistence by logging all write operations to disk
+type Aof struct {
+    path string           // Where the file lives, so a rewrite can replace it
+    file *os.File         // The actual file on disk
+    rd   *bufio.Reader    // Buffered reader for reading the file
+    wr   *bufio.Writer    // Write-behind buffer for appended commands
+    mu   sync.Mutex       // Mutex to protect concurrent access
+
+    rewriting  bool       // A rewrite is running
+    rewriteBuf []byte     // Commands appended since the rewrite took its snapshot
+    baseSize   int64      // Size of the file after loading or the last rewrite
+
+    lastErr error         // Why the last write, flush or sync failed, nil if it succeeded
+
+    closed  bool          // Close was called; a rewrite must not replace the file any more
+    stop    chan struct{} // Closed by Close to end the background goroutine
+    stopped chan struct{} // Closed by the background goroutine once it has returned
+}
+
+// errAofClosed is returned by a rewrite that finishes after the AOF was closed
+var errAofClosed = errors.New("AOF is closed")
+
+// NewAof creates a new AOF handler
+// path: the filesystem path where the AOF file will be stored
+func NewAof(path string) (*Aof, error) {
+    // Open the file with create, read, and write permissions
+    // O_CREATE: create file if it doesn't exist
+    // O_RDWR: open for reading and writing
+    // 0666: read/write permissions for user, group, and others
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+    if err != nil {
+        return nil, err
+    }
+
+    // Create new AOF instance
+    aof := &Aof{
+        path: path,
+        file: f,
+        rd:   bufio.NewReader(f),
+        wr:   bufio.NewWriter(f),
+
+        stop:    make(chan struct{}),
+        stopped: make(chan struct{}),
+    }
+
+    // Start background goroutine for periodic flushing and disk sync
+    // Writes collect in the buffer, so once a second we hand them to the
+    // operating system in one go. With appendfsync "everysec" we also force
+    // them to disk; with "no" we leave that to the operating system, and
+    // with "always" every Write already flushes and syncs on its own
+    // It runs until Close, which waits for it to return before closing the file
+    go func() {
+        defer close(aof.stopped)
+
+        ticker := time.NewTicker(time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-aof.stop:
+                return
+            case <-ticker.C: // Wait 1 second before next sync
+            }
+
+            aof.mu.Lock()           // Acquire lock
+            err := aof.wr.Flush()   // Hand buffered commands to the OS
+            if err == nil && config.AppendFsync() == "everysec" {
+                err = aof.file.Sync() // Force write to disk
+            }
+            aof.setErr(err)         // Remembered for the health check and MISCONF
+            aof.mu.Unlock()         // Release lock
+
+            // A failed write leaves the buffer unusable, so the way back is a
+            // rewrite into a fresh file, tried again every second until it works
+            if err != nil {
+                aof.rewriteInBackground()
+            } else {
+                aof.rewriteIfGrown() // Start a rewrite if the file grew too much
+            }
+        }
+    }()
+
+    return aof, nil
+}
+
+// Close safely closes the AOF file
+// This should be called when shutting down the server
+// Buffered commands are flushed first so nothing written is lost
+// The background goroutine is stopped first, and a rewrite still running
+// afterwards is discarded, so nothing replaces the file once it's closed
+func (aof *Aof) Close() error {
+    aof.mu.Lock()
+    if aof.closed {
+        aof.mu.Unlock()
+        return nil
+    }
+    aof.closed = true
+    aof.mu.Unlock()
+
+    close(aof.stop)
+    <-aof.stopped
+
+    aof.mu.Lock()
+    defer aof.mu.Unlock()  // Ensure lock is released even if Close fails
+
+    if err := aof.wr.Flush(); err != nil {
+        aof.file.Close()
+        return err
+    }
+    return aof.file.Close()
+}
+
+// Sync forces everything written so far onto the disk
+func (aof *Aof) Sync() error {
+    aof.mu.Lock()
+    defer aof.mu.Unlock()
+
+    if err := aof.wr.Flush(); err != nil {
+        return err
+    }
+    return aof.file.Sync()
+}
+
+// Err returns why the AOF last failed to write, flush or sync, or nil if
+// the last attempt succeeded
+// A bufio.Writer keeps failing once it has failed, so after an error the
+// AOF stays failed until a rewrite moves it to a new file
+func (aof *Aof) Err() error {
+    aof.mu.Lock()
+    defer aof.mu.Unlock()
+    return aof.lastErr
+}
+
+// setErr records the outcome of a write, flush or sync for Err
+// Starting to fail is logged, and so is recovering
+// The caller must hold aof.mu
+func (aof *Aof) setErr(err error) {
+    switch {
+    case err != nil && aof.lastErr == nil:
+        fmt.Fprintln(logOutput, "Error writing to the AOF file:", err)
+    case err == nil && aof.lastErr != nil:
+        fmt.Fprintln(logOutput, "AOF file is being written again")
+    }
+    aof.lastErr = err
+}
+
+// Size returns the size of the AOF, counting commands still in the write buffer
+func (aof *Aof) Size() (int64, error) {
+    aof.mu.Lock()
+    defer aof.mu.Unlock()
+
+    info, err := aof.file.Stat()
+    if err != nil {
+        return 0, err
+    }
+    return info.Size() + int64(aof.wr.Buffered()), nil
+}
+
+// Write appends a new command to the AOF file
+// This is called for every write operation (SET, HSET, etc.)
+func (aof *Aof) Write(value Value) error {
+    aof.mu.Lock()
+    defer aof.mu.Unlock()  // Ensure lock is released after write
+
+    // Marshal the command to RESP format and add it to the write buffer
+    // The background goroutine flushes it once a second
+    data := value.Marshal()
+
+    // A running rewrite needs everything written after its snapshot too,
+    // even if the old file can't take it any more
+    if aof.rewriteBuf != nil {
+        aof.rewriteBuf = append(aof.rewriteBuf, data...)
+    }
+
+    _, err := aof.wr.Write(data)
+    if err != nil {
+        aof.setErr(err)
+        return err
+    }
+
+    // With appendfsync "always", every write hits the disk before we reply
+    if config.AppendFsync() == "always" {
+        err = aof.wr.Flush()
+        if err == nil {
+            err = aof.file.Sync()
+        }
+        aof.setErr(err)
+        return err
+    }
+
+    return nil
+}
+
+// Read processes all commands in the AOF file
+// This is called during server startup to rebuild the database state
+// fn is a callback function that processes each command
+//
+// If the server crashed while appending, the last record may be cut short.
+// Like Redis's "aof-load-truncated yes", we keep every complete command before
+// it, log how many bytes were discarded, and truncate the file there so new
+// writes don't land after the broken record.
+func (aof *Aof) Read(fn func(value Value)) error {
+    aof.mu.Lock()
+    defer aof.mu.Unlock()
+
+    // Seek to start of file
+    aof.file.Seek(0, io.SeekStart)
+
+    // Create a RESP reader for the file
+    reader := NewResp(aof.file)
+
+    // Read and process each command
+    for {
+        // Remember where this record starts, in case it turns out to be incomplete
+        start := reader.Offset()
+
+        // Read next command
+        value, err := reader.Read()
+        if err != nil {
+            // If we've reached end of file cleanly between records, we're done
+            if err == io.EOF && reader.Offset() == start {
+                break
+            }
+
+            // The file ended in the middle of a record: drop the partial record
+            if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+                return aof.truncate(start)
+            }
+            return err
+        }
+
+        // Process the command using callback function
+        fn(value)
+    }
+
+    // Growth that triggers an automatic rewrite is measured from here
+    aof.baseSize = reader.Offset()
+    return nil
+}
+
+// truncate cuts the AOF file at offset, discarding an incomplete final record
+// The caller must hold aof.mu
+func (aof *Aof) truncate(offset int64) error {
+    info, err := aof.file.Stat()
+    if err != nil {
+        return err
+    }
+
+    fmt.Fprintf(logOutput, "Warning: AOF file ends with an incomplete command, discarding the last %d bytes\n", info.Size()-offset)
+
+    if err := aof.file.Truncate(offset); err != nil {
+        return err
+    }
+
+    // Continue appending right after the last complete command
+    aof.baseSize = offset
+    _, err = aof.file.Seek(offset, io.SeekStart)
+    return err
+}
+
+// errRewriteInProgress is returned by Rewrite when another rewrite is still running
+var errRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// Rewrite replaces the AOF with the shortest list of commands that rebuilds the
+// current dataset, dropping overwritten and deleted values
+//
+// Commands are stopped only while the dataset is snapshotted. The new file is
+// then written next to the old one while commands go on being appended to the
+// old file and, in memory, to rewriteBuf. At the end the buffered commands are
+// added to the new file and it is renamed over the old one
+func (aof *Aof) Rewrite() error {
+    aof.mu.Lock()
+    if aof.rewriting {
+        aof.mu.Unlock()
+        return errRewriteInProgress
+    }
+    aof.rewriting = true
+    aof.mu.Unlock()
+
+    // Holding execMu for writing keeps every command out, so the snapshot and
+    // the point where rewriteBuf starts collecting line up exactly
+    execMu.Lock()
+    snapshot := rewriteCommands()
+    aof.mu.Lock()
+    aof.rewriteBuf = []byte{}
+    aof.mu.Unlock()
+    execMu.Unlock()
+
+    err := aof.finishRewrite(snapshot)
+
+    aof.mu.Lock()
+    aof.rewriting = false
+    aof.rewriteBuf = nil
+    aof.mu.Unlock()
+
+    return err
+}
+
+// RewriteLocked is Rewrite for a caller that already holds execMu for
+// writing, such as DEBUG RELOAD. No command can run meanwhile, so the whole
+// file is written before returning
+func (aof *Aof) RewriteLocked() error {
+    aof.mu.Lock()
+    if aof.rewriting {
+        aof.mu.Unlock()
+        return errRewriteInProgress
+    }
+    aof.rewriting = true
+    aof.rewriteBuf = []byte{}
+    aof.mu.Unlock()
+
+    err := aof.finishRewrite(rewriteCommands())
+
+    aof.mu.Lock()
+    aof.rewriting = false
+    aof.rewriteBuf = nil
+    aof.mu.Unlock()
+
+    return err
+}
+
+// finishRewrite writes snapshot and the commands buffered since to a temporary
+// file and swaps it in for the AOF
+func (aof *Aof) finishRewrite(snapshot []byte) error {
+    tmpPath := aof.path + ".rewrite"
+    tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+    if err != nil {
+        return err
+    }
+
+    // Write the bulk of it without holding the lock, so commands keep flowing
+    if _, err := tmp.Write(snapshot); err != nil {
+        tmp.Close()
+        os.Remove(tmpPath)
+        return err
+    }
+
+    aof.mu.Lock()
+    defer aof.mu.Unlock()
+
+    // Once the AOF is closed it must stay the file Close left behind
+    if aof.closed {
+        tmp.Close()
+        os.Remove(tmpPath)
+        return errAofClosed
+    }
+
+    // Whatever happens next, the old file stays complete, so flush it first
+    // If it can't be written, carry on: the new file holds everything anyway,
+    // and replacing it is how a failing AOF recovers
+    if err := aof.wr.Flush(); err != nil {
+        aof.setErr(err)
+    }
+
+    if _, err := tmp.Write(aof.rewriteBuf); err != nil {
+        tmp.Close()
+        os.Remove(tmpPath)
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        os.Remove(tmpPath)
+        return err
+    }
+    if err := os.Rename(tmpPath, aof.path); err != nil {
+        tmp.Close()
+        os.Remove(tmpPath)
+        return err
+    }
+
+    // The temporary file is now the AOF; keep appending to it
+    aof.file.Close()
+    aof.file = tmp
+    aof.rd = bufio.NewReader(tmp)
+    aof.wr = bufio.NewWriter(tmp)
+    aof.baseSize = int64(len(snapshot) + len(aof.rewriteBuf))
+    aof.setErr(nil)
+    return nil
+}
+
+// rewriteIfGrown starts a rewrite in the background once the AOF has grown by
+// auto-aof-rewrite-percentage since the last rewrite and is at least
+// auto-aof-rewrite-min-size, like Redis. A percentage of 0 turns this off
+func (aof *Aof) rewriteIfGrown() {
+    percentage := config.AutoAofRewritePercentage()
+    if percentage == 0 {
+        return
+    }
+
+    aof.mu.Lock()
+    rewriting, base := aof.rewriting, aof.baseSize
+    aof.mu.Unlock()
+    if rewriting {
+        return
+    }
+
+    size, err := aof.Size()
+    if err != nil || size < config.AutoAofRewriteMinSize() {
+        return
+    }
+
+    // An empty base would divide by zero, so any growth from nothing counts in full
+    if base == 0 {
+        base = 1
+    }
+    if (size-base)*100/base < int64(percentage) {
+        return
+    }
+
+    fmt.Fprintf(logOutput, "Starting automatic rewriting of AOF on %d%% growth\n", (size-base)*100/base)
+    go aof.rewriteInBackground()
+}
+
+// rewriteInBackground runs Rewrite and logs a failure, for callers that don't wait for it
+func (aof *Aof) rewriteInBackground() {
+    if err := aof.Rewrite(); err != nil && err != errRewriteInProgress {
+        fmt.Fprintln(logOutput, "AOF rewrite failed:", err)
+    }
+}
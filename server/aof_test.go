@@ -0,0 +1,328 @@
+// Package server tests that writes survive an AOF replay
+package server
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+)
+
+// TestGetdelSurvivesRestart checks that a key removed with GETDEL stays gone
+// after the AOF is replayed
+func TestGetdelSurvivesRestart(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+    run(c, "SET", "other", "v")
+    expectBulk(t, run(c, "GETDEL", "k"), "v")
+
+    restart(t, c)
+
+    if reply := run(c, "GET", "k"); reply.typ != "null" {
+        t.Fatalf("GET k after replay: got %+v, want null", reply)
+    }
+    expectBulk(t, run(c, "GET", "other"), "v")
+}
+
+// TestWritesSurviveRestart checks that commands which change a key without
+// being SET or HSET are logged and replayed too
+func TestWritesSurviveRestart(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "s", "hello")
+    run(c, "SETRANGE", "s", "0", "J")
+    run(c, "SET", "ttl", "v")
+    run(c, "GETEX", "ttl", "EX", "100")
+    run(c, "RPUSH", "src", "a", "b")
+    run(c, "LMOVE", "src", "dst", "LEFT", "RIGHT")
+
+    restart(t, c)
+
+    expectBulk(t, run(c, "GET", "s"), "Jello")
+    if reply := run(c, "TTL", "ttl"); reply.typ != "integer" || reply.num <= 0 {
+        t.Fatalf("TTL after replay: got %+v, want a positive TTL", reply)
+    }
+    expectInteger(t, run(c, "LLEN", "src"), 1)
+    expectBulk(t, run(c, "LINDEX", "dst", "0"), "a")
+}
+
+// TestLoadTruncatedAof checks that an AOF whose last SET was only half
+// written still loads, keeping every command before it, and that the broken
+// record is cut off so new writes land after the last good one
+func TestLoadTruncatedAof(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "good", "v")
+    path := c.aof.path
+    if err := c.aof.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    good := info.Size()
+
+    // Append the start of a SET, as a crash in the middle of writing would leave
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString("*3\r\n$3\r\nSET\r\n$4\r\nhalf\r\n$5\r\nval")
+    f.Close()
+
+    emptyDataset()
+    aof, err := NewAof(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.aof = aof
+    if err := loadAof(aof); err != nil {
+        t.Fatalf("loading a truncated AOF: %v", err)
+    }
+
+    expectBulk(t, run(c, "GET", "good"), "v")
+    if reply := run(c, "GET", "half"); reply.typ != "null" {
+        t.Fatalf("GET half: got %+v, want null", reply)
+    }
+    info, err = os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info.Size() != good {
+        t.Fatalf("AOF is %d bytes after loading, want the %d good ones", info.Size(), good)
+    }
+
+    // A write after the load is replayed like any other
+    run(c, "SET", "after", "v")
+    restart(t, c)
+    expectBulk(t, run(c, "GET", "good"), "v")
+    expectBulk(t, run(c, "GET", "after"), "v")
+}
+
+// TestBinarySafeValues checks that keys and values containing \r, \n, NUL and
+// invalid UTF-8 come back byte for byte, live and after an AOF replay
+func TestBinarySafeValues(t *testing.T) {
+    c := newTestClient(t)
+    key := "k\x00\r\n\xff"
+    value := "a\r\nb\x00c\rd\ne\xfe\xff"
+
+    run(c, "SET", key, value)
+    run(c, "HSET", "h", key, value)
+    expectBulk(t, run(c, "GET", key), value)
+    expectBulk(t, run(c, "HGET", "h", key), value)
+
+    restart(t, c)
+
+    expectBulk(t, run(c, "GET", key), value)
+    expectBulk(t, run(c, "HGET", "h", key), value)
+}
+
+// TestRewriteAfterClose checks that Close stops the background goroutine and
+// that a rewrite finishing afterwards leaves the closed file alone
+func TestRewriteAfterClose(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+
+    aof := c.aof
+    if err := aof.Close(); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case <-aof.stopped:
+    default:
+        t.Fatal("the background goroutine is still running after Close")
+    }
+
+    // The dataset no longer matches the file; a rewrite would drop k from it
+    emptyDataset()
+    if err := aof.Rewrite(); err != errAofClosed {
+        t.Fatalf("Rewrite after Close: got %v, want %v", err, errAofClosed)
+    }
+
+    reopened, err := NewAof(aof.path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c.aof = reopened
+    if err := loadAof(reopened); err != nil {
+        t.Fatal(err)
+    }
+    expectBulk(t, run(c, "GET", "k"), "v")
+}
+
+// TestConcurrentWritesReplayInOrder checks that writes from several clients at
+// once are logged in the order they were applied, so replaying the AOF builds
+// the same list the clients built live
+func TestConcurrentWritesReplayInOrder(t *testing.T) {
+    c := newTestClient(t)
+
+    var wg sync.WaitGroup
+    for i := 0; i < 8; i++ {
+        peer := newPeerClient(t, c.aof)
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            for j := 0; j < 200; j++ {
+                run(peer, "RPUSH", "l", fmt.Sprintf("%d:%d", i, j))
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    live := run(c, "LRANGE", "l", "0", "-1")
+    restart(t, c)
+    replayed := run(c, "LRANGE", "l", "0", "-1")
+
+    if len(replayed.array) != len(live.array) {
+        t.Fatalf("replayed %d elements, want %d", len(replayed.array), len(live.array))
+    }
+    for i := range live.array {
+        if replayed.array[i].bulk != live.array[i].bulk {
+            t.Fatalf("element %d: replayed %q, want %q", i, replayed.array[i].bulk, live.array[i].bulk)
+        }
+    }
+}
+
+// snapshot describes every key in the dataset, its value and its expiry to
+// the millisecond, in a form that doesn't depend on map order
+func snapshot() map[string]string {
+    rLockAllStores()
+    defer rUnlockAllStores()
+    expirationsMu.Lock()
+    defer expirationsMu.Unlock()
+
+    keys := map[string]string{}
+    for _, key := range storedKeysLocked() {
+        var items []string
+        switch keyTypeLocked(key) {
+        case "string":
+            items = []string{SETs[key]}
+        case "hash":
+            for field, value := range HSETs[key] {
+                items = append(items, field+"="+value)
+            }
+            sort.Strings(items)
+        case "zset":
+            for member, score := range ZSETs[key].scores {
+                items = append(items, fmt.Sprint(member, "=", score))
+            }
+            sort.Strings(items)
+        case "list":
+            items = LISTs[key]
+        case "set":
+            for member := range SSETs[key] {
+                items = append(items, member)
+            }
+            sort.Strings(items)
+        }
+
+        expiry := "persistent"
+        if when, ok := expirations[key]; ok {
+            expiry = fmt.Sprint(when.UnixMilli())
+        }
+        keys[key] = fmt.Sprintf("%s %q %s", keyTypeLocked(key), items, expiry)
+    }
+    return keys
+}
+
+// TestReplayMatchesLiveState runs writes that succeed, fail, change a TTL or
+// evict keys, and checks that replaying the AOF gives back exactly the
+// dataset the live server had
+func TestReplayMatchesLiveState(t *testing.T) {
+    c := newTestClient(t)
+
+    run(c, "SET", "s", "v")
+    run(c, "SETRANGE", "s", "5", "x")
+    run(c, "HSET", "h", "a", "1", "b", "2")
+    run(c, "ZADD", "z", "1", "a", "2.5", "b")
+    run(c, "RPUSH", "l", "a", "b", "c")
+    run(c, "LMOVE", "l", "l2", "LEFT", "RIGHT")
+    run(c, "SADD", "set1", "a", "b", "c")
+    run(c, "SADD", "set2", "b", "c", "d")
+    run(c, "SINTERSTORE", "inter", "set1", "set2")
+    run(c, "SET", "gone", "v")
+    run(c, "GETDEL", "gone")
+
+    // TTLs set by every command that sets one, and taken off again
+    run(c, "SET", "e1", "v")
+    run(c, "EXPIRE", "e1", "100")
+    run(c, "SET", "e2", "v")
+    run(c, "PEXPIRE", "e2", "200000")
+    run(c, "SET", "gx", "v")
+    run(c, "GETEX", "gx", "PX", "300000")
+    run(c, "SET", "gp", "v")
+    run(c, "EXPIRE", "gp", "100")
+    run(c, "GETEX", "gp", "PERSIST")
+
+    // Writes that fail, or find nothing to change, must change nothing,
+    // live or replayed
+    run(c, "GETEX", "h", "EX", "100")
+    expectError(t, run(c, "SADD", "s", "m"), "WRONGTYPE")
+    dump := run(c, "DUMP", "s")
+    expectError(t, run(c, "RESTORE", "h", "100000", dump.bulk), "BUSYKEY")
+    run(c, "RESTORE", "restored", "100000", dump.bulk)
+
+    // Evict some keys, which only the AOF can tell the replay about
+    config.Set("maxmemory-policy", "allkeys-random")
+    config.Set("maxmemory", "20000")
+    t.Cleanup(func() {
+        config.Set("maxmemory", "0")
+        config.Set("maxmemory-policy", "noeviction")
+    })
+    for i := 0; i < 500; i++ {
+        run(c, "SET", fmt.Sprint("fill:", i), "v")
+    }
+    config.Set("maxmemory", "0")
+
+    live := snapshot()
+    filled := 0
+    for i := 0; i < 500; i++ {
+        if _, ok := live[fmt.Sprint("fill:", i)]; ok {
+            filled++
+        }
+    }
+    if filled == 500 {
+        t.Fatal("nothing was evicted")
+    }
+
+    restart(t, c)
+
+    replayed := snapshot()
+    if len(replayed) != len(live) {
+        t.Errorf("%d keys after replay, %d live", len(replayed), len(live))
+    }
+    for key, want := range live {
+        if got := replayed[key]; got != want {
+            t.Errorf("%q: replayed %s, live %s", key, got, want)
+        }
+    }
+    for key := range replayed {
+        if _, ok := live[key]; !ok {
+            t.Errorf("%q: replayed but not live", key)
+        }
+    }
+}
+
+// TestReplayKeepsRemainingTTL checks that a TTL is logged as an absolute time:
+// after a restart following some downtime, the key has what was left of its
+// TTL rather than a fresh one, or is gone if it ran out meanwhile
+func TestReplayKeepsRemainingTTL(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "long", "v")
+    run(c, "PEXPIRE", "long", "1000")
+    run(c, "SET", "short", "v")
+    run(c, "PEXPIRE", "short", "100")
+
+    // The server is down for this long
+    time.Sleep(300 * time.Millisecond)
+    restart(t, c)
+
+    if reply := run(c, "PTTL", "long"); reply.typ != "integer" || reply.num <= 0 || reply.num > 700 {
+        t.Fatalf("PTTL after 300ms of downtime: got %+v, want at most 700", reply)
+    }
+    if reply := run(c, "GET", "short"); reply.typ != "null" {
+        t.Fatalf("GET of a key whose TTL ran out during downtime: got %+v", reply)
+    }
+}
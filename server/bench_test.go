@@ -0,0 +1,73 @@
+// Package server benchmarks the path a command takes through the server
+// Run with: go test ./server -bench . -benchmem -run '^$'
+package server
+
+import (
+    "io"
+    "testing"
+)
+
+// repeatReader replays data over and over, like a client that never stops sending
+type repeatReader struct {
+    data []byte
+    pos  int
+}
+
+// Read copies the next bytes of data into p, starting again at the end
+func (r *repeatReader) Read(p []byte) (int, error) {
+    n := 0
+    for n < len(p) {
+        copied := copy(p[n:], r.data[r.pos:])
+        n += copied
+        r.pos = (r.pos + copied) % len(r.data)
+    }
+    return n, nil
+}
+
+// BenchmarkSetGet drives pipelined SET and GET commands through the parser,
+// dispatch and the reply writer, the way a connection handler does
+// Each iteration is one command
+func BenchmarkSetGet(b *testing.B) {
+    c := newTestClient(b)
+    writer := NewWriter(io.Discard)
+
+    stream := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n")
+    resp := NewResp(&repeatReader{data: stream})
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        value, err := resp.ReadCommand()
+        if err != nil {
+            b.Fatal(err)
+        }
+        if err := writer.Write(dispatch(c, value)); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkWriteSimpleReplies writes the most common small replies, +OK, an
+// integer and an error, the way a connection handler writes every reply
+// The Writer encodes into a buffer it keeps between replies, so none of them
+// should allocate
+func BenchmarkWriteSimpleReplies(b *testing.B) {
+    replies := []struct {
+        name  string
+        value Value
+    }{
+        {"OK", Value{typ: "string", str: "OK"}},
+        {"integer", Value{typ: "integer", num: 12345}},
+        {"error", wrongTypeError},
+    }
+
+    for _, reply := range replies {
+        b.Run(reply.name, func(b *testing.B) {
+            writer := NewWriter(io.Discard)
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                writer.Write(reply.value)
+            }
+        })
+    }
+}
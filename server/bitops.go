@@ -0,0 +1,175 @@
+// Package server implements the bit commands: SETBIT, GETBIT and BITCOUNT
+// They treat a string value as an array of bits, so a string can be used as a
+// bitmap. Like in Redis, bit 0 is the most significant bit of the first byte
+package server
+
+import (
+    "math/bits"
+    "strconv"
+    "strings"
+)
+
+// setbit implements the Redis SETBIT command
+// It sets or clears the bit at offset, growing the string with zero bytes if
+// it is too short, and returns the bit's previous value
+// The command format is: SETBIT key offset 0|1
+func setbit(c *Client, args []Value) Value {
+    key := args[0].bulk
+    offset, err := strconv.Atoi(args[1].bulk)
+    if err != nil || offset < 0 || offset/8 >= MaxBulkLen {
+        return Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}
+    }
+    if args[2].bulk != "0" && args[2].bulk != "1" {
+        return Value{typ: "error", str: "ERR bit is not an integer or out of range"}
+    }
+    on := args[2].bulk == "1"
+
+    expireIfNeeded(key)
+
+    // Hold the write locks for the whole read-modify-write
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    defer unlockAllStores()
+    if wrongTypeLocked(key, "string") {
+        return wrongTypeError
+    }
+
+    value, ok := SETs[key]
+
+    buf := []byte(value)
+    byteIndex := offset / 8
+    if byteIndex >= len(buf) {
+        buf = append(buf, make([]byte, byteIndex+1-len(buf))...)
+    }
+    mask := byte(0x80) >> (offset % 8)
+    old := 0
+    if buf[byteIndex]&mask != 0 {
+        old = 1
+    }
+    if on {
+        buf[byteIndex] |= mask
+    } else {
+        buf[byteIndex] &^= mask
+    }
+
+    // Like SETRANGE, SETBIT keeps any time to live the key already had
+    setStringLocked(key, string(buf))
+    if !ok {
+        clearExpiration(key)
+    }
+    touchKey(key)
+    notifyKeyspaceEvent(notifyString, "setbit", key)
+
+    return Value{typ: "integer", num: old}
+}
+
+// getbit implements the Redis GETBIT command
+// It returns the bit at offset, which is 0 past the end of the string or for
+// a missing key
+// The command format is: GETBIT key offset
+func getbit(c *Client, args []Value) Value {
+    key := args[0].bulk
+    offset, err := strconv.Atoi(args[1].bulk)
+    if err != nil || offset < 0 {
+        return Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}
+    }
+
+    expireIfNeeded(key)
+
+    SETsMu.RLock()
+    value, ok := SETs[key]
+    SETsMu.RUnlock()
+    if ok {
+        touchKey(key)
+    }
+
+    if offset/8 >= len(value) || value[offset/8]&(0x80>>(offset%8)) == 0 {
+        return Value{typ: "integer", num: 0}
+    }
+    return Value{typ: "integer", num: 1}
+}
+
+// bitcount implements the Redis BITCOUNT command
+// It counts the set bits in the string, or only in the range from start to
+// end, both inclusive. The range is in bytes, or in bits with BIT, and
+// negative offsets count from the end like in GETRANGE
+// The command format is: BITCOUNT key [start end [BYTE|BIT]]
+func bitcount(c *Client, args []Value) Value {
+    key := args[0].bulk
+
+    // A range needs both ends
+    if len(args) == 2 {
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+    inBits := false
+    if len(args) == 4 {
+        switch strings.ToUpper(args[3].bulk) {
+        case "BYTE":
+        case "BIT":
+            inBits = true
+        default:
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+    }
+    var start, end int
+    if len(args) > 1 {
+        var err1, err2 error
+        start, err1 = strconv.Atoi(args[1].bulk)
+        end, err2 = strconv.Atoi(args[2].bulk)
+        if err1 != nil || err2 != nil {
+            return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+        }
+    }
+
+    expireIfNeeded(key)
+
+    SETsMu.RLock()
+    value, ok := SETs[key]
+    SETsMu.RUnlock()
+    if ok {
+        touchKey(key)
+    }
+
+    // Without a range, every byte counts
+    length := len(value)
+    if inBits {
+        length *= 8
+    }
+    if len(args) == 1 {
+        start, end = 0, length-1
+    }
+
+    // Convert negative offsets and clamp both ends, as GETRANGE does
+    if start < 0 {
+        start += length
+    }
+    if end < 0 {
+        end += length
+    }
+    if start < 0 {
+        start = 0
+    }
+    if end < 0 {
+        end = 0
+    }
+    if end >= length {
+        end = length - 1
+    }
+    if length == 0 || start > end {
+        return Value{typ: "integer", num: 0}
+    }
+
+    count := 0
+    if !inBits {
+        for i := start; i <= end; i++ {
+            count += bits.OnesCount8(value[i])
+        }
+        return Value{typ: "integer", num: count}
+    }
+    for i := start; i <= end; i++ {
+        if value[i/8]&(0x80>>(i%8)) != 0 {
+            count++
+        }
+    }
+    return Value{typ: "integer", num: count}
+}
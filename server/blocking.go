@@ -0,0 +1,205 @@
+// Package server implements the blocking list pops, BLPOP and BRPOP
+// A client that finds every list empty isn't answered straight away: its
+// connection handler waits, holding no locks, until a push to one of the
+// keys wakes it, and then runs the command again
+package server
+
+import (
+    "math"
+    "strconv"
+    "time"
+)
+
+// listWait is a client blocked until one of keys gets an element
+type listWait struct {
+    keys     []string
+    deadline time.Time     // When to give up, zero to wait forever
+    wake     chan struct{} // Signalled when one of keys may have elements
+}
+
+// listWaiters maps each key to the clients blocked on it
+// It is protected by LISTsMu, so a client registers under the same lock hold
+// in which it found the lists empty, and a push can't slip in between
+var listWaiters = map[string]map[*listWait]bool{}
+
+// signalListWaitersLocked wakes every client blocked on key
+// The wake channel holds one signal, so a client that was already woken
+// isn't waited for. The caller must hold LISTsMu for writing
+func signalListWaitersLocked(key string) {
+    for w := range listWaiters[key] {
+        select {
+        case w.wake <- struct{}{}:
+        default:
+        }
+    }
+}
+
+// unregisterListWait removes w from the waiters of each of its keys
+func unregisterListWait(w *listWait) {
+    LISTsMu.Lock()
+    for _, key := range w.keys {
+        delete(listWaiters[key], w)
+        if len(listWaiters[key]) == 0 {
+            delete(listWaiters, key)
+        }
+    }
+    LISTsMu.Unlock()
+}
+
+// blockingPop pops one element from the head (left) or tail of the first
+// non-empty list among the keys in args, followed by a timeout in seconds
+// It is shared by BLPOP and BRPOP and replies with [key, element]. If every
+// list is empty it sets c.blocked and returns no reply; the connection
+// handler then waits and runs the command again, see serveBlocked
+func blockingPop(c *Client, args []Value, left bool, event string) Value {
+    timeout, err := strconv.ParseFloat(args[len(args)-1].bulk, 64)
+    if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+        return Value{typ: "error", str: "ERR timeout is not a float or out of range"}
+    }
+    if timeout < 0 {
+        return Value{typ: "error", str: "ERR timeout is negative"}
+    }
+
+    keys := make([]string, 0, len(args)-1)
+    for _, arg := range args[:len(args)-1] {
+        keys = append(keys, arg.bulk)
+        expireIfNeeded(arg.bulk)
+    }
+
+    // Every store is locked so a key of another type is reported, not skipped
+    lockAllStores()
+    for _, key := range keys {
+        if wrongTypeLocked(key, "list") {
+            unlockAllStores()
+            return wrongTypeError
+        }
+        list, ok := LISTs[key]
+        if !ok {
+            continue
+        }
+
+        popped, removed := popLocked(key, list, 1, left)
+        unlockAllStores()
+
+        if !removed {
+            touchKey(key)
+        }
+        notifyKeyspaceEvent(notifyList, event, key)
+        if removed {
+            notifyKeyspaceEvent(notifyGeneric, "del", key)
+        }
+        return Value{typ: "array", array: []Value{
+            {typ: "bulk", bulk: key},
+            {typ: "bulk", bulk: popped[0]},
+        }}
+    }
+
+    // Replaying the AOF, applying the master's writes and running a
+    // transaction can't wait for another client, so like Redis they time out at once
+    if c.aof == nil || c.master || c.inExec {
+        unlockAllStores()
+        return Value{typ: "null_array"}
+    }
+
+    w := &listWait{keys: keys, wake: make(chan struct{}, 1)}
+    if timeout > 0 {
+        w.deadline = time.Now().Add(time.Duration(timeout * float64(time.Second)))
+    }
+    for _, key := range keys {
+        if listWaiters[key] == nil {
+            listWaiters[key] = map[*listWait]bool{}
+        }
+        listWaiters[key][w] = true
+    }
+    unlockAllStores()
+
+    c.blocked = w
+    return Value{}
+}
+
+// blpop implements the Redis BLPOP command
+// It pops from the head of the first non-empty list, waiting for one if needed
+// The command format is: BLPOP key [key ...] timeout
+func blpop(c *Client, args []Value) Value {
+    return blockingPop(c, args, true, "lpop")
+}
+
+// brpop implements the Redis BRPOP command
+// It pops from the tail of the first non-empty list, waiting for one if needed
+// The command format is: BRPOP key [key ...] timeout
+func brpop(c *Client, args []Value) Value {
+    return blockingPop(c, args, false, "rpop")
+}
+
+// serveBlocked waits for the command in value, which left c blocked, to be
+// able to finish, and returns its reply: the command is run again each time
+// it is woken, and a null array is the reply once its timeout passes
+// Another client may take the element first, in which case it waits again,
+// still until the original deadline. It returns false if the client
+// disconnected meanwhile
+func serveBlocked(c *Client, value Value, resp *Resp) (Value, bool) {
+    deadline := c.blocked.deadline
+    for c.blocked != nil {
+        w := c.blocked
+        c.blocked = nil
+
+        woken, gone := waitForWake(c, w, deadline, resp)
+        unregisterListWait(w)
+        if gone {
+            return Value{}, false
+        }
+        if !woken {
+            return Value{typ: "null_array"}, true
+        }
+
+        result := dispatch(c, value)
+        if c.blocked == nil {
+            return result, true
+        }
+    }
+    return Value{}, true
+}
+
+// waitForWake waits until w is woken or deadline passes, reporting which,
+// and watches the connection meanwhile so a client that hangs up is noticed
+// Peeking consumes nothing, so commands pipelined behind the blocked one are
+// read afterwards, in order
+func waitForWake(c *Client, w *listWait, deadline time.Time, resp *Resp) (woken, gone bool) {
+    var timeout <-chan time.Time
+    if !deadline.IsZero() {
+        timer := time.NewTimer(time.Until(deadline))
+        defer timer.Stop()
+        timeout = timer.C
+    }
+
+    // A blocked client is waiting, not idle, so the idle timeout doesn't apply
+    c.conn.SetReadDeadline(time.Time{})
+    peeked := make(chan error, 1)
+    go func() {
+        _, err := resp.reader.Peek(1)
+        peeked <- err
+    }()
+
+    // Stop the peek before returning, so only one goroutine reads at a time
+    defer func() {
+        if peeked != nil {
+            c.conn.SetReadDeadline(time.Now())
+            <-peeked
+        }
+    }()
+
+    for {
+        select {
+        case <-w.wake:
+            return true, false
+        case <-timeout:
+            return false, false
+        case err := <-peeked:
+            peeked = nil
+            if err != nil {
+                return false, true
+            }
+            // More commands arrived; they wait their turn
+        }
+    }
+}
@@ -0,0 +1,358 @@
+// Package server implements per-connection client state
+// Most commands only touch the shared data stores, but some (AUTH, SUBSCRIBE, ...)
+// change the state of the connection that sent them, so they need the client too
+package server
+
+import (
+    "net"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Client holds the state of one client connection
+type Client struct {
+    id            int64            // Unique id, assigned in connection order
+    conn          net.Conn
+    writer        *Writer          // Replies and pushed messages go through this writer
+    authenticated bool             // Whether the client may run commands
+    channels      map[string]bool  // Pub/sub channels this client is subscribed to
+    patterns      map[string]bool  // Pub/sub channel patterns this client is subscribed to
+    pushes        *pushQueue       // Published messages and invalidations waiting to be written, protected by pubsubMu
+    name          string           // Set with CLIENT SETNAME, protected by clientsMu
+    connectedAt   time.Time        // When the connection was accepted
+    lastActive    atomic.Int64     // Unix nanoseconds of the last command
+    aof           *Aof             // Where this client's writes are logged, nil during AOF replay
+
+    // Transaction state, only touched by the client's own connection handler
+    inMulti      bool              // Between MULTI and EXEC/DISCARD
+    queued       []queuedCommand   // Commands queued for EXEC
+    multiAborted bool              // A command couldn't be queued, so EXEC must refuse
+    watched      map[string]uint64 // Watched keys and their versions at WATCH time
+    inExec       bool              // EXEC is running the queued commands
+
+    // blocked is set by BLPOP and BRPOP when there was nothing to pop, see
+    // blocking.go. Only touched by the client's own connection handler
+    blocked *listWait
+
+    // Client-side caching state, see tracking.go
+    tracking    bool            // CLIENT TRACKING is on, only touched by the client's own connection handler
+    trackedKeys map[string]bool // Keys read since their last invalidation, protected by trackingMu
+
+    // closeAfterReply makes the connection handler hang up once the reply
+    // to the current command has been written
+    closeAfterReply bool
+
+    // Replication state, see replication.go
+    master      bool // This is our link to our master, whose writes we apply
+    replica     bool // Set by PSYNC: the connection now carries the replication stream
+    replicaPort int  // Port the replica listens on, from REPLCONF listening-port
+}
+
+// serverVersion is the Redis version we report to clients
+// Clients use it to decide which commands and reply formats they can rely on
+const serverVersion = "7.0.0"
+
+// clients is the registry of every connected client, keyed by id
+var clients = map[int64]*Client{}
+
+// clientsMu protects the clients registry and the name of each client
+var clientsMu = sync.RWMutex{}
+
+// connectedClients counts the open client connections
+// serve increments it when accepting a connection and handleConnection
+// decrements it on the way out, so maxclients can be enforced before the
+// connection gets a goroutine of its own
+var connectedClients atomic.Int64
+
+// nextClientID is the id given to the next client that connects
+var nextClientID atomic.Int64
+
+// NewClient creates the state for a freshly accepted connection
+// Write commands the client runs are logged to aof
+func NewClient(conn net.Conn, aof *Aof) *Client {
+    return &Client{
+        id:          nextClientID.Add(1),
+        conn:        conn,
+        writer:      NewWriter(conn),
+        connectedAt: time.Now(),
+        aof:         aof,
+
+        // Without a requirepass every client starts out authenticated, and like
+        // Redis, setting a password later doesn't log out existing connections
+        authenticated: config.RequirePass() == "",
+
+        channels: map[string]bool{},
+        patterns: map[string]bool{},
+
+        trackedKeys: map[string]bool{},
+    }
+}
+
+// registerClient adds a connected client to the registry
+func registerClient(c *Client) {
+    c.lastActive.Store(time.Now().UnixNano())
+
+    clientsMu.Lock()
+    clients[c.id] = c
+    clientsMu.Unlock()
+}
+
+// unregisterClient removes a disconnected client from the registry
+func unregisterClient(c *Client) {
+    clientsMu.Lock()
+    delete(clients, c.id)
+    clientsMu.Unlock()
+}
+
+// info formats the client the way CLIENT LIST prints it
+// The caller must hold clientsMu
+func (c *Client) info() string {
+    now := time.Now()
+    idle := now.Sub(time.Unix(0, c.lastActive.Load()))
+
+    pubsubMu.RLock()
+    subscriptions := len(c.channels)
+    patterns := len(c.patterns)
+    pubsubMu.RUnlock()
+
+    return "id=" + strconv.FormatInt(c.id, 10) +
+        " addr=" + c.conn.RemoteAddr().String() +
+        " laddr=" + c.conn.LocalAddr().String() +
+        " name=" + c.name +
+        " age=" + strconv.Itoa(int(now.Sub(c.connectedAt)/time.Second)) +
+        " idle=" + strconv.Itoa(int(idle/time.Second)) +
+        " sub=" + strconv.Itoa(subscriptions) +
+        " psub=" + strconv.Itoa(patterns) +
+        " resp=" + strconv.Itoa(c.writer.Protocol())
+}
+
+// validClientName reports whether name may be used as a client name
+// Names show up in the space-separated CLIENT LIST output, so only
+// printable characters other than space are allowed
+func validClientName(name string) bool {
+    for i := 0; i < len(name); i++ {
+        if name[i] <= ' ' || name[i] > '~' {
+            return false
+        }
+    }
+    return true
+}
+
+// auth implements the Redis AUTH command
+// It authenticates the connection against the requirepass config parameter
+// The command format is: AUTH password
+func auth(c *Client, args []Value) Value {
+    if config.RequirePass() == "" {
+        return Value{typ: "error", str: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
+    }
+    if args[0].bulk != config.RequirePass() {
+        return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+    }
+
+    c.authenticated = true
+    return Value{typ: "string", str: "OK"}
+}
+
+// quit implements the Redis QUIT command
+// It replies OK and then closes the connection
+// The command format is: QUIT
+func quit(c *Client, args []Value) Value {
+    c.closeAfterReply = true
+    return Value{typ: "string", str: "OK"}
+}
+
+// hello implements the Redis HELLO command
+// It switches the connection to protocol version protover and returns a map
+// describing the server, optionally authenticating and naming the client first
+// The command format is: HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(c *Client, args []Value) Value {
+    proto := c.writer.Protocol()
+    if len(args) > 0 {
+        n, err := strconv.Atoi(args[0].bulk)
+        if err != nil {
+            return Value{typ: "error", str: "ERR Protocol version is not an integer or out of range"}
+        }
+        if n != 2 && n != 3 {
+            return Value{typ: "error", str: "NOPROTO unsupported protocol version"}
+        }
+        proto = n
+    }
+
+    // Parse the options before acting on any of them, so a syntax error changes nothing
+    var password, name string
+    hasAuth, hasName := false, false
+    for i := 1; i < len(args); i++ {
+        switch option := strings.ToUpper(args[i].bulk); {
+        case option == "AUTH" && i+2 < len(args):
+            // Only the default user exists, as configured by requirepass
+            if args[i+1].bulk != "default" {
+                return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+            }
+            password, hasAuth = args[i+2].bulk, true
+            i += 2
+        case option == "SETNAME" && i+1 < len(args):
+            name, hasName = args[i+1].bulk, true
+            i++
+        default:
+            return Value{typ: "error", str: "ERR Syntax error in HELLO option '" + args[i].bulk + "'"}
+        }
+    }
+
+    if hasAuth {
+        if reply := auth(c, []Value{{typ: "bulk", bulk: password}}); reply.typ == "error" {
+            return reply
+        }
+    }
+    if !c.authenticated {
+        return Value{typ: "error", str: "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"}
+    }
+    if hasName {
+        if !validClientName(name) {
+            return Value{typ: "error", str: "ERR Client names cannot contain spaces, newlines or special characters."}
+        }
+        clientsMu.Lock()
+        c.name = name
+        clientsMu.Unlock()
+    }
+
+    // The reply itself is already written in the new protocol
+    c.writer.SetProtocol(proto)
+
+    return Value{typ: "map", array: []Value{
+        {typ: "bulk", bulk: "server"}, {typ: "bulk", bulk: "redis"},
+        {typ: "bulk", bulk: "version"}, {typ: "bulk", bulk: serverVersion},
+        {typ: "bulk", bulk: "proto"}, {typ: "integer", num: proto},
+        {typ: "bulk", bulk: "id"}, {typ: "integer", num: int(c.id)},
+        {typ: "bulk", bulk: "mode"}, {typ: "bulk", bulk: "standalone"},
+        {typ: "bulk", bulk: "role"}, {typ: "bulk", bulk: "master"},
+        {typ: "bulk", bulk: "modules"}, {typ: "array", array: []Value{}},
+    }}
+}
+
+// clientCommand implements the Redis CLIENT command family
+// The command format is: CLIENT <subcommand> [arguments ...]
+// Supported subcommands:
+//   CLIENT LIST          - one line per connected client
+//   CLIENT GETNAME       - the name of this connection, or null
+//   CLIENT SETNAME name  - name this connection (an empty name clears it)
+//   CLIENT KILL addr     - disconnect the client at addr
+//   CLIENT KILL [ID id] [ADDR addr] [SKIPME yes|no] - disconnect every matching client
+//   CLIENT TRACKING ON|OFF - push invalidations for keys this connection reads
+func clientCommand(c *Client, args []Value) Value {
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch {
+    case subcommand == "LIST" && len(args) == 1:
+        clientsMu.RLock()
+        ids := make([]int64, 0, len(clients))
+        for id := range clients {
+            ids = append(ids, id)
+        }
+        sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+        var b strings.Builder
+        for _, id := range ids {
+            b.WriteString(clients[id].info())
+            b.WriteByte('\n')
+        }
+        clientsMu.RUnlock()
+
+        return Value{typ: "bulk", bulk: b.String()}
+    case subcommand == "GETNAME" && len(args) == 1:
+        clientsMu.RLock()
+        name := c.name
+        clientsMu.RUnlock()
+
+        if name == "" {
+            return Value{typ: "null"}
+        }
+        return Value{typ: "bulk", bulk: name}
+    case subcommand == "SETNAME" && len(args) == 2:
+        name := args[1].bulk
+        if !validClientName(name) {
+            return Value{typ: "error", str: "ERR Client names cannot contain spaces, newlines or special characters."}
+        }
+
+        clientsMu.Lock()
+        c.name = name
+        clientsMu.Unlock()
+
+        return Value{typ: "string", str: "OK"}
+    case subcommand == "KILL" && len(args) == 2:
+        // Old form: a single address, answered with OK or an error
+        if clientKill(c, 0, args[1].bulk, false) == 0 {
+            return Value{typ: "error", str: "ERR No such client"}
+        }
+        return Value{typ: "string", str: "OK"}
+    case subcommand == "KILL" && len(args) > 2 && len(args)%2 == 1:
+        // New form: filter/value pairs, answered with the number of clients killed
+        id := int64(0)
+        addr := ""
+        skipMe := true
+        for i := 1; i < len(args); i += 2 {
+            value := args[i+1].bulk
+            switch strings.ToUpper(args[i].bulk) {
+            case "ID":
+                n, err := strconv.ParseInt(value, 10, 64)
+                if err != nil || n <= 0 {
+                    return Value{typ: "error", str: "ERR client-id should be greater than 0"}
+                }
+                id = n
+            case "ADDR":
+                addr = value
+            case "SKIPME":
+                switch strings.ToLower(value) {
+                case "yes":
+                    skipMe = true
+                case "no":
+                    skipMe = false
+                default:
+                    return Value{typ: "error", str: "ERR syntax error"}
+                }
+            default:
+                return Value{typ: "error", str: "ERR syntax error"}
+            }
+        }
+        return Value{typ: "integer", num: clientKill(c, id, addr, skipMe)}
+    case subcommand == "TRACKING" && len(args) >= 2:
+        return clientTracking(c, args[1:])
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try CLIENT HELP."}
+    }
+}
+
+// clientKill disconnects every client matching id and addr (a zero id or empty
+// addr matches anything), skipping the calling client when skipMe is set
+// Closing the connection interrupts the victim's blocked read, so its own
+// handler exits and removes it from the registry
+// It returns the number of clients killed
+func clientKill(caller *Client, id int64, addr string, skipMe bool) int {
+    clientsMu.RLock()
+    victims := []*Client{}
+    for _, c := range clients {
+        if id != 0 && c.id != id {
+            continue
+        }
+        if addr != "" && c.conn.RemoteAddr().String() != addr {
+            continue
+        }
+        if skipMe && c == caller {
+            continue
+        }
+        victims = append(victims, c)
+    }
+    clientsMu.RUnlock()
+
+    for _, c := range victims {
+        // A client killing itself still gets its reply before we hang up
+        if c == caller {
+            c.closeAfterReply = true
+            continue
+        }
+        c.conn.Close()
+    }
+    return len(victims)
+}
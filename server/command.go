@@ -0,0 +1,94 @@
+// Package server implements the COMMAND introspection command
+// Client libraries call it on connect to learn which commands the server
+// knows, so the reply is built from the Handlers registry
+package server
+
+import (
+    "sort"
+    "strings"
+)
+
+// commandInfos holds the COMMAND INFO reply for each command, by upper-case name
+// Like commandCalls, it is built from Handlers once at startup
+var commandInfos = map[string]Value{}
+
+// commandNames lists every command in alphabetical order, so COMMAND's reply is stable
+var commandNames []string
+
+func init() {
+    for name, cmd := range Handlers {
+        commandInfos[name] = commandInfo(name, cmd)
+        commandNames = append(commandNames, name)
+    }
+    sort.Strings(commandNames)
+}
+
+// commandInfo describes one command the way Redis 7 does in COMMAND
+// Key positions, ACL categories, tips, key specs and subcommands aren't tracked,
+// so they are reported as no keys and empty arrays
+func commandInfo(name string, cmd Command) Value {
+    flags := []Value{}
+    if cmd.write {
+        flags = append(flags, Value{typ: "string", str: "write"})
+    }
+    if denyOOMCommands[name] {
+        flags = append(flags, Value{typ: "string", str: "denyoom"})
+    }
+    if name == "AUTH" || name == "HELLO" || name == "QUIT" {
+        flags = append(flags, Value{typ: "string", str: "no-auth"})
+    }
+
+    return Value{typ: "array", array: []Value{
+        {typ: "bulk", bulk: strings.ToLower(name)},
+        {typ: "integer", num: cmd.arity()},
+        {typ: "array", array: flags},
+        {typ: "integer", num: 0},         // First key
+        {typ: "integer", num: 0},         // Last key
+        {typ: "integer", num: 0},         // Key step
+        {typ: "array", array: []Value{}}, // ACL categories
+        {typ: "array", array: []Value{}}, // Tips
+        {typ: "array", array: []Value{}}, // Key specs
+        {typ: "array", array: []Value{}}, // Subcommands
+    }}
+}
+
+// command implements the Redis COMMAND command family
+// The command format is: COMMAND [subcommand [arguments ...]]
+// Supported forms:
+//   COMMAND                      - describe every command
+//   COMMAND COUNT                - the number of commands
+//   COMMAND INFO name [name ...] - describe the given commands, null for unknown ones
+//   COMMAND DOCS [name ...]      - command docs; none are kept, so always empty
+func command(c *Client, args []Value) Value {
+    if len(args) == 0 {
+        infos := make([]Value, 0, len(commandNames))
+        for _, name := range commandNames {
+            infos = append(infos, commandInfos[name])
+        }
+        return Value{typ: "array", array: infos}
+    }
+
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch {
+    case subcommand == "COUNT" && len(args) == 1:
+        return Value{typ: "integer", num: len(commandNames)}
+    case subcommand == "INFO":
+        // Without names, INFO describes every command, like plain COMMAND
+        if len(args) == 1 {
+            return command(c, nil)
+        }
+        infos := make([]Value, 0, len(args)-1)
+        for _, arg := range args[1:] {
+            info, ok := commandInfos[strings.ToUpper(arg.bulk)]
+            if !ok {
+                info = Value{typ: "null_array"}
+            }
+            infos = append(infos, info)
+        }
+        return Value{typ: "array", array: infos}
+    case subcommand == "DOCS":
+        return Value{typ: "map", array: []Value{}}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try COMMAND HELP."}
+    }
+}
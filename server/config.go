@@ -0,0 +1,517 @@
+// Package server implements runtime configuration (CONFIG GET / CONFIG SET)
+// Parameters live in a single Config struct that the rest of the server reads
+// while running, so a CONFIG SET takes effect without a restart
+package server
+
+import (
+    "errors"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Config holds the server parameters that can be changed at runtime
+type Config struct {
+    mu          sync.RWMutex
+    appendfsync string  // AOF fsync policy: "always", "everysec" or "no"
+    maxmemory   int64   // Memory limit in bytes, 0 means unlimited
+    maxmemoryPolicy string  // What to do when maxmemory is reached: "noeviction", "allkeys-lru" or "allkeys-random"
+    timeout     int     // Close client connections idle for this many seconds, 0 disables
+    requirepass string  // Password clients must send with AUTH, empty disables
+    slowlogLogSlowerThan int  // Log commands slower than this many microseconds, negative disables
+    slowlogMaxLen        int  // Number of entries kept in the slow log
+    notifyKeyspaceEvents int  // Enabled keyspace notification classes (see notify.go)
+    maxclients           int  // Connections beyond this many are refused
+    autoAofRewritePercentage int    // Rewrite the AOF once it grows by this much, 0 disables
+    autoAofRewriteMinSize    int64  // ... but only if it is at least this many bytes
+    hz                       int    // Active expire cycles per second
+    activeExpireSamples      int    // Keys with an expiry sampled per active expire pass
+    pubsubOutputLimits       outputLimits // When to drop a subscriber that falls behind
+    hashPreserveOrder        bool   // Return hash fields in the order they were added
+    stopWritesOnPersistenceError bool // Refuse writes while the AOF can't be written
+}
+
+// outputLimits bounds how much may be queued for a client before it is
+// disconnected: the hard limit at once, the soft limit once it has been
+// exceeded for longer than softDuration. A limit of 0 disables it
+type outputLimits struct {
+    hard         int64
+    soft         int64
+    softDuration time.Duration
+}
+
+// config is the live server configuration
+var config = &Config{
+    appendfsync:          "everysec",
+    maxmemoryPolicy:      "noeviction",
+    slowlogLogSlowerThan: 10000,
+    slowlogMaxLen:        128,
+    maxclients:           10000,
+    autoAofRewritePercentage: 100,
+    autoAofRewriteMinSize:    64 * 1024 * 1024,
+    hz:                       10,
+    activeExpireSamples:      20,
+    pubsubOutputLimits:       outputLimits{32 * 1024 * 1024, 8 * 1024 * 1024, 60 * time.Second},
+    stopWritesOnPersistenceError: true,
+}
+
+// AppendFsync returns the current AOF fsync policy
+func (c *Config) AppendFsync() string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.appendfsync
+}
+
+// MaxMemory returns the memory limit in bytes (0 means unlimited)
+func (c *Config) MaxMemory() int64 {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.maxmemory
+}
+
+// MaxMemoryPolicy returns the eviction policy used when maxmemory is reached
+func (c *Config) MaxMemoryPolicy() string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.maxmemoryPolicy
+}
+
+// Timeout returns the client idle timeout in seconds (0 means never)
+func (c *Config) Timeout() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.timeout
+}
+
+// RequirePass returns the configured password (empty means none)
+func (c *Config) RequirePass() string {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.requirepass
+}
+
+// SlowlogLogSlowerThan returns the slow log threshold in microseconds
+func (c *Config) SlowlogLogSlowerThan() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.slowlogLogSlowerThan
+}
+
+// SlowlogMaxLen returns the number of entries kept in the slow log
+func (c *Config) SlowlogMaxLen() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.slowlogMaxLen
+}
+
+// NotifyKeyspaceEvents returns the enabled keyspace notification classes
+func (c *Config) NotifyKeyspaceEvents() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.notifyKeyspaceEvents
+}
+
+// MaxClients returns the maximum number of connected clients
+func (c *Config) MaxClients() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.maxclients
+}
+
+// AutoAofRewritePercentage returns the AOF growth, in percent of its size after
+// the last rewrite, that triggers an automatic rewrite (0 means never)
+func (c *Config) AutoAofRewritePercentage() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.autoAofRewritePercentage
+}
+
+// AutoAofRewriteMinSize returns the size in bytes the AOF must reach before
+// it is rewritten automatically
+func (c *Config) AutoAofRewriteMinSize() int64 {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.autoAofRewriteMinSize
+}
+
+// Hz returns how many times per second the active expire cycle runs
+func (c *Config) Hz() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.hz
+}
+
+// ActiveExpireSamples returns how many keys with an expiry each active expire
+// pass looks at
+func (c *Config) ActiveExpireSamples() int {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.activeExpireSamples
+}
+
+// PubsubOutputLimits returns the output buffer limits of pub/sub subscribers
+func (c *Config) PubsubOutputLimits() outputLimits {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.pubsubOutputLimits
+}
+
+// HashPreserveOrder reports whether hash fields are kept in insertion order
+func (c *Config) HashPreserveOrder() bool {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.hashPreserveOrder
+}
+
+// StopWritesOnPersistenceError reports whether writes are refused while the AOF is failing
+func (c *Config) StopWritesOnPersistenceError() bool {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    return c.stopWritesOnPersistenceError
+}
+
+// Set validates and stores a new value for the named parameter
+// It is used both by CONFIG SET and by Options.Config when a Server is created
+func (c *Config) Set(name, value string) error {
+    param, ok := configParams[name]
+    if !ok {
+        return errUnknownConfigParam
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return param.set(c, value)
+}
+
+// errUnknownConfigParam is returned by Set for a parameter that isn't in the whitelist
+var errUnknownConfigParam = errors.New("unsupported parameter")
+
+// errInvalidConfigValue is returned by a parameter's setter when the value is rejected
+var errInvalidConfigValue = errors.New("invalid value")
+
+// parseYesNo parses the value of a yes/no parameter
+func parseYesNo(value string) (bool, error) {
+    switch strings.ToLower(value) {
+    case "yes":
+        return true, nil
+    case "no":
+        return false, nil
+    default:
+        return false, errInvalidConfigValue
+    }
+}
+
+// formatYesNo formats the value of a yes/no parameter
+func formatYesNo(b bool) string {
+    if b {
+        return "yes"
+    }
+    return "no"
+}
+
+// configParam describes one parameter exposed through CONFIG GET / CONFIG SET
+// get formats the current value, set parses and stores a new one
+// Both are called with config.mu held
+type configParam struct {
+    get func(c *Config) string
+    set func(c *Config, value string) error
+}
+
+// configParams is the whitelist of parameters CONFIG knows about
+var configParams = map[string]configParam{
+    "appendfsync": {
+        get: func(c *Config) string { return c.appendfsync },
+        set: func(c *Config, value string) error {
+            value = strings.ToLower(value)
+            if value != "always" && value != "everysec" && value != "no" {
+                return errInvalidConfigValue
+            }
+            c.appendfsync = value
+            return nil
+        },
+    },
+    "auto-aof-rewrite-percentage": {
+        get: func(c *Config) string { return strconv.Itoa(c.autoAofRewritePercentage) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 0 {
+                return errInvalidConfigValue
+            }
+            c.autoAofRewritePercentage = n
+            return nil
+        },
+    },
+    "auto-aof-rewrite-min-size": {
+        get: func(c *Config) string { return strconv.FormatInt(c.autoAofRewriteMinSize, 10) },
+        set: func(c *Config, value string) error {
+            n, err := parseMemory(value)
+            if err != nil {
+                return err
+            }
+            c.autoAofRewriteMinSize = n
+            return nil
+        },
+    },
+    "hz": {
+        get: func(c *Config) string { return strconv.Itoa(c.hz) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 || n > 500 {
+                return errInvalidConfigValue
+            }
+            c.hz = n
+            return nil
+        },
+    },
+    "active-expire-samples": {
+        get: func(c *Config) string { return strconv.Itoa(c.activeExpireSamples) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                return errInvalidConfigValue
+            }
+            c.activeExpireSamples = n
+            return nil
+        },
+    },
+    "client-output-buffer-limit": {
+        // Redis's format: one "class hard soft seconds" group per client class
+        // Only the pubsub class is enforced; normal and replica are accepted so
+        // existing configurations still load
+        get: func(c *Config) string {
+            l := c.pubsubOutputLimits
+            return "pubsub " + strconv.FormatInt(l.hard, 10) + " " + strconv.FormatInt(l.soft, 10) + " " + strconv.Itoa(int(l.softDuration/time.Second))
+        },
+        set: func(c *Config, value string) error {
+            fields := strings.Fields(value)
+            if len(fields) == 0 || len(fields)%4 != 0 {
+                return errInvalidConfigValue
+            }
+            limits := c.pubsubOutputLimits
+            for i := 0; i < len(fields); i += 4 {
+                hard, err1 := parseMemory(fields[i+1])
+                soft, err2 := parseMemory(fields[i+2])
+                seconds, err3 := strconv.Atoi(fields[i+3])
+                if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+                    return errInvalidConfigValue
+                }
+                switch strings.ToLower(fields[i]) {
+                case "pubsub":
+                    limits = outputLimits{hard, soft, time.Duration(seconds) * time.Second}
+                case "normal", "replica", "slave":
+                default:
+                    return errInvalidConfigValue
+                }
+            }
+            c.pubsubOutputLimits = limits
+            return nil
+        },
+    },
+    "hash-preserve-order": {
+        get: func(c *Config) string { return formatYesNo(c.hashPreserveOrder) },
+        set: func(c *Config, value string) error {
+            b, err := parseYesNo(value)
+            if err != nil {
+                return err
+            }
+            c.hashPreserveOrder = b
+            return nil
+        },
+    },
+    "stop-writes-on-persistence-error": {
+        get: func(c *Config) string { return formatYesNo(c.stopWritesOnPersistenceError) },
+        set: func(c *Config, value string) error {
+            b, err := parseYesNo(value)
+            if err != nil {
+                return err
+            }
+            c.stopWritesOnPersistenceError = b
+            return nil
+        },
+    },
+    "maxmemory": {
+        get: func(c *Config) string { return strconv.FormatInt(c.maxmemory, 10) },
+        set: func(c *Config, value string) error {
+            n, err := parseMemory(value)
+            if err != nil {
+                return err
+            }
+            c.maxmemory = n
+            return nil
+        },
+    },
+    "maxmemory-policy": {
+        get: func(c *Config) string { return c.maxmemoryPolicy },
+        set: func(c *Config, value string) error {
+            value = strings.ToLower(value)
+            if value != "noeviction" && value != "allkeys-lru" && value != "allkeys-random" {
+                return errInvalidConfigValue
+            }
+            c.maxmemoryPolicy = value
+            return nil
+        },
+    },
+    "slowlog-log-slower-than": {
+        get: func(c *Config) string { return strconv.Itoa(c.slowlogLogSlowerThan) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil {
+                return errInvalidConfigValue
+            }
+            c.slowlogLogSlowerThan = n
+            return nil
+        },
+    },
+    "slowlog-max-len": {
+        get: func(c *Config) string { return strconv.Itoa(c.slowlogMaxLen) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 0 {
+                return errInvalidConfigValue
+            }
+            c.slowlogMaxLen = n
+            return nil
+        },
+    },
+    "timeout": {
+        get: func(c *Config) string { return strconv.Itoa(c.timeout) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 0 {
+                return errInvalidConfigValue
+            }
+            c.timeout = n
+            return nil
+        },
+    },
+    "notify-keyspace-events": {
+        get: func(c *Config) string { return formatKeyspaceEvents(c.notifyKeyspaceEvents) },
+        set: func(c *Config, value string) error {
+            flags, err := parseKeyspaceEvents(value)
+            if err != nil {
+                return errInvalidConfigValue
+            }
+            c.notifyKeyspaceEvents = flags
+            return nil
+        },
+    },
+    "maxclients": {
+        get: func(c *Config) string { return strconv.Itoa(c.maxclients) },
+        set: func(c *Config, value string) error {
+            n, err := strconv.Atoi(value)
+            if err != nil || n < 1 {
+                return errInvalidConfigValue
+            }
+            c.maxclients = n
+            return nil
+        },
+    },
+    "requirepass": {
+        get: func(c *Config) string { return c.requirepass },
+        set: func(c *Config, value string) error {
+            c.requirepass = value
+            return nil
+        },
+    },
+}
+
+// parseMemory parses a memory size such as "1024", "100kb", "64mb" or "1gb"
+// Units are case-insensitive and use powers of 1024, like Redis
+func parseMemory(s string) (int64, error) {
+    units := []struct {
+        suffix string
+        mult   int64
+    }{
+        {"gb", 1024 * 1024 * 1024},
+        {"mb", 1024 * 1024},
+        {"kb", 1024},
+        {"b", 1},
+    }
+
+    s = strings.ToLower(s)
+    mult := int64(1)
+    for _, unit := range units {
+        if strings.HasSuffix(s, unit.suffix) {
+            s = strings.TrimSuffix(s, unit.suffix)
+            mult = unit.mult
+            break
+        }
+    }
+
+    // A value too big for an int64 once scaled would wrap around to a negative limit
+    n, err := strconv.ParseInt(s, 10, 64)
+    if err != nil || n < 0 || n > math.MaxInt64/mult {
+        return 0, errInvalidConfigValue
+    }
+    return n * mult, nil
+}
+
+// configCommand implements the Redis CONFIG command family
+// The command format is: CONFIG <subcommand> [arguments ...]
+// Supported subcommands:
+//   CONFIG GET parameter        - returns [name, value] pairs for matching parameters
+//   CONFIG SET parameter value  - changes a parameter at runtime
+//   CONFIG RESETSTAT            - zeroes the statistics reported by INFO
+func configCommand(c *Client, args []Value) Value {
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch subcommand {
+    case "GET":
+        if len(args) != 2 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'config|get' command"}
+        }
+        return configGet(strings.ToLower(args[1].bulk))
+    case "SET":
+        if len(args) != 3 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'config|set' command"}
+        }
+        return configSet(strings.ToLower(args[1].bulk), args[2].bulk)
+    case "RESETSTAT":
+        if len(args) != 1 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'config|resetstat' command"}
+        }
+        resetStats()
+        return Value{typ: "string", str: "OK"}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try CONFIG HELP."}
+    }
+}
+
+// configGet returns a flat [name, value, ...] array of every parameter matching pattern
+// Unknown parameters simply produce an empty array, matching Redis
+func configGet(pattern string) Value {
+    config.mu.RLock()
+    defer config.mu.RUnlock()
+
+    // Walk the parameters in a stable order
+    names := make([]string, 0, len(configParams))
+    for name := range configParams {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    values := []Value{}
+    for _, name := range names {
+        if matchPattern(pattern, name) {
+            values = append(values, Value{typ: "bulk", bulk: name})
+            values = append(values, Value{typ: "bulk", bulk: configParams[name].get(config)})
+        }
+    }
+
+    return Value{typ: "array", array: values}
+}
+
+// configSet validates and stores a new value for a parameter
+func configSet(name, value string) Value {
+    err := config.Set(name, value)
+    if err == errUnknownConfigParam {
+        return Value{typ: "error", str: "ERR Unsupported CONFIG parameter: " + name}
+    }
+    if err != nil {
+        return Value{typ: "error", str: "ERR Invalid argument '" + value + "' for CONFIG SET '" + name + "'"}
+    }
+
+    return Value{typ: "string", str: "OK"}
+}
@@ -0,0 +1,122 @@
+// Package server implements the DEBUG command
+// DEBUG exposes hooks that test suites use to put the server into specific
+// states, such as a slow command or a paused expiry sweeper
+package server
+
+import (
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// debugCommand implements the Redis DEBUG command family
+// The command format is: DEBUG <subcommand> [arguments ...]
+// Supported subcommands:
+//   DEBUG OBJECT key               - internal details of the value at key
+//   DEBUG SLEEP seconds            - block this connection, fractional seconds allowed
+//   DEBUG SET-ACTIVE-EXPIRE 0|1    - stop or restart the active expiry sweeper
+//   DEBUG RELOAD                   - rewrite the AOF, then rebuild the dataset from it
+//   DEBUG JMAP                     - accepted for compatibility, does nothing
+//   DEBUG QUICKLIST-PACKED-THRESHOLD size - accepted for compatibility, does nothing
+func debugCommand(c *Client, args []Value) Value {
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch {
+    case subcommand == "OBJECT" && len(args) == 2:
+        return debugObject(args[1].bulk)
+    case subcommand == "SLEEP" && len(args) == 2:
+        seconds, err := strconv.ParseFloat(args[1].bulk, 64)
+        if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+            return Value{typ: "error", str: "ERR value is not a valid float"}
+        }
+        if seconds > 0 {
+            time.Sleep(time.Duration(seconds * float64(time.Second)))
+        }
+        return Value{typ: "string", str: "OK"}
+    case subcommand == "SET-ACTIVE-EXPIRE" && len(args) == 2:
+        switch args[1].bulk {
+        case "0":
+            activeExpireEnabled.Store(false)
+        case "1":
+            activeExpireEnabled.Store(true)
+        default:
+            return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+        }
+        return Value{typ: "string", str: "OK"}
+    case subcommand == "RELOAD" && len(args) == 1:
+        return debugReload(c)
+    case subcommand == "JMAP" && len(args) == 1:
+        return Value{typ: "string", str: "OK"}
+    case subcommand == "QUICKLIST-PACKED-THRESHOLD" && len(args) == 2:
+        // Lists aren't stored as quicklists, so there is no threshold to change
+        if _, err := parseMemory(args[1].bulk); err != nil {
+            return Value{typ: "error", str: "ERR argument must be a memory value"}
+        }
+        return Value{typ: "string", str: "OK"}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try DEBUG HELP."}
+    }
+}
+
+// reloadAof is loadAof, assigned in init: loadAof replays through dispatch,
+// which looks commands up in Handlers, so a handler naming it directly would
+// make the initialization of Handlers depend on itself
+var reloadAof func(aof *Aof) error
+
+func init() {
+    reloadAof = loadAof
+}
+
+// debugReload implements DEBUG RELOAD
+// It rewrites the AOF from the dataset, empties the dataset and loads it back
+// from the new file, so a test can check that what it stored survives a
+// restart without restarting. dispatch runs it holding execMu for writing
+// (see runsAlone), so no other command sees the dataset half loaded
+func debugReload(c *Client) Value {
+    // Replaying the AOF has nothing to reload from yet
+    if c.aof == nil {
+        return Value{typ: "error", str: "ERR Append only file is not available"}
+    }
+
+    if err := c.aof.RewriteLocked(); err != nil {
+        return Value{typ: "error", str: "ERR Error trying to rewrite the AOF: " + err.Error()}
+    }
+
+    emptyDataset()
+
+    // The dataset is gone at this point, so a failure can't be undone; the
+    // file is intact though, so a restart would still load it
+    if err := reloadAof(c.aof); err != nil {
+        return Value{typ: "error", str: "ERR Error trying to load the AOF: " + err.Error()}
+    }
+    return Value{typ: "string", str: "OK"}
+}
+
+// debugObject describes the value at key as a line of name:value fields
+// serializedlength is the size of the key's DUMP payload, since we have no RDB
+// encoding to measure; the fields otherwise follow Redis where they make sense
+func debugObject(key string) Value {
+    encoding, ok := objectEncoding(key)
+    if !ok {
+        return Value{typ: "error", str: "ERR no such key"}
+    }
+    idle, _ := keyIdleTime(key)
+
+    rLockAllStores()
+    value, ok := lookupValueLocked(key)
+    var length int
+    if ok {
+        length = len(encodeDump(value))
+    }
+    rUnlockAllStores()
+
+    // The key may have been deleted since we looked up its encoding
+    if !ok {
+        return Value{typ: "error", str: "ERR no such key"}
+    }
+
+    return Value{typ: "string", str: "refcount:1" +
+        " encoding:" + encoding +
+        " serializedlength:" + strconv.Itoa(length) +
+        " lru_seconds_idle:" + strconv.Itoa(int(idle/time.Second))}
+}
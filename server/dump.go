@@ -0,0 +1,236 @@
+// Package server implements DUMP and RESTORE
+// DUMP turns one key's value into an opaque string that RESTORE, on this or
+// another server, turns back into a key. The format is our own, not Redis RDB:
+//
+//   version (1 byte) | RESP array: type, items... | CRC-32 of everything before (4 bytes)
+//
+// The items are the string value, field/value pairs, score/member pairs,
+// list elements or set members, depending on the type
+package server
+
+import (
+    "encoding/binary"
+    "errors"
+    "hash/crc32"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// dumpVersion is written at the start of every DUMP payload
+// Bump it when the format changes, so old payloads are rejected instead of misread
+const dumpVersion = 1
+
+// errBadDumpPayload is returned for a payload that isn't one DUMP produced
+var errBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// lookupValueLocked returns the value stored at key, whatever its type
+// The caller must hold at least the read lock of every store
+func lookupValueLocked(key string) (interface{}, bool) {
+    if value, ok := SETs[key]; ok {
+        return value, true
+    }
+    if value, ok := HSETs[key]; ok {
+        return value, true
+    }
+    if value, ok := ZSETs[key]; ok {
+        return value, true
+    }
+    if value, ok := LISTs[key]; ok {
+        return value, true
+    }
+    if value, ok := SSETs[key]; ok {
+        return value, true
+    }
+    return nil, false
+}
+
+// encodeDump serializes a stored value into a DUMP payload
+func encodeDump(value interface{}) []byte {
+    items := []string{}
+    switch value := value.(type) {
+    case string:
+        items = append(items, "string", value)
+    case map[string]string:
+        items = append(items, "hash")
+        for field, v := range value {
+            items = append(items, field, v)
+        }
+    case *SortedSet:
+        items = append(items, "zset")
+        for _, entry := range value.entries {
+            items = append(items, formatScore(entry.score), entry.member)
+        }
+    case []string:
+        items = append(items, "list")
+        items = append(items, value...)
+    case map[string]struct{}:
+        items = append(items, "set")
+        for member := range value {
+            items = append(items, member)
+        }
+    }
+
+    values := make([]Value, 0, len(items))
+    for _, item := range items {
+        values = append(values, Value{typ: "bulk", bulk: item})
+    }
+
+    payload := []byte{dumpVersion}
+    payload = append(payload, Value{typ: "array", array: values}.Marshal()...)
+    return binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(payload))
+}
+
+// decodeDump parses a DUMP payload back into a value ready to be stored
+func decodeDump(payload string) (interface{}, error) {
+    if len(payload) < 5 || payload[0] != dumpVersion {
+        return nil, errBadDumpPayload
+    }
+    body, sum := payload[:len(payload)-4], payload[len(payload)-4:]
+    if crc32.ChecksumIEEE([]byte(body)) != binary.BigEndian.Uint32([]byte(sum)) {
+        return nil, errBadDumpPayload
+    }
+
+    record, err := NewResp(strings.NewReader(body[1:])).Read()
+    if err != nil || record.typ != "array" || len(record.array) < 2 {
+        return nil, errBadDumpPayload
+    }
+    items := make([]string, 0, len(record.array)-1)
+    for _, v := range record.array[1:] {
+        items = append(items, v.bulk)
+    }
+
+    switch record.array[0].bulk {
+    case "string":
+        if len(items) != 1 {
+            return nil, errBadDumpPayload
+        }
+        return items[0], nil
+    case "hash":
+        if len(items)%2 != 0 {
+            return nil, errBadDumpPayload
+        }
+        fields := map[string]string{}
+        for i := 0; i < len(items); i += 2 {
+            fields[items[i]] = items[i+1]
+        }
+        return fields, nil
+    case "zset":
+        if len(items)%2 != 0 {
+            return nil, errBadDumpPayload
+        }
+        zset := NewSortedSet()
+        for i := 0; i < len(items); i += 2 {
+            score, ok := parseScore(items[i])
+            if !ok {
+                return nil, errBadDumpPayload
+            }
+            zset.Add(items[i+1], score)
+        }
+        return zset, nil
+    case "list":
+        return items, nil
+    case "set":
+        members := map[string]struct{}{}
+        for _, member := range items {
+            members[member] = struct{}{}
+        }
+        return members, nil
+    }
+    return nil, errBadDumpPayload
+}
+
+// storeValueLocked puts a decoded value into the store for its type
+// The caller must hold all store write locks and make sure key doesn't exist
+func storeValueLocked(key string, value interface{}) {
+    switch value := value.(type) {
+    case string:
+        SETs[key] = value
+    case map[string]string:
+        HSETs[key] = value
+    case *SortedSet:
+        ZSETs[key] = value
+    case []string:
+        LISTs[key] = value
+        signalListWaitersLocked(key)
+    case map[string]struct{}:
+        SSETs[key] = value
+    }
+    trackMemory(valueMemory(key, value))
+    indexKey(key)
+}
+
+// dump implements the Redis DUMP command
+// It returns the value at key serialized for RESTORE, or null if the key doesn't exist
+// The command format is: DUMP key
+func dump(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    rLockAllStores()
+    value, ok := lookupValueLocked(key)
+    var payload []byte
+    if ok {
+        payload = encodeDump(value)
+    }
+    rUnlockAllStores()
+
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(key)
+
+    return Value{typ: "bulk", bulk: string(payload)}
+}
+
+// restore implements the Redis RESTORE command
+// It creates key from a DUMP payload, expiring after ttl milliseconds (0 means never)
+// An existing key is only overwritten with REPLACE
+// The command format is: RESTORE key ttl serialized-value [REPLACE]
+func restore(c *Client, args []Value) Value {
+    key := args[0].bulk
+
+    ttl, err := strconv.ParseInt(args[1].bulk, 10, 64)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+    if ttl < 0 {
+        return Value{typ: "error", str: "ERR Invalid TTL value, must be >= 0"}
+    }
+
+    replace := false
+    for _, arg := range args[3:] {
+        if strings.ToUpper(arg.bulk) != "REPLACE" {
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+        replace = true
+    }
+
+    value, err := decodeDump(args[2].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR " + err.Error()}
+    }
+
+    expireIfNeeded(key)
+
+    lockAllStores()
+    if keyExistsLocked(key) {
+        if !replace {
+            unlockAllStores()
+            return Value{typ: "error", str: "BUSYKEY Target key name already exists."}
+        }
+        deleteKeyLocked(key)
+    }
+    storeValueLocked(key, value)
+    if ttl > 0 {
+        expirationsMu.Lock()
+        expirations[key] = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+        expirationsMu.Unlock()
+    }
+    unlockAllStores()
+
+    touchKey(key)
+    notifyKeyspaceEvent(notifyGeneric, "restore", key)
+
+    return Value{typ: "string", str: "OK"}
+}
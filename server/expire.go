@@ -0,0 +1,407 @@
+// Package server implements key expiration (EXPIRE / TTL and their variants)
+// Like Redis, a key has one type and at most one expiry, so expirations are
+// tracked in a single map shared by every data type
+package server
+
+import (
+    "math"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// expirations maps a key to the moment it expires
+// Keys without an entry live forever
+var expirations = map[string]time.Time{}
+
+// expirationsMu protects the expirations map
+// It is a leaf lock: it may be taken while holding store locks, but no
+// store lock may ever be acquired while holding it
+var expirationsMu = sync.Mutex{}
+
+// isExpired reports whether key has an expiry that is already in the past
+func isExpired(key string) bool {
+    expirationsMu.Lock()
+    defer expirationsMu.Unlock()
+
+    when, ok := expirations[key]
+    return ok && !time.Now().Before(when)
+}
+
+// clearExpiration removes any expiry set on key
+// The caller should hold the write lock of the store the key lives in
+func clearExpiration(key string) {
+    expirationsMu.Lock()
+    delete(expirations, key)
+    expirationsMu.Unlock()
+}
+
+// deleteKeyLocked removes key from every store and drops its expiry
+// The caller must hold all store write locks (see lockAllStores)
+// It returns true if the key existed in any store
+func deleteKeyLocked(key string) bool {
+    trackMemory(-keyMemoryLocked(key))
+    existed := unindexStoresLocked(key)
+    delete(SETs, key)
+    delete(HSETs, key)
+    delete(hashFieldOrder, key)
+    delete(ZSETs, key)
+    delete(LISTs, key)
+    delete(SSETs, key)
+    clearExpiration(key)
+    forgetKeyAccess(key)
+
+    return existed
+}
+
+// detachKeyLocked removes key from every store and drops its expiry, like
+// deleteKeyLocked, but hands back the removed values instead of accounting
+// for their memory. This lets UNLINK size and release big values later,
+// outside the store locks
+// The caller must hold all store write locks (see lockAllStores)
+func detachKeyLocked(key string) []interface{} {
+    values := []interface{}{}
+    if value, ok := SETs[key]; ok {
+        values = append(values, value)
+    }
+    if value, ok := HSETs[key]; ok {
+        values = append(values, value)
+    }
+    if value, ok := ZSETs[key]; ok {
+        values = append(values, value)
+    }
+    if value, ok := LISTs[key]; ok {
+        values = append(values, value)
+    }
+    if value, ok := SSETs[key]; ok {
+        values = append(values, value)
+    }
+
+    unindexStoresLocked(key)
+    delete(SETs, key)
+    delete(HSETs, key)
+    delete(hashFieldOrder, key)
+    delete(ZSETs, key)
+    delete(LISTs, key)
+    delete(SSETs, key)
+    clearExpiration(key)
+    forgetKeyAccess(key)
+
+    return values
+}
+
+// unindexStoresLocked takes key out of the SCAN index once for each store
+// holding it, before it is removed from all of them
+// The caller must hold all store write locks (see lockAllStores)
+// It returns true if the key existed in any store
+func unindexStoresLocked(key string) bool {
+    _, inSETs := SETs[key]
+    _, inHSETs := HSETs[key]
+    _, inZSETs := ZSETs[key]
+    _, inLISTs := LISTs[key]
+    _, inSSETs := SSETs[key]
+    existed := false
+    for _, stored := range []bool{inSETs, inHSETs, inZSETs, inLISTs, inSSETs} {
+        if stored {
+            unindexKey(key)
+            existed = true
+        }
+    }
+    return existed
+}
+
+// keyExistsLocked reports whether key exists in any store
+// The caller must hold at least the read lock of every store
+func keyExistsLocked(key string) bool {
+    if _, ok := SETs[key]; ok {
+        return true
+    }
+    if _, ok := HSETs[key]; ok {
+        return true
+    }
+    if _, ok := ZSETs[key]; ok {
+        return true
+    }
+    if _, ok := LISTs[key]; ok {
+        return true
+    }
+    if _, ok := SSETs[key]; ok {
+        return true
+    }
+    return false
+}
+
+// keyTypeLocked returns the type of the value at key, as TYPE names it:
+// "string", "hash", "zset", "list", "set", or "none" if the key doesn't exist
+// The caller must hold at least the read lock of every store
+func keyTypeLocked(key string) string {
+    if _, ok := SETs[key]; ok {
+        return "string"
+    }
+    if _, ok := HSETs[key]; ok {
+        return "hash"
+    }
+    if _, ok := ZSETs[key]; ok {
+        return "zset"
+    }
+    if _, ok := LISTs[key]; ok {
+        return "list"
+    }
+    if _, ok := SSETs[key]; ok {
+        return "set"
+    }
+    return "none"
+}
+
+// wrongTypeLocked reports whether key exists holding something other than typ
+// The caller must hold at least the read lock of every store
+func wrongTypeLocked(key, typ string) bool {
+    actual := keyTypeLocked(key)
+    return actual != "none" && actual != typ
+}
+
+// anyWrongTypeLocked reports whether any of keys exists holding something other than typ
+// The caller must hold at least the read lock of every store
+func anyWrongTypeLocked(keys []Value, typ string) bool {
+    for _, key := range keys {
+        if wrongTypeLocked(key.bulk, typ) {
+            return true
+        }
+    }
+    return false
+}
+
+// expireIfNeeded lazily deletes key if its expiry has passed
+// Handlers call this before looking a key up so an expired key always
+// behaves as if it were already gone, whatever its type
+//
+// The first check is made without the store locks so the common case stays
+// cheap. Another goroutine can SET or EXPIRE the key before we get the write
+// locks, so the expiry is checked again under them before anything is deleted
+func expireIfNeeded(key string) {
+    if !isExpired(key) {
+        return
+    }
+
+    lockAllStores()
+    deleted := false
+    if isExpired(key) {
+        deleted = deleteKeyLocked(key)
+    }
+    unlockAllStores()
+
+    if deleted {
+        notifyKeyspaceEvent(notifyExpired, "expired", key)
+    }
+}
+
+// activeExpireEnabled turns the active expiry sweeper on and off
+// DEBUG SET-ACTIVE-EXPIRE 0 disables it so tests can observe expired keys
+// that haven't been looked up yet; lazy expiry keeps working either way
+var activeExpireEnabled atomic.Bool
+
+func init() {
+    activeExpireEnabled.Store(true)
+}
+
+// activeExpireCycle periodically removes expired keys that nobody reads
+// Without it, keys that are never accessed again would stay in memory forever
+//
+// It runs hz times per second. Each run samples a few keys with an expiry
+// instead of scanning them all, so it stays cheap on big keyspaces. Like Redis,
+// it keeps sampling while more than a quarter of a sample had expired, since
+// many more expired keys are then likely waiting, but only for up to a quarter
+// of the interval so commands aren't held up
+func activeExpireCycle() {
+    for {
+        interval := time.Second / time.Duration(config.Hz())
+        time.Sleep(interval)
+        if !activeExpireEnabled.Load() {
+            continue
+        }
+
+        deadline := time.Now().Add(interval / 4)
+        for {
+            sampled, expired := activeExpireSample(config.ActiveExpireSamples())
+            if sampled == 0 || expired*4 <= sampled || time.Now().After(deadline) {
+                break
+            }
+        }
+    }
+}
+
+// activeExpireSample looks at up to n keys with an expiry and deletes those
+// that have expired
+// It returns how many keys it looked at and how many of them had expired
+func activeExpireSample(n int) (int, int) {
+    // Go starts ranging over a map at a random entry, which makes the first n
+    // entries a cheap random sample
+    now := time.Now()
+    sampled := 0
+    expired := []string{}
+    expirationsMu.Lock()
+    for key, when := range expirations {
+        if sampled == n {
+            break
+        }
+        sampled++
+        if !now.Before(when) {
+            expired = append(expired, key)
+        }
+    }
+    expirationsMu.Unlock()
+
+    // Like a command, hold execMu so keys don't expire in the middle of EXEC
+    execMu.RLock()
+    for _, key := range expired {
+        expireIfNeeded(key)
+    }
+    execMu.RUnlock()
+
+    return sampled, len(expired)
+}
+
+// expireVariant describes how a command of the EXPIRE family reads its time argument
+type expireVariant struct {
+    unit     time.Duration // time.Second or time.Millisecond
+    absolute bool          // A Unix timestamp rather than an offset from now
+}
+
+// expireVariants lists the EXPIRE family
+// Whatever the variant, the expiry is stored as the absolute time it reduces to
+var expireVariants = map[string]expireVariant{
+    "EXPIRE":    {time.Second, false},
+    "PEXPIRE":   {time.Millisecond, false},
+    "EXPIREAT":  {time.Second, true},
+    "PEXPIREAT": {time.Millisecond, true},
+}
+
+// expireTime converts the time argument of an EXPIRE-family command into the
+// Unix time in milliseconds at which the key should expire
+// It fails on anything that isn't an integer or would overflow
+func expireTime(arg string, variant expireVariant) (int64, bool) {
+    n, err := strconv.ParseInt(arg, 10, 64)
+    if err != nil {
+        return 0, false
+    }
+
+    ms := n
+    if variant.unit == time.Second {
+        if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+            return 0, false
+        }
+        ms = n * 1000
+    }
+
+    if !variant.absolute {
+        now := time.Now().UnixMilli()
+        if ms > math.MaxInt64-now {
+            return 0, false
+        }
+        ms += now
+    }
+    return ms, true
+}
+
+// expireGeneric sets the expiry of the key in args[0] from args[1]
+// It is shared by EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT
+// Returns 1 if the timeout was set, 0 if the key doesn't exist
+func expireGeneric(args []Value, command string) Value {
+    key := args[0].bulk
+    if _, err := strconv.ParseInt(args[1].bulk, 10, 64); err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+    ms, ok := expireTime(args[1].bulk, expireVariants[command])
+    if !ok {
+        return Value{typ: "error", str: "ERR invalid expire time in '" + strings.ToLower(command) + "' command"}
+    }
+
+    expireIfNeeded(key)
+
+    // Hold the store locks so the key can't be deleted while we set its expiry
+    rLockAllStores()
+    defer rUnlockAllStores()
+
+    if !keyExistsLocked(key) {
+        return Value{typ: "integer", num: 0}
+    }
+
+    // A time in the past is allowed: the key then expires right away
+    expirationsMu.Lock()
+    expirations[key] = time.UnixMilli(ms)
+    expirationsMu.Unlock()
+
+    notifyKeyspaceEvent(notifyGeneric, "expire", key)
+    return Value{typ: "integer", num: 1}
+}
+
+// expire implements the Redis EXPIRE command
+// It sets a timeout in seconds after which the key is deleted
+// The command format is: EXPIRE key seconds
+func expire(c *Client, args []Value) Value {
+    return expireGeneric(args, "EXPIRE")
+}
+
+// pexpire implements the Redis PEXPIRE command
+// It sets a timeout in milliseconds after which the key is deleted
+// The command format is: PEXPIRE key milliseconds
+func pexpire(c *Client, args []Value) Value {
+    return expireGeneric(args, "PEXPIRE")
+}
+
+// expireat implements the Redis EXPIREAT command
+// It sets the Unix time, in seconds, at which the key is deleted
+// The command format is: EXPIREAT key unix-time-seconds
+func expireat(c *Client, args []Value) Value {
+    return expireGeneric(args, "EXPIREAT")
+}
+
+// pexpireat implements the Redis PEXPIREAT command
+// It sets the Unix time, in milliseconds, at which the key is deleted
+// The command format is: PEXPIREAT key unix-time-milliseconds
+func pexpireat(c *Client, args []Value) Value {
+    return expireGeneric(args, "PEXPIREAT")
+}
+
+// ttlGeneric returns the remaining time to live of the key in args[0] in unit
+// It is shared by TTL and PTTL
+// Returns -2 if the key doesn't exist and -1 if it has no expiry
+func ttlGeneric(args []Value, unit time.Duration) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    rLockAllStores()
+    defer rUnlockAllStores()
+
+    if !keyExistsLocked(key) {
+        return Value{typ: "integer", num: -2}
+    }
+
+    expirationsMu.Lock()
+    when, ok := expirations[key]
+    expirationsMu.Unlock()
+
+    if !ok {
+        return Value{typ: "integer", num: -1}
+    }
+
+    // Round to the nearest unit, like Redis
+    remaining := time.Until(when)
+    return Value{typ: "integer", num: int((remaining + unit/2) / unit)}
+}
+
+// ttl implements the Redis TTL command
+// It returns the remaining time to live of a key in seconds
+// The command format is: TTL key
+func ttl(c *Client, args []Value) Value {
+    return ttlGeneric(args, time.Second)
+}
+
+// pttl implements the Redis PTTL command
+// It returns the remaining time to live of a key in milliseconds
+// The command format is: PTTL key
+func pttl(c *Client, args []Value) Value {
+    return ttlGeneric(args, time.Millisecond)
+}
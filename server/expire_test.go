@@ -0,0 +1,103 @@
+// Package server tests key expiry under concurrent access
+// These tests are meant to be run with the race detector too:
+// go test -race -run Concurrent ./server
+package server
+
+import (
+    "fmt"
+    "math/rand"
+    "sync"
+    "testing"
+    "time"
+)
+
+// TestConcurrentWritesAcrossStores runs SET, HSET, SADD, LPUSH, DEL and
+// EXPIRE from many clients at once on a handful of shared keys, and checks
+// that every key ends up in at most one store and that no expiry outlives its key
+func TestConcurrentWritesAcrossStores(t *testing.T) {
+    c := newTestClient(t)
+    keys := []string{"k0", "k1", "k2", "k3"}
+    commands := [][]string{
+        {"SET", "", "v"},
+        {"HSET", "", "f", "v"},
+        {"SADD", "", "m"},
+        {"LPUSH", "", "e"},
+        {"DEL", ""},
+        {"PEXPIRE", "", "1"},
+        {"EXPIRE", "", "100"},
+        {"GET", ""},
+        {"HGET", "", "f"},
+    }
+
+    const workers, rounds = 8, 2000
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        client := newPeerClient(t, c.aof)
+        wg.Add(1)
+        go func(seed int64) {
+            defer wg.Done()
+            rng := rand.New(rand.NewSource(seed))
+            for i := 0; i < rounds; i++ {
+                args := append([]string{}, commands[rng.Intn(len(commands))]...)
+                args[1] = keys[rng.Intn(len(keys))]
+                reply := run(client, args...)
+                if reply.typ == "error" && reply.str != wrongTypeError.str {
+                    t.Errorf("%v: %s", args, reply.str)
+                    return
+                }
+            }
+        }(int64(w))
+    }
+    wg.Wait()
+
+    rLockAllStores()
+    defer rUnlockAllStores()
+    expirationsMu.Lock()
+    defer expirationsMu.Unlock()
+    for _, key := range keys {
+        _, inSETs := SETs[key]
+        _, inHSETs := HSETs[key]
+        _, inZSETs := ZSETs[key]
+        _, inLISTs := LISTs[key]
+        _, inSSETs := SSETs[key]
+        stores := 0
+        for _, in := range []bool{inSETs, inHSETs, inZSETs, inLISTs, inSSETs} {
+            if in {
+                stores++
+            }
+        }
+        if stores > 1 {
+            t.Errorf("%s is in %d stores", key, stores)
+        }
+        if _, ok := expirations[key]; ok && stores == 0 {
+            t.Errorf("%s has an expiry but no value", key)
+        }
+    }
+}
+
+// TestConcurrentGetAfterExpiry checks that once a key's time to live has run
+// out, GET never returns its value, however many clients race to expire it
+func TestConcurrentGetAfterExpiry(t *testing.T) {
+    c := newTestClient(t)
+
+    const readers, rounds = 8, 200
+    for i := 0; i < rounds; i++ {
+        key := fmt.Sprint("k", i)
+        run(c, "SET", key, "v")
+        expectInteger(t, run(c, "PEXPIRE", key, "1"), 1)
+        time.Sleep(2 * time.Millisecond)
+
+        var wg sync.WaitGroup
+        for r := 0; r < readers; r++ {
+            client := newPeerClient(t, c.aof)
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                if reply := run(client, "GET", key); reply.typ != "null" {
+                    t.Errorf("GET %s after its expiry: got %+v", key, reply)
+                }
+            }()
+        }
+        wg.Wait()
+    }
+}
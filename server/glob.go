@@ -0,0 +1,109 @@
+// Package server implements glob-style pattern matching
+// This is the same matching Redis uses for KEYS, SCAN MATCH and pattern subscriptions
+package server
+
+// matchPattern reports whether str matches the glob-style pattern
+// Supported syntax:
+//   *      matches any sequence of bytes (including none)
+//   ?      matches exactly one byte
+//   [abc]  matches one byte from the set, [^abc] negates it, [a-z] is a range
+//   \x     matches the byte x literally
+// Matching works on raw bytes, so binary keys are handled safely
+func matchPattern(pattern, str string) bool {
+    p, s := 0, 0
+
+    // Remember where the last '*' was so we can backtrack to it
+    starP, starS := -1, 0
+
+    for s < len(str) {
+        if p < len(pattern) {
+            switch pattern[p] {
+            case '*':
+                // Record the star position and try matching zero bytes first
+                starP, starS = p, s
+                p++
+                continue
+            case '?':
+                p++
+                s++
+                continue
+            case '[':
+                if next, ok := matchClass(pattern, p, str[s]); ok {
+                    p = next
+                    s++
+                    continue
+                }
+            case '\\':
+                if p+1 < len(pattern) && pattern[p+1] == str[s] {
+                    p += 2
+                    s++
+                    continue
+                }
+            default:
+                if pattern[p] == str[s] {
+                    p++
+                    s++
+                    continue
+                }
+            }
+        }
+
+        // Mismatch: if we saw a star, let it swallow one more byte and retry
+        if starP < 0 {
+            return false
+        }
+        starS++
+        p, s = starP+1, starS
+    }
+
+    // Any trailing stars can match the empty remainder
+    for p < len(pattern) && pattern[p] == '*' {
+        p++
+    }
+    return p == len(pattern)
+}
+
+// matchClass matches a single byte c against the [...] class starting at pattern[p]
+// It returns the index just past the closing ']' and whether c is in the class
+func matchClass(pattern string, p int, c byte) (int, bool) {
+    p++ // Skip '['
+
+    negate := false
+    if p < len(pattern) && pattern[p] == '^' {
+        negate = true
+        p++
+    }
+
+    matched := false
+    for p < len(pattern) && pattern[p] != ']' {
+        switch {
+        case pattern[p] == '\\' && p+1 < len(pattern):
+            // Escaped byte inside the class
+            if pattern[p+1] == c {
+                matched = true
+            }
+            p += 2
+        case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+            // Range such as a-z, accepted in either order
+            lo, hi := pattern[p], pattern[p+2]
+            if lo > hi {
+                lo, hi = hi, lo
+            }
+            if c >= lo && c <= hi {
+                matched = true
+            }
+            p += 3
+        default:
+            if pattern[p] == c {
+                matched = true
+            }
+            p++
+        }
+    }
+
+    // An unterminated class never matches
+    if p >= len(pattern) {
+        return p, false
+    }
+    return p + 1, matched != negate
+}
@@ -0,0 +1,1178 @@
+// Package server implements a Redis-like server with basic command handling functionality
+// This file specifically handles the implementation of Redis commands like SET, GET, HSET, etc.
+package server
+
+// Import the sync package which provides basic synchronization primitives
+// We need this for mutual exclusion (mutex) to handle concurrent access to our data stores
+import (
+    "math"
+    "sort"
+    "strings"
+    "sync"
+	"strconv"
+    "time"
+)
+
+// Command describes one entry in the command registry
+// The dispatcher checks the number of arguments against minArgs and maxArgs
+// before calling the handler, so handlers can rely on getting a valid count
+// Commands marked write change the dataset: they are appended to the AOF and
+// sent to replicas, so the dataset can be rebuilt elsewhere
+type Command struct {
+    handler func(c *Client, args []Value) Value  // Runs the command for client c
+    minArgs int                                  // Fewest arguments accepted, not counting the command name
+    maxArgs int                                  // Most arguments accepted, -1 means no upper limit
+    write   bool                                 // Changes the dataset
+}
+
+// arityOK reports whether n arguments (not counting the command name) are allowed
+func (cmd Command) arityOK(n int) bool {
+    return n >= cmd.minArgs && (cmd.maxArgs < 0 || n <= cmd.maxArgs)
+}
+
+// arity returns the arity in the form COMMAND reports it
+// It counts the command name too, and is negative when it's only a minimum:
+// 3 means exactly two arguments, -3 means at least two
+func (cmd Command) arity() int {
+    if cmd.minArgs == cmd.maxArgs {
+        return cmd.minArgs + 1
+    }
+    return -(cmd.minArgs + 1)
+}
+
+// Handlers maps Redis command names to their registry entries
+// Each handler function takes the client that sent the command and a slice of Values
+// (the command arguments) and returns a Value (the response)
+// This is our command registry - it tells the server which function to call for each Redis command
+var Handlers = map[string]Command{
+    "PING":         {ping, 0, 1, false},            // Simple server health check command
+    "SET":          {set, 2, 2, true},              // Set a key-value pair
+    "GET":          {get, 1, 1, false},             // Retrieve a value by key
+    "HSET":         {hset, 3, -1, true},            // Set fields in a hash structure
+    "HGET":         {hget, 2, 2, false},            // Get a field from a hash structure
+    "HGETALL":      {hgetall, 1, 1, false},         // Get all fields and values from a hash structure
+    "DEL":          {del, 1, -1, true},             // Delete one or more keys
+    "GETDEL":       {getdel, 1, 1, true},           // Get the value of a key and delete it
+    "GETEX":        {getex, 1, 3, true},            // Get the value of a key and set or clear its expiry
+    "HINCRBY":      {hincrby, 3, 3, true},          // Increment the integer value of a hash field
+    "SCAN":         {scan, 1, -1, false},           // Incrementally iterate over the keyspace
+    "OBJECT":       {object, 1, -1, false},         // Inspect how a key's value is stored
+    "ZADD":         {zadd, 3, -1, true},            // Add members with scores to a sorted set
+    "ZSCORE":       {zscore, 2, 2, false},          // Get the score of a sorted set member
+    "ZRANGE":       {zrange, 3, 4, false},          // Get a range of sorted set members by rank
+    "ZRANK":        {zrank, 2, 2, false},           // Get the rank of a sorted set member
+    "ZINCRBY":      {zincrby, 3, 3, true},          // Increment the score of a sorted set member
+    "ZCARD":        {zcard, 1, 1, false},           // Get the number of members in a sorted set
+    "ZCOUNT":       {zcount, 3, 3, false},          // Count sorted set members within a score range
+    "ZREM":         {zrem, 2, -1, true},            // Remove members from a sorted set
+    "MULTI":        {multi, 0, 0, false},           // Start a transaction
+    "EXEC":         {exec, 0, 0, false},            // Run the queued commands of a transaction
+    "DISCARD":      {discard, 0, 0, false},         // Drop the queued commands of a transaction
+    "WATCH":        {watch, 1, -1, false},          // Abort the next EXEC if any of these keys change
+    "UNWATCH":      {unwatch, 0, 0, false},         // Forget every watched key
+    "COMMAND":      {command, 0, -1, false},        // Describe the commands this server supports
+    "BGREWRITEAOF": {bgrewriteaof, 0, 0, false},    // Compact the AOF in the background
+    "DUMP":         {dump, 1, 1, false},            // Serialize a key's value for RESTORE
+    "RESTORE":      {restore, 3, -1, true},         // Create a key from a DUMP payload
+    "EXPIRE":       {expire, 2, 2, true},           // Set a key's time to live in seconds
+    "TTL":          {ttl, 1, 1, false},             // Get a key's remaining time to live in seconds
+    "PEXPIRE":      {pexpire, 2, 2, true},          // Set a key's time to live in milliseconds
+    "EXPIREAT":     {expireat, 2, 2, true},         // Set the Unix time in seconds at which a key expires
+    "PEXPIREAT":    {pexpireat, 2, 2, true},        // Set the Unix time in milliseconds at which a key expires
+    "PTTL":         {pttl, 1, 1, false},            // Get a key's remaining time to live in milliseconds
+    "CONFIG":       {configCommand, 1, -1, false},  // Read and change runtime configuration
+    "GETRANGE":     {getrange, 3, 3, false},        // Get a substring of a string value
+    "SETRANGE":     {setrange, 3, 3, true},         // Overwrite part of a string value
+    "HMSET":        {hmset, 3, -1, true},           // Set several fields in a hash structure
+    "HMGET":        {hmget, 2, -1, false},          // Get several fields from a hash structure
+    "SLOWLOG":      {slowlogCommand, 1, -1, false}, // Inspect commands that took too long
+    "PUBLISH":      {publishCommand, 2, 2, false},  // Send a message to a pub/sub channel
+    "WAIT":         {wait, 2, 2, false},            // Wait for replicas to acknowledge writes
+    "REPLICAOF":    {replicaof, 2, 2, false},       // Replicate from a master, or stop with NO ONE
+    "SLAVEOF":      {replicaof, 2, 2, false},       // Old name for REPLICAOF
+    "PSYNC":        {psync, 2, 2, false},           // Start streaming the dataset and its writes to a replica
+    "REPLCONF":     {replconf, 0, -1, false},       // Replica options sent during the replication handshake
+    "AUTH":         {auth, 1, 1, false},            // Authenticate the connection
+    "QUIT":         {quit, 0, -1, false},           // Close the connection
+    "SUBSCRIBE":    {subscribe, 1, -1, false},      // Listen for messages on channels
+    "UNSUBSCRIBE":  {unsubscribe, 0, -1, false},    // Stop listening on channels
+    "PSUBSCRIBE":   {psubscribe, 1, -1, false},     // Listen for messages on channels matching patterns
+    "PUNSUBSCRIBE": {punsubscribe, 0, -1, false},   // Stop listening on channel patterns
+    "PUBSUB":       {pubsubCommand, 1, -1, false},  // Inspect pub/sub channels and patterns
+    "CLIENT":       {clientCommand, 1, -1, false},  // Inspect and name client connections
+    "HELLO":        {hello, 0, -1, false},          // Pick the protocol version and describe the server
+    "LPUSH":        {lpush, 2, -1, true},           // Insert elements at the head of a list
+    "RPUSH":        {rpush, 2, -1, true},           // Append elements to the tail of a list
+    "LPUSHX":       {lpushx, 2, -1, true},          // Insert elements at the head of an existing list
+    "RPUSHX":       {rpushx, 2, -1, true},          // Append elements to the tail of an existing list
+    "LPOP":         {lpop, 1, 2, true},             // Remove and return elements from the head of a list
+    "RPOP":         {rpop, 1, 2, true},             // Remove and return elements from the tail of a list
+    "BLPOP":        {blpop, 2, -1, true},           // Pop from the head of the first non-empty list, waiting for one
+    "BRPOP":        {brpop, 2, -1, true},           // Pop from the tail of the first non-empty list, waiting for one
+    "LLEN":         {llen, 1, 1, false},            // Get the length of a list
+    "LRANGE":       {lrange, 3, 3, false},          // Get a range of list elements by index
+    "LINDEX":       {lindex, 2, 2, false},          // Get a list element by index
+    "LSET":         {lset, 3, 3, true},             // Replace a list element by index
+    "LREM":         {lrem, 3, 3, true},             // Remove matching elements from a list
+    "LINSERT":      {linsert, 4, 4, true},          // Insert an element before or after another
+    "LTRIM":        {ltrim, 3, 3, true},            // Trim a list to a range of indexes
+    "LMOVE":        {lmove, 4, 4, true},            // Move an element from one end of a list to one end of another
+    "RPOPLPUSH":    {rpoplpush, 2, 2, true},        // Move the tail element of a list to the head of another
+    "SADD":         {sadd, 2, -1, true},            // Add members to a set
+    "SREM":         {srem, 2, -1, true},            // Remove members from a set
+    "SMEMBERS":     {smembers, 1, 1, false},        // Get every member of a set
+    "SISMEMBER":    {sismember, 2, 2, false},       // Check whether a value is in a set
+    "SCARD":        {scard, 1, 1, false},           // Get the number of members in a set
+    "SINTER":       {sinter, 1, -1, false},         // Intersect sets
+    "SUNION":       {sunion, 1, -1, false},         // Union sets
+    "SDIFF":        {sdiff, 1, -1, false},          // Subtract sets from the first one
+    "SINTERSTORE":  {sinterstore, 2, -1, true},     // Intersect sets and store the result
+    "SUNIONSTORE":  {sunionstore, 2, -1, true},     // Union sets and store the result
+    "SDIFFSTORE":   {sdiffstore, 2, -1, true},      // Subtract sets and store the result
+    "SMISMEMBER":   {smismember, 2, -1, false},     // Check whether several values are in a set
+    "SINTERCARD":   {sintercard, 2, -1, false},     // Count the members of an intersection
+    "SHUTDOWN":     {shutdown, 0, 1, false},        // Stop the server
+    "UNLINK":       {unlink, 1, -1, true},          // Delete keys, reclaiming their memory in the background
+    "TOUCH":        {touch, 1, -1, false},          // Mark keys as recently used
+    "MONITOR":      {monitor, 0, 0, false},         // Stream every command run by other clients
+    "INFO":         {info, 0, -1, false},           // Report server state and statistics
+    "DEBUG":        {debugCommand, 1, -1, false},   // Testing hooks such as DEBUG SLEEP and DEBUG OBJECT
+    "HSETNX":       {hsetnx, 3, 3, true},           // Set a hash field only if it doesn't exist
+    "HRANDFIELD":   {hrandfield, 1, 3, false},      // Get random fields from a hash structure
+    "SRANDMEMBER":  {srandmember, 1, 2, false},     // Get random members of a set
+    "SETBIT":       {setbit, 3, 3, true},           // Set or clear one bit of a string
+    "GETBIT":       {getbit, 2, 2, false},          // Get one bit of a string
+    "BITCOUNT":     {bitcount, 1, 4, false},        // Count the set bits of a string
+}
+
+// ping implements the PING command from Redis protocol
+// If called without arguments, returns "PONG"
+// If called with an argument, echoes back that argument
+// This is commonly used to test if the server is alive and responding
+func ping(c *Client, args []Value) Value {
+    // A RESP2 subscriber can't tell a plain reply from a pushed message,
+    // so it gets the reply in the shape of a message: ["pong", argument]
+    if c.subscribed() && c.writer.Protocol() == 2 {
+        message := ""
+        if len(args) > 0 {
+            message = args[0].bulk
+        }
+        return Value{typ: "array", array: []Value{
+            {typ: "bulk", bulk: "pong"},
+            {typ: "bulk", bulk: message},
+        }}
+    }
+
+    // If no arguments provided, return the standard "PONG" response
+    if len(args) == 0 {
+        return Value{typ: "string", str: "PONG"}
+    }
+
+    // If an argument was provided, echo it back to the client
+    // args[0].bulk contains the first argument's value
+    return Value{typ: "string", str: args[0].bulk}
+}
+
+// wait implements the Redis WAIT command
+// It blocks until the given number of replicas acknowledged our writes
+// Our replicas never acknowledge what they've applied (see replication.go),
+// so there are never any to wait for and the answer is always 0 right away,
+// just like a Redis master without replicas
+// The command format is: WAIT numreplicas timeout
+func wait(c *Client, args []Value) Value {
+    // Both arguments must be integers, even though we don't use them
+    if _, err := strconv.Atoi(args[0].bulk); err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+    timeout, err := strconv.Atoi(args[1].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR timeout is not an integer or out of range"}
+    }
+    if timeout < 0 {
+        return Value{typ: "error", str: "ERR timeout is negative"}
+    }
+
+    return Value{typ: "integer", num: 0}
+}
+
+// SETs is our key-value store for string values
+// This is a simple map that stores key-value pairs for the SET and GET commands
+// It's the equivalent of Redis's string data type storage
+var SETs = map[string]string{}
+
+// SETsMu is a read-write mutex that protects access to the SETs map
+// RWMutex allows multiple simultaneous readers but only one writer
+// This ensures thread-safety when multiple clients are accessing the data
+var SETsMu = sync.RWMutex{}
+
+// set implements the Redis SET command
+// It stores a key-value pair in the SETs map
+// The command format is: SET key value
+func set(c *Client, args []Value) Value {
+    // Extract key and value from the arguments
+    key := args[0].bulk    // First argument is the key
+    value := args[1].bulk  // Second argument is the value
+
+    // Lock every store before modifying the map
+    // SET replaces a key of any type, so the old value has to be removed
+    // from whichever store holds it or the key would live in two stores
+    lockAllStores()
+    if wrongTypeLocked(key, "string") {
+        deleteKeyLocked(key)
+    }
+    setStringLocked(key, value)  // Store the key-value pair
+    clearExpiration(key)  // SET discards any previous time to live
+    unlockAllStores()    // Release the locks immediately after writing
+    touchKey(key)
+    notifyKeyspaceEvent(notifyString, "set", key)
+
+    // Return OK to indicate successful operation
+    return Value{typ: "string", str: "OK"}
+}
+
+// get implements the Redis GET command
+// It retrieves a value from the SETs map by its key
+// The command format is: GET key
+func get(c *Client, args []Value) Value {
+    // Extract the key from the arguments
+    key := args[0].bulk
+
+    // Remove the key first if its time to live has run out
+    expireIfNeeded(key)
+
+    // Get a read lock - multiple goroutines can read simultaneously
+    // The key may have expired since expireIfNeeded looked at it, so the
+    // expiry is checked again under the lock; a late expiry reads as missing
+    SETsMu.RLock()
+    value, ok := SETs[key]  // Attempt to get the value and whether it exists
+    ok = ok && !isExpired(key)
+    SETsMu.RUnlock()       // Release the read lock
+
+    // If the key doesn't exist, return null
+    // This matches Redis behavior for non-existent keys
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(key)
+
+    // Return the value as a bulk string
+    return Value{typ: "bulk", bulk: value}
+}
+
+// getdel implements the Redis GETDEL command
+// It returns the value of a key and deletes the key in one atomic step
+// The command format is: GETDEL key
+func getdel(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Hold the write locks for the whole read-and-delete so no other
+    // client can observe the key between the two steps
+    // Every store is locked so a key of another type is refused, not skipped
+    lockAllStores()
+    if wrongTypeLocked(key, "string") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    value, ok := SETs[key]
+    if ok {
+        deleteKeyLocked(key)
+    }
+    unlockAllStores()
+
+    // If the key doesn't exist, return null just like GET
+    if !ok {
+        return Value{typ: "null"}
+    }
+    notifyKeyspaceEvent(notifyGeneric, "del", key)
+
+    return Value{typ: "bulk", bulk: value}
+}
+
+// getexOptions maps the GETEX options that set a TTL to how their argument is read
+var getexOptions = map[string]expireVariant{
+    "EX":   expireVariants["EXPIRE"],
+    "PX":   expireVariants["PEXPIRE"],
+    "EXAT": expireVariants["EXPIREAT"],
+    "PXAT": expireVariants["PEXPIREAT"],
+}
+
+// parseGetexOptions reads the options of a GETEX command
+// It returns the Unix time in milliseconds to expire at if setTTL is true,
+// whether PERSIST was given, or an error message for invalid options
+func parseGetexOptions(options []Value) (ms int64, setTTL, persist bool, errMsg string) {
+    if len(options) == 0 {
+        return 0, false, false, ""
+    }
+
+    option := strings.ToUpper(options[0].bulk)
+    variant, ok := getexOptions[option]
+    switch {
+    case len(options) == 1 && option == "PERSIST":
+        return 0, false, true, ""
+    case len(options) == 2 && ok:
+        n, err := strconv.ParseInt(options[1].bulk, 10, 64)
+        if err != nil {
+            return 0, false, false, "ERR value is not an integer or out of range"
+        }
+        ms, ok := expireTime(options[1].bulk, variant)
+        if n <= 0 || !ok {
+            return 0, false, false, "ERR invalid expire time in 'getex' command"
+        }
+        return ms, true, false, ""
+    default:
+        return 0, false, false, "ERR syntax error"
+    }
+}
+
+// getex implements the Redis GETEX command
+// It returns the value of a key like GET, and can set (EX, PX, EXAT, PXAT) or
+// remove (PERSIST) its expiry in the same step
+// The command format is: GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+func getex(c *Client, args []Value) Value {
+    key := args[0].bulk
+
+    ms, setTTL, persist, errMsg := parseGetexOptions(args[1:])
+    if errMsg != "" {
+        return Value{typ: "error", str: errMsg}
+    }
+    when := time.UnixMilli(ms)
+
+    expireIfNeeded(key)
+
+    // Hold the write locks so the value we return and the expiry we change
+    // belong to the same key, even if another client overwrites it meanwhile
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "string") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    value, ok := SETs[key]
+    persisted := false
+    if ok {
+        expirationsMu.Lock()
+        if setTTL {
+            expirations[key] = when
+        }
+        if _, hasTTL := expirations[key]; persist && hasTTL {
+            delete(expirations, key)
+            persisted = true
+        }
+        expirationsMu.Unlock()
+    }
+    unlockAllStores()
+
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(key)
+
+    if setTTL {
+        notifyKeyspaceEvent(notifyGeneric, "expire", key)
+    }
+    if persisted {
+        notifyKeyspaceEvent(notifyGeneric, "persist", key)
+    }
+
+    return Value{typ: "bulk", bulk: value}
+}
+
+// getrange implements the Redis GETRANGE command
+// It returns the substring between two byte offsets, both inclusive
+// Negative offsets count from the end of the string (-1 is the last byte)
+// The command format is: GETRANGE key start end
+func getrange(c *Client, args []Value) Value {
+    key := args[0].bulk
+    start, err1 := strconv.Atoi(args[1].bulk)
+    end, err2 := strconv.Atoi(args[2].bulk)
+    if err1 != nil || err2 != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    expireIfNeeded(key)
+
+    SETsMu.RLock()
+    value, ok := SETs[key]  // A missing key behaves like an empty string
+    SETsMu.RUnlock()
+    if ok {
+        touchKey(key)
+    }
+
+    // Convert negative offsets and clamp both ends to the string
+    length := len(value)
+    if start < 0 {
+        start += length
+    }
+    if end < 0 {
+        end += length
+    }
+    if start < 0 {
+        start = 0
+    }
+    if end < 0 {
+        end = 0
+    }
+    if end >= length {
+        end = length - 1
+    }
+
+    // An empty or inverted range gives an empty string
+    if length == 0 || start > end {
+        return Value{typ: "bulk", bulk: ""}
+    }
+
+    return Value{typ: "bulk", bulk: value[start : end+1]}
+}
+
+// setrange implements the Redis SETRANGE command
+// It overwrites the string at key starting at offset, padding with zero bytes
+// if the offset is past the end, and returns the new length of the string
+// The command format is: SETRANGE key offset value
+func setrange(c *Client, args []Value) Value {
+    key := args[0].bulk
+    patch := args[2].bulk
+    offset, err := strconv.Atoi(args[1].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+    if offset < 0 {
+        return Value{typ: "error", str: "ERR offset is out of range"}
+    }
+
+    // Don't let a large offset build a string bigger than we'd ever accept
+    // Written as a subtraction, since offset+len(patch) can overflow
+    if offset > MaxBulkLen-len(patch) {
+        return Value{typ: "error", str: "ERR string exceeds maximum allowed size (proto-max-bulk-len)"}
+    }
+
+    expireIfNeeded(key)
+
+    // Hold the write locks for the whole read-modify-write
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    defer unlockAllStores()
+    if wrongTypeLocked(key, "string") {
+        return wrongTypeError
+    }
+
+    value, ok := SETs[key]
+
+    // Writing nothing never creates or changes the key
+    if len(patch) == 0 {
+        return Value{typ: "integer", num: len(value)}
+    }
+
+    // Grow the string with zero bytes if the patch ends past its current length
+    buf := []byte(value)
+    if end := offset + len(patch); end > len(buf) {
+        buf = append(buf, make([]byte, end-len(buf))...)
+    }
+    copy(buf[offset:], patch)
+
+    // Unlike SET, SETRANGE keeps any time to live the key already had
+    setStringLocked(key, string(buf))
+    if !ok {
+        clearExpiration(key)
+    }
+    touchKey(key)
+    notifyKeyspaceEvent(notifyString, "setrange", key)
+
+    return Value{typ: "integer", num: len(buf)}
+}
+
+// wrongTypeError is the reply to a command used on a key holding another type
+var wrongTypeError = Value{typ: "error", str: "WRONGTYPE Operation against a key holding the wrong kind of value"}
+
+// HSETs is our hash table store
+// It's a nested map: the outer map keys are hash names, and each value is another map
+// The inner maps represent hash fields and their values
+// This implements Redis's hash data structure
+var HSETs = map[string]map[string]string{}
+
+// HSETsMu protects access to the HSETs map
+// Like SETsMu, this ensures thread-safe access to our hash structures
+var HSETsMu = sync.RWMutex{}
+
+// hashFieldOrder holds the fields of each hash in the order they were added,
+// for the hash-preserve-order option. Fields are only recorded while the
+// option is on, so it costs nothing otherwise. Protected by HSETsMu
+var hashFieldOrder = map[string][]string{}
+
+// hashFieldsLocked lists the fields of hash in the order replies use
+// With hash-preserve-order that's the order they were added in, followed by
+// any fields added while the option was off, sorted. Otherwise it's Go's map
+// order, which changes from call to call; Redis doesn't promise an order either
+// The caller must hold at least the read lock of HSETsMu
+func hashFieldsLocked(hash string) []string {
+    fields := HSETs[hash]
+    names := make([]string, 0, len(fields))
+    if !config.HashPreserveOrder() {
+        for field := range fields {
+            names = append(names, field)
+        }
+        return names
+    }
+
+    for _, field := range hashFieldOrder[hash] {
+        if _, ok := fields[field]; ok {
+            names = append(names, field)
+        }
+    }
+    if len(names) < len(fields) {
+        ordered := make(map[string]bool, len(names))
+        for _, field := range names {
+            ordered[field] = true
+        }
+        rest := []string{}
+        for field := range fields {
+            if !ordered[field] {
+                rest = append(rest, field)
+            }
+        }
+        sort.Strings(rest)
+        names = append(names, rest...)
+    }
+    return names
+}
+
+// Lock ordering
+//
+// Commands that touch more than one store must acquire the store mutexes
+// in this canonical order, and release them in reverse:
+//
+//   SETsMu -> HSETsMu -> ZSETsMu -> LISTsMu -> SSETsMu
+//
+// New stores are appended to the end of this list. If every handler follows
+// the same order, two commands can never each hold one lock while waiting
+// for the other (an AB/BA deadlock). Commands that only need one store at a
+// time may lock them one after another, as long as they never hold two at once
+// out of order. Cross-type commands should use lockAllStores / rLockAllStores
+// rather than locking the stores by hand. expirationsMu, accessTimesMu and
+// pubsubMu are leaf locks: they may be taken after store locks, but a store
+// lock is never acquired while holding one of them.
+
+// lockAllStores acquires the write lock on every store in canonical order
+func lockAllStores() {
+    SETsMu.Lock()
+    HSETsMu.Lock()
+    ZSETsMu.Lock()
+    LISTsMu.Lock()
+    SSETsMu.Lock()
+}
+
+// unlockAllStores releases the write locks taken by lockAllStores, in reverse order
+func unlockAllStores() {
+    SSETsMu.Unlock()
+    LISTsMu.Unlock()
+    ZSETsMu.Unlock()
+    HSETsMu.Unlock()
+    SETsMu.Unlock()
+}
+
+// rLockAllStores acquires the read lock on every store in canonical order
+func rLockAllStores() {
+    SETsMu.RLock()
+    HSETsMu.RLock()
+    ZSETsMu.RLock()
+    LISTsMu.RLock()
+    SSETsMu.RLock()
+}
+
+// rUnlockAllStores releases the read locks taken by rLockAllStores, in reverse order
+func rUnlockAllStores() {
+    SSETsMu.RUnlock()
+    LISTsMu.RUnlock()
+    ZSETsMu.RUnlock()
+    HSETsMu.RUnlock()
+    SETsMu.RUnlock()
+}
+
+// hset implements the Redis HSET command
+// It sets one or more field values within a hash structure
+// Returns the number of fields that were added, not counting updated ones
+// The command format is: HSET hash field value [field value ...]
+func hset(c *Client, args []Value) Value {
+    // HSET needs a hash name followed by one or more field/value pairs
+    if (len(args)-1)%2 != 0 {
+        return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
+    }
+
+    hash := args[0].bulk // Name of the hash
+
+    // An expired hash must not be extended with new fields
+    expireIfNeeded(hash)
+
+    // Lock for writing since we're modifying the structure
+    // Taking it once for all the fields means other clients see all of them or none
+    // Every store is locked so the key can't become another type meanwhile
+    lockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    added := 0
+    for i := 1; i < len(args); i += 2 {
+        // Set the field value, creating the hash if it doesn't exist yet
+        if setHashFieldLocked(hash, args[i].bulk, args[i+1].bulk) {
+            added++
+        }
+    }
+    unlockAllStores()
+    touchKey(hash)
+    notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+    return Value{typ: "integer", num: added}
+}
+
+// hsetnx implements the Redis HSETNX command
+// It sets a field only if the field doesn't exist yet
+// Returns 1 if the field was set and 0 if it already existed
+// The command format is: HSETNX hash field value
+func hsetnx(c *Client, args []Value) Value {
+    // Extract arguments
+    hash := args[0].bulk   // Name of the hash
+    key := args[1].bulk    // Field name within the hash
+    value := args[2].bulk  // Value to store
+
+    // An expired hash must not keep its old fields
+    expireIfNeeded(hash)
+
+    // Hold the write lock across the check and the set, so two clients
+    // racing to initialize the same field can't both win
+    lockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    if _, exists := HSETs[hash][key]; exists {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+    setHashFieldLocked(hash, key, value)
+    unlockAllStores()
+    touchKey(hash)
+    notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+    return Value{typ: "integer", num: 1}
+}
+
+// hget implements the Redis HGET command
+// It retrieves the value of a field from a hash structure
+// The command format is: HGET hash field
+func hget(c *Client, args []Value) Value {
+    // Extract arguments
+    hash := args[0].bulk  // Name of the hash
+    key := args[1].bulk   // Field name to retrieve
+
+    // An expired hash behaves as if it were already gone
+    expireIfNeeded(hash)
+
+    // Get a read lock
+    // As in GET, an expiry that passed after expireIfNeeded reads as missing
+    rLockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    value, ok := HSETs[hash][key]  // Attempt to get the field value
+    ok = ok && !isExpired(hash)
+    rUnlockAllStores()
+
+    // If either the hash doesn't exist or the field doesn't exist, return null
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(hash)
+
+    // Return the field value
+    return Value{typ: "bulk", bulk: value}
+}
+
+// hincrby implements the Redis HINCRBY command
+// It adds an integer increment to a hash field, treating a missing field as 0
+// The command format is: HINCRBY hash field increment
+func hincrby(c *Client, args []Value) Value {
+    // Extract arguments
+    hash := args[0].bulk  // Name of the hash
+    key := args[1].bulk   // Field name within the hash
+
+    // An expired hash starts over from an empty hash
+    expireIfNeeded(hash)
+
+    // The increment itself must be a valid integer
+    incr, err := strconv.ParseInt(args[2].bulk, 10, 64)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    // Hold the write lock for the whole read-modify-write so concurrent
+    // increments on the same field can't lose updates
+    lockAllStores()
+    defer unlockAllStores()
+
+    if wrongTypeLocked(hash, "hash") {
+        return wrongTypeError
+    }
+
+    // A missing field counts as 0, otherwise it must already hold an integer
+    var current int64
+    if value, ok := HSETs[hash][key]; ok {
+        current, err = strconv.ParseInt(value, 10, 64)
+        if err != nil {
+            return Value{typ: "error", str: "ERR hash value is not an integer"}
+        }
+    }
+
+    // Refuse increments that would wrap around
+    if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+        return Value{typ: "error", str: "ERR increment or decrement would overflow"}
+    }
+    current += incr
+
+    // Store the result, creating the hash if it doesn't exist yet
+    setHashFieldLocked(hash, key, strconv.FormatInt(current, 10))
+    touchKey(hash)
+    notifyKeyspaceEvent(notifyHash, "hincrby", hash)
+
+    // Return the new value as an integer
+    return Value{typ: "integer", num: int(current)}
+}
+
+// hmset implements the Redis HMSET command
+// It sets several field values within a hash structure in one go
+// The command format is: HMSET hash field value [field value ...]
+func hmset(c *Client, args []Value) Value {
+    // HMSET needs a hash name followed by one or more field/value pairs
+    if (len(args)-1)%2 != 0 {
+        return Value{typ: "error", str: "ERR wrong number of arguments for 'hmset' command"}
+    }
+
+    hash := args[0].bulk
+    expireIfNeeded(hash)
+
+    // Take the lock once for all the fields so other clients
+    // see either none or all of them
+    lockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    for i := 1; i < len(args); i += 2 {
+        setHashFieldLocked(hash, args[i].bulk, args[i+1].bulk)
+    }
+    unlockAllStores()
+    touchKey(hash)
+    notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+    return Value{typ: "string", str: "OK"}
+}
+
+// hmget implements the Redis HMGET command
+// It returns the values of several fields, with null for each missing field
+// The command format is: HMGET hash field [field ...]
+func hmget(c *Client, args []Value) Value {
+    hash := args[0].bulk
+    expireIfNeeded(hash)
+
+    rLockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    fields, ok := HSETs[hash]
+    values := make([]Value, 0, len(args)-1)
+    for _, arg := range args[1:] {
+        if value, found := fields[arg.bulk]; found {
+            values = append(values, Value{typ: "bulk", bulk: value})
+        } else {
+            values = append(values, Value{typ: "null"})
+        }
+    }
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(hash)
+    }
+
+    return Value{typ: "array", array: values}
+}
+
+// hrandfield implements the Redis HRANDFIELD command
+// Without a count it returns one random field, or null if the hash doesn't exist
+// With a count it returns an array of fields, picked like SRANDMEMBER picks
+// members; WITHVALUES adds each field's value, as a flat field/value list for
+// RESP2 clients and as [field, value] pairs for RESP3 clients
+// The command format is: HRANDFIELD hash [count [WITHVALUES]]
+func hrandfield(c *Client, args []Value) Value {
+    hash := args[0].bulk
+    count := 1
+    withValues := false
+    if len(args) > 1 {
+        var ok bool
+        if count, ok = parseRandomCount(args[1]); !ok {
+            return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+        }
+    }
+    if len(args) > 2 {
+        if len(args) > 3 || strings.ToUpper(args[2].bulk) != "WITHVALUES" {
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+        withValues = true
+    }
+    expireIfNeeded(hash)
+
+    // Go maps can't be indexed by position, so the fields are listed first
+    rLockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    fields, ok := HSETs[hash]
+    names := make([]string, 0, len(fields))
+    values := make([]string, 0, len(fields))
+    for field, value := range fields {
+        names = append(names, field)
+        values = append(values, value)
+    }
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(hash)
+    }
+
+    picks := randomPicks(len(names), count)
+    if len(args) == 1 {
+        if len(picks) == 0 {
+            return Value{typ: "null"}
+        }
+        return Value{typ: "bulk", bulk: names[picks[0]]}
+    }
+
+    reply := []Value{}
+    for _, i := range picks {
+        field := Value{typ: "bulk", bulk: names[i]}
+        switch {
+        case !withValues:
+            reply = append(reply, field)
+        case c.writer.Protocol() == 3:
+            reply = append(reply, Value{typ: "array", array: []Value{field, {typ: "bulk", bulk: values[i]}}})
+        default:
+            reply = append(reply, field, Value{typ: "bulk", bulk: values[i]})
+        }
+    }
+    return Value{typ: "array", array: reply}
+}
+
+// hgetall implements the Redis HGETALL command
+// It returns all fields and values of a hash structure
+// The command format is: HGETALL hash
+func hgetall(c *Client, args []Value) Value {
+    // Extract the hash name
+    hash := args[0].bulk
+    expireIfNeeded(hash)
+
+    // Get a read lock
+    rLockAllStores()
+    if wrongTypeLocked(hash, "hash") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    value, ok := HSETs[hash]  // Get the entire hash structure
+
+    // Create a map reply holding all field-value pairs
+    // Its elements alternate between field names and their values; RESP3 clients
+    // receive it as a map and RESP2 clients as a flat array
+    values := []Value{}
+    for _, k := range hashFieldsLocked(hash) {
+        // Add field name to array
+        values = append(values, Value{typ: "bulk", bulk: k})
+        // Add field value to array
+        values = append(values, Value{typ: "bulk", bulk: value[k]})
+    }
+    rUnlockAllStores()
+
+    // If the hash doesn't exist, return null
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(hash)
+
+    // Return the field-value pairs
+    return Value{typ: "map", array: values}
+}
+
+// del implements the Redis DEL command
+// It removes keys of any type and returns how many of them existed
+// The command format is: DEL key [key ...]
+func del(c *Client, args []Value) Value {
+    // Expired keys don't count as deleted
+    for _, arg := range args {
+        expireIfNeeded(arg.bulk)
+    }
+
+    // A key may live in any store, so take all of them in canonical order
+    // Holding them for every key makes a multi-key DEL atomic
+    deleted := []string{}
+    lockAllStores()
+    for _, arg := range args {
+        // Remove the key from whichever store holds it, along with its expiry
+        if deleteKeyLocked(arg.bulk) {
+            deleted = append(deleted, arg.bulk)
+        }
+    }
+    unlockAllStores()
+
+    // Announce the deletions once the stores are unlocked again
+    // This also bumps each key's version, failing EXEC for clients watching it
+    for _, key := range deleted {
+        notifyKeyspaceEvent(notifyGeneric, "del", key)
+    }
+
+    return Value{typ: "integer", num: len(deleted)}
+}
+
+// unlink implements the Redis UNLINK command
+// Like DEL it removes keys and returns how many existed, but only unhooking
+// them from the keyspace happens before the reply. Sizing the removed values
+// for the memory estimate walks every element, so that is left to a
+// background goroutine, which also drops the last references to them
+// The command format is: UNLINK key [key ...]
+func unlink(c *Client, args []Value) Value {
+    // Expired keys don't count as unlinked
+    for _, arg := range args {
+        expireIfNeeded(arg.bulk)
+    }
+
+    type detached struct {
+        key    string
+        values []interface{}
+    }
+    unlinked := []detached{}
+
+    lockAllStores()
+    for _, arg := range args {
+        if values := detachKeyLocked(arg.bulk); len(values) > 0 {
+            unlinked = append(unlinked, detached{arg.bulk, values})
+        }
+    }
+    unlockAllStores()
+
+    for _, d := range unlinked {
+        notifyKeyspaceEvent(notifyGeneric, "del", d.key)
+    }
+
+    if len(unlinked) == 0 {
+        return Value{typ: "integer", num: 0}
+    }
+    go func() {
+        for _, d := range unlinked {
+            for _, value := range d.values {
+                trackMemory(-valueMemory(d.key, value))
+            }
+        }
+    }()
+
+    return Value{typ: "integer", num: len(unlinked)}
+}
+
+// touch implements the Redis TOUCH command
+// It marks keys as just accessed, for LRU eviction, and returns how many exist
+// The command format is: TOUCH key [key ...]
+func touch(c *Client, args []Value) Value {
+    for _, arg := range args {
+        expireIfNeeded(arg.bulk)
+    }
+
+    existing := []string{}
+    rLockAllStores()
+    for _, arg := range args {
+        if keyExistsLocked(arg.bulk) {
+            existing = append(existing, arg.bulk)
+        }
+    }
+    rUnlockAllStores()
+
+    for _, key := range existing {
+        touchKey(key)
+    }
+
+    return Value{typ: "integer", num: len(existing)}
+}
+
+// emptyDataset deletes every key, for DEBUG RELOAD, a replica loading its
+// master's dataset and a server starting up
+// Every watched key counts as changed, whether it existed or not, since the
+// dataset that replaces this one may hold anything
+func emptyDataset() {
+    lockAllStores()
+    for _, key := range storedKeysLocked() {
+        deleteKeyLocked(key)
+    }
+    unlockAllStores()
+    touchWatchedKeys()
+}
+
+// storedKeysLocked returns every key held in any store, expired or not
+// The caller must hold at least the read lock of every store
+func storedKeysLocked() []string {
+    seen := map[string]bool{}
+    for key := range SETs {
+        seen[key] = true
+    }
+    for key := range HSETs {
+        seen[key] = true
+    }
+    for key := range ZSETs {
+        seen[key] = true
+    }
+    for key := range LISTs {
+        seen[key] = true
+    }
+    for key := range SSETs {
+        seen[key] = true
+    }
+
+    keys := make([]string, 0, len(seen))
+    for key := range seen {
+        keys = append(keys, key)
+    }
+    return keys
+}
+
+// allKeys returns every key in the keyspace, across all data types
+// The result is sorted so callers get a stable order to iterate over
+func allKeys() []string {
+    // Read every store under one consistent set of locks
+    rLockAllStores()
+    stored := storedKeysLocked()
+    rUnlockAllStores()
+
+    keys := make([]string, 0, len(stored))
+    for _, key := range stored {
+        // Keys whose time to live has run out are logically gone
+        if isExpired(key) {
+            continue
+        }
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// object implements the Redis OBJECT command family
+// The command format is: OBJECT <subcommand> [arguments ...]
+// Supported subcommands:
+//   OBJECT ENCODING key - how the value at key is stored
+//   OBJECT IDLETIME key - seconds since the key was last read or written
+//   OBJECT VERSION key  - the version WATCH compares, see keyVersions
+//   OBJECT REFCOUNT key - always 1, since values are never shared between keys
+func object(c *Client, args []Value) Value {
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch subcommand {
+    case "ENCODING":
+        if len(args) != 2 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'object|encoding' command"}
+        }
+        encoding, ok := objectEncoding(args[1].bulk)
+        if !ok {
+            return Value{typ: "error", str: "ERR no such key"}
+        }
+        return Value{typ: "bulk", bulk: encoding}
+    case "IDLETIME":
+        if len(args) != 2 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'object|idletime' command"}
+        }
+        idle, ok := keyIdleTime(args[1].bulk)
+        if !ok {
+            return Value{typ: "error", str: "ERR no such key"}
+        }
+        return Value{typ: "integer", num: int(idle / time.Second)}
+    case "REFCOUNT":
+        if len(args) != 2 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'object|refcount' command"}
+        }
+        key := args[1].bulk
+        expireIfNeeded(key)
+
+        rLockAllStores()
+        exists := keyExistsLocked(key)
+        rUnlockAllStores()
+
+        if !exists {
+            return Value{typ: "error", str: "ERR no such key"}
+        }
+        return Value{typ: "integer", num: 1}
+    case "VERSION":
+        if len(args) != 2 {
+            return Value{typ: "error", str: "ERR wrong number of arguments for 'object|version' command"}
+        }
+        // Unlike the other subcommands this works for a missing key too: it
+        // is at 0 unless a client watches it
+        key := args[1].bulk
+        expireIfNeeded(key)
+        return Value{typ: "integer", num: int(keyVersion(key))}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand '" + args[0].bulk + "'. Try OBJECT HELP."}
+    }
+}
+
+// objectEncoding reports how the value at key is stored, and whether the key exists
+// These names mirror Redis's encodings but are derived from the value itself:
+//   int       - a string that parses as a 64-bit integer
+//   embstr    - a short string (44 bytes or less)
+//   raw       - any longer string
+//   hashtable - a hash or a set
+//   skiplist  - a sorted set
+//   quicklist - a list
+func objectEncoding(key string) (string, bool) {
+    expireIfNeeded(key)
+
+    SETsMu.RLock()
+    value, ok := SETs[key]
+    SETsMu.RUnlock()
+
+    if ok {
+        if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+            return "int", true
+        }
+        if len(value) <= 44 {
+            return "embstr", true
+        }
+        return "raw", true
+    }
+
+    HSETsMu.RLock()
+    _, ok = HSETs[key]
+    HSETsMu.RUnlock()
+
+    if ok {
+        return "hashtable", true
+    }
+
+    ZSETsMu.RLock()
+    _, ok = ZSETs[key]
+    ZSETsMu.RUnlock()
+
+    if ok {
+        return "skiplist", true
+    }
+
+    LISTsMu.RLock()
+    _, ok = LISTs[key]
+    LISTsMu.RUnlock()
+
+    if ok {
+        return "quicklist", true
+    }
+
+    SSETsMu.RLock()
+    _, ok = SSETs[key]
+    SSETsMu.RUnlock()
+
+    if ok {
+        return "hashtable", true
+    }
+
+    return "", false
+}
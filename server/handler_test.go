@@ -0,0 +1,115 @@
+// Package server tests the string and hash commands
+package server
+
+import "testing"
+
+// TestHashCommandsOnStringKey checks that the hash commands refuse a string key
+func TestHashCommandsOnStringKey(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+
+    expectError(t, run(c, "HGET", "k", "f"), "WRONGTYPE")
+    expectError(t, run(c, "HGETALL", "k"), "WRONGTYPE")
+    expectError(t, run(c, "HSET", "k", "f", "v"), "WRONGTYPE")
+    expectError(t, run(c, "HMGET", "k", "f"), "WRONGTYPE")
+
+    // The string itself is left alone
+    expectBulk(t, run(c, "GET", "k"), "v")
+}
+
+// TestSetReplacesHash checks that SET over a hash leaves only the string,
+// rather than a key that lives in both stores
+func TestSetReplacesHash(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "HSET", "k", "f", "v")
+
+    if reply := run(c, "SET", "k", "s"); reply.typ != "string" || reply.str != "OK" {
+        t.Fatalf("SET: got %+v", reply)
+    }
+    expectBulk(t, run(c, "GET", "k"), "s")
+    expectError(t, run(c, "HGET", "k", "f"), "WRONGTYPE")
+
+    HSETsMu.RLock()
+    _, inHSETs := HSETs["k"]
+    HSETsMu.RUnlock()
+    if inHSETs {
+        t.Fatal("the old hash is still stored")
+    }
+
+    // Deleting the key removes it once, from the only store that has it
+    expectInteger(t, run(c, "DEL", "k"), 1)
+    if reply := run(c, "GET", "k"); reply.typ != "null" {
+        t.Fatalf("GET after DEL: got %+v", reply)
+    }
+}
+
+// TestSetOverHashSurvivesRestart checks that replaying SET over a hash gives
+// the same single string as the live dataset
+func TestSetOverHashSurvivesRestart(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "HSET", "k", "f", "v")
+    run(c, "SET", "k", "s")
+
+    restart(t, c)
+
+    expectBulk(t, run(c, "GET", "k"), "s")
+    expectError(t, run(c, "HGET", "k", "f"), "WRONGTYPE")
+}
+
+// TestStringWritesOnOtherTypes checks that the commands changing part of a
+// string refuse a hash or list key, instead of creating a string next to it
+// There is no INCR yet; SETRANGE and SETBIT are the commands that would
+// otherwise read a missing string as empty
+func TestStringWritesOnOtherTypes(t *testing.T) {
+    for _, setup := range [][]string{
+        {"HSET", "k", "f", "v"},
+        {"RPUSH", "k", "a"},
+    } {
+        for _, command := range [][]string{
+            {"SETRANGE", "k", "0", "x"},
+            {"SETBIT", "k", "0", "1"},
+        } {
+            t.Run(setup[0]+" then "+command[0], func(t *testing.T) {
+                c := newTestClient(t)
+                run(c, setup...)
+
+                expectError(t, run(c, command...), "WRONGTYPE")
+
+                SETsMu.RLock()
+                _, inSETs := SETs["k"]
+                SETsMu.RUnlock()
+                if inSETs {
+                    t.Fatal("a string was created next to the existing key")
+                }
+            })
+        }
+    }
+}
+
+// TestSetrangeHugeOffset checks that an offset near the top of the int range
+// is refused, rather than overflowing the size check and panicking
+func TestSetrangeHugeOffset(t *testing.T) {
+    c := newTestClient(t)
+
+    for _, offset := range []string{"9223372036854775807", "9223372036854775806"} {
+        expectError(t, run(c, "SETRANGE", "k", offset, "x"), "ERR string exceeds maximum allowed size")
+    }
+    if reply := run(c, "GET", "k"); reply.typ != "null" {
+        t.Fatalf("GET k: got %+v, want null", reply)
+    }
+}
+
+// TestGetdelOnHash checks that GETDEL and GETEX refuse a hash, and that the
+// refused GETDEL isn't logged as a DEL that would drop the hash on restart
+func TestGetdelOnHash(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "HSET", "h", "f", "v")
+
+    expectError(t, run(c, "GETDEL", "h"), "WRONGTYPE")
+    expectError(t, run(c, "GETEX", "h", "PX", "100000"), "WRONGTYPE")
+
+    restart(t, c)
+
+    expectBulk(t, run(c, "HGET", "h", "f"), "v")
+    expectInteger(t, run(c, "TTL", "h"), -1)
+}
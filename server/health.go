@@ -0,0 +1,44 @@
+// Package server implements an HTTP health check for load balancers
+// GET /healthz answers 200 OK while the server is accepting clients and the
+// AOF is being written, and 503 otherwise, so an HTTP probe (a Kubernetes
+// liveness/readiness probe, a load balancer check) needs no Redis client
+// It is served on the metrics port, and on -healthz-port if that is set
+package server
+
+import (
+    "fmt"
+    "net/http"
+)
+
+// healthHandler returns the /healthz handler for a server logging to aof
+func healthHandler(aof *Aof) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain")
+
+        // The RESP listeners are open before any HTTP server starts, so
+        // we're accepting clients until shutdown begins
+        if shuttingDown.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte("shutting down\n"))
+            return
+        }
+        if err := aof.Err(); err != nil {
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte("AOF write failed: " + err.Error() + "\n"))
+            return
+        }
+        w.Write([]byte("OK"))
+    }
+}
+
+// serveHealth runs srv answering only /healthz, until it fails or is closed
+func serveHealth(srv *http.Server, aof *Aof) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/healthz", healthHandler(aof))
+    srv.Handler = mux
+
+    fmt.Fprintln(logOutput, "Serving health checks on " + srv.Addr + "/healthz")
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        fmt.Fprintln(logOutput, "Health check server stopped:", err)
+    }
+}
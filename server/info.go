@@ -0,0 +1,137 @@
+// Package server implements the INFO command
+// INFO reports the state of the server as "name:value" lines grouped into
+// sections, each headed by a "# Section" line, the format redis-cli and
+// monitoring tools parse
+package server
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+// serverStart is when the server started, for uptime_in_seconds
+var serverStart = time.Now()
+
+// infoSection is one section of the INFO reply
+type infoSection struct {
+    name   string                     // Lower-case name used to ask for it
+    format func(b *strings.Builder) // Writes the section's lines
+}
+
+// infoSections lists the sections in the order INFO prints them
+// Sections marked in infoNotDefault are only printed when asked for by name,
+// or with "all" or "everything"
+var infoSections = []infoSection{
+    {"server", infoServer},
+    {"clients", infoClients},
+    {"memory", infoMemory},
+    {"stats", infoStats},
+    {"replication", infoReplication},
+    {"commandstats", infoCommandstats},
+    {"keyspace", infoKeyspace},
+}
+
+// infoNotDefault lists the sections left out of a plain INFO, like in Redis
+var infoNotDefault = map[string]bool{
+    "commandstats": true,
+}
+
+// info implements the Redis INFO command
+// The command format is: INFO [section [section ...]]
+// A section may also be "default", "all" or "everything"
+func info(c *Client, args []Value) Value {
+    wanted := map[string]bool{}
+    for _, arg := range args {
+        wanted[strings.ToLower(arg.bulk)] = true
+    }
+    if len(wanted) == 0 {
+        wanted["default"] = true
+    }
+    all := wanted["all"] || wanted["everything"]
+
+    var b strings.Builder
+    for _, section := range infoSections {
+        if !all && !wanted[section.name] && !(wanted["default"] && !infoNotDefault[section.name]) {
+            continue
+        }
+        if b.Len() > 0 {
+            b.WriteString("\r\n")
+        }
+        b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+        section.format(&b)
+    }
+
+    return Value{typ: "bulk", bulk: b.String()}
+}
+
+// infoServer describes the server process
+func infoServer(b *strings.Builder) {
+    fmt.Fprintf(b, "redis_version:%s\r\n", serverVersion)
+    fmt.Fprintf(b, "process_id:%d\r\n", os.Getpid())
+    fmt.Fprintf(b, "uptime_in_seconds:%d\r\n", int64(time.Since(serverStart)/time.Second))
+    fmt.Fprintf(b, "hz:%d\r\n", config.Hz())
+}
+
+// infoClients describes the connected clients
+func infoClients(b *strings.Builder) {
+    fmt.Fprintf(b, "connected_clients:%d\r\n", connectedClients.Load())
+    fmt.Fprintf(b, "maxclients:%d\r\n", config.MaxClients())
+}
+
+// infoMemory describes the memory estimate and limit
+func infoMemory(b *strings.Builder) {
+    fmt.Fprintf(b, "used_memory:%d\r\n", UsedMemory())
+    fmt.Fprintf(b, "maxmemory:%d\r\n", config.MaxMemory())
+    fmt.Fprintf(b, "maxmemory_policy:%s\r\n", config.MaxMemoryPolicy())
+}
+
+// infoStats describes the totals since startup or the last CONFIG RESETSTAT
+func infoStats(b *strings.Builder) {
+    var calls int64
+    for _, stat := range commandStats {
+        calls += stat.calls.Load()
+    }
+    fmt.Fprintf(b, "total_commands_processed:%d\r\n", calls)
+    fmt.Fprintf(b, "total_error_replies:%d\r\n", errorReplies.Load())
+}
+
+// infoCommandstats describes every command that has run at least once
+func infoCommandstats(b *strings.Builder) {
+    names := make([]string, 0, len(commandStats))
+    for name := range commandStats {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        calls := commandStats[name].calls.Load()
+        if calls == 0 {
+            continue
+        }
+        usec := commandStats[name].usec.Load()
+        fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+            strings.ToLower(name), calls, usec, float64(usec)/float64(calls))
+    }
+}
+
+// infoKeyspace describes the keys, and how many of them have an expiry
+func infoKeyspace(b *strings.Builder) {
+    keys := allKeys()
+    if len(keys) == 0 {
+        return
+    }
+
+    expires := 0
+    expirationsMu.Lock()
+    for _, key := range keys {
+        if _, ok := expirations[key]; ok {
+            expires++
+        }
+    }
+    expirationsMu.Unlock()
+
+    fmt.Fprintf(b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", len(keys), expires)
+}
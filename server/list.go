@@ -0,0 +1,657 @@
+// Package server implements the list data type
+// A list is an ordered sequence of strings that can be pushed to and popped
+// from at both ends, which makes it a natural fit for queues and stacks
+package server
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// LISTs is our list store
+// It maps each key to its elements, head first. A plain slice keeps the code
+// simple; pushing to the head is O(n) because elements have to be shifted,
+// which is fine for the small and medium lists this server is used with
+var LISTs = map[string][]string{}
+
+// LISTsMu protects access to the LISTs map and the lists inside it
+var LISTsMu = sync.RWMutex{}
+
+// listElementMemory is the estimated size of one list element
+func listElementMemory(element string) int64 {
+    return int64(fieldOverhead + len(element))
+}
+
+// removeEmptyListLocked deletes key once its last element is gone
+// Like Redis, an empty list doesn't exist, so it also loses its expiry
+// The caller must hold LISTsMu for writing
+func removeEmptyListLocked(key string) bool {
+    if len(LISTs[key]) > 0 {
+        return false
+    }
+    delete(LISTs, key)
+    trackMemory(-int64(keyOverhead + len(key)))
+    unindexKey(key)
+    clearExpiration(key)
+    forgetKeyAccess(key)
+    return true
+}
+
+// push adds elements to the head (left) or tail of the list at key
+// It is shared by LPUSH, RPUSH, LPUSHX and RPUSHX and returns the new length
+// of the list. With onlyExisting a missing list is left alone and 0 returned
+func push(args []Value, left, onlyExisting bool, event string) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so the key can't become another type meanwhile
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok && onlyExisting {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+    if !ok {
+        trackMemory(int64(keyOverhead + len(key)))
+        indexKey(key)
+    }
+    for _, arg := range args[1:] {
+        // LPUSH a b c leaves c at the head, so each element goes in front of the last
+        if left {
+            list = append([]string{arg.bulk}, list...)
+        } else {
+            list = append(list, arg.bulk)
+        }
+        trackMemory(listElementMemory(arg.bulk))
+    }
+    LISTs[key] = list
+    signalListWaitersLocked(key)
+    length := len(list)
+    unlockAllStores()
+
+    touchKey(key)
+    notifyKeyspaceEvent(notifyList, event, key)
+
+    return Value{typ: "integer", num: length}
+}
+
+// lpush implements the Redis LPUSH command
+// It inserts elements at the head of a list, creating the list if needed
+// The command format is: LPUSH key element [element ...]
+func lpush(c *Client, args []Value) Value {
+    return push(args, true, false, "lpush")
+}
+
+// rpush implements the Redis RPUSH command
+// It appends elements to the tail of a list, creating the list if needed
+// The command format is: RPUSH key element [element ...]
+func rpush(c *Client, args []Value) Value {
+    return push(args, false, false, "rpush")
+}
+
+// lpushx implements the Redis LPUSHX command
+// Like LPUSH, but only if the list already exists
+// The command format is: LPUSHX key element [element ...]
+func lpushx(c *Client, args []Value) Value {
+    return push(args, true, true, "lpush")
+}
+
+// rpushx implements the Redis RPUSHX command
+// Like RPUSH, but only if the list already exists
+// The command format is: RPUSHX key element [element ...]
+func rpushx(c *Client, args []Value) Value {
+    return push(args, false, true, "rpush")
+}
+
+// pop removes elements from the head (left) or tail of the list at key
+// It is shared by LPOP and RPOP. Without a count it replies with a single
+// bulk string; with a count it replies with an array of up to count elements,
+// or a null array if the list doesn't exist
+func pop(args []Value, left bool, event string) Value {
+    key := args[0].bulk
+
+    count := 1
+    if len(args) == 2 {
+        n, err := strconv.Atoi(args[1].bulk)
+        if err != nil || n < 0 {
+            return Value{typ: "error", str: "ERR value is out of range, must be positive"}
+        }
+        count = n
+    }
+
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok {
+        unlockAllStores()
+        if len(args) == 2 {
+            return Value{typ: "null_array"}
+        }
+        return Value{typ: "null"}
+    }
+
+    popped, removed := popLocked(key, list, count, left)
+    unlockAllStores()
+
+    if !removed {
+        touchKey(key)
+    }
+    if len(popped) > 0 {
+        notifyKeyspaceEvent(notifyList, event, key)
+    }
+    if removed {
+        notifyKeyspaceEvent(notifyGeneric, "del", key)
+    }
+
+    if len(args) == 1 {
+        return Value{typ: "bulk", bulk: popped[0]}
+    }
+    values := make([]Value, 0, len(popped))
+    for _, element := range popped {
+        values = append(values, Value{typ: "bulk", bulk: element})
+    }
+    return Value{typ: "array", array: values}
+}
+
+// popLocked removes up to count elements from the head (left) or tail of
+// list, the list stored at key, and reports whether that emptied and deleted it
+// The caller must hold LISTsMu for writing
+func popLocked(key string, list []string, count int, left bool) ([]string, bool) {
+    if count > len(list) {
+        count = len(list)
+    }
+    popped := make([]string, 0, count)
+    for i := 0; i < count; i++ {
+        var element string
+        if left {
+            element, list = list[0], list[1:]
+        } else {
+            element, list = list[len(list)-1], list[:len(list)-1]
+        }
+        popped = append(popped, element)
+        trackMemory(-listElementMemory(element))
+    }
+    LISTs[key] = list
+    return popped, removeEmptyListLocked(key)
+}
+
+// lpop implements the Redis LPOP command
+// It removes and returns elements from the head of a list
+// The command format is: LPOP key [count]
+func lpop(c *Client, args []Value) Value {
+    return pop(args, true, "lpop")
+}
+
+// rpop implements the Redis RPOP command
+// It removes and returns elements from the tail of a list
+// The command format is: RPOP key [count]
+func rpop(c *Client, args []Value) Value {
+    return pop(args, false, "rpop")
+}
+
+// llen implements the Redis LLEN command
+// It returns the length of a list, or 0 if the list doesn't exist
+// The command format is: LLEN key
+func llen(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    if wrongTypeLocked(key, "list") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(key)
+    }
+    return Value{typ: "integer", num: len(list)}
+}
+
+// lrange implements the Redis LRANGE command
+// It returns the elements between two indexes, both inclusive
+// Negative indexes count from the end (-1 is the last element)
+// The command format is: LRANGE key start stop
+func lrange(c *Client, args []Value) Value {
+    start, err1 := strconv.Atoi(args[1].bulk)
+    stop, err2 := strconv.Atoi(args[2].bulk)
+    if err1 != nil || err2 != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(key, "list") {
+        return wrongTypeError
+    }
+
+    list, ok := LISTs[key]
+    if !ok {
+        return Value{typ: "array", array: []Value{}}
+    }
+    touchKey(key)
+
+    // Convert negative indexes and clamp the range to the list
+    n := len(list)
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    if start < 0 {
+        start = 0
+    }
+    if stop >= n {
+        stop = n - 1
+    }
+
+    values := []Value{}
+    for i := start; i <= stop; i++ {
+        values = append(values, Value{typ: "bulk", bulk: list[i]})
+    }
+
+    return Value{typ: "array", array: values}
+}
+
+// lindex implements the Redis LINDEX command
+// It returns the element at index, or null if index is out of range
+// Negative indexes count from the end (-1 is the last element)
+// The command format is: LINDEX key index
+func lindex(c *Client, args []Value) Value {
+    index, err := strconv.Atoi(args[1].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(key, "list") {
+        return wrongTypeError
+    }
+
+    list, ok := LISTs[key]
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(key)
+
+    if index < 0 {
+        index += len(list)
+    }
+    if index < 0 || index >= len(list) {
+        return Value{typ: "null"}
+    }
+
+    return Value{typ: "bulk", bulk: list[index]}
+}
+
+// lset implements the Redis LSET command
+// It replaces the element at index
+// Negative indexes count from the end (-1 is the last element)
+// The command format is: LSET key index element
+func lset(c *Client, args []Value) Value {
+    index, err := strconv.Atoi(args[1].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    key := args[0].bulk
+    element := args[2].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "error", str: "ERR no such key"}
+    }
+
+    if index < 0 {
+        index += len(list)
+    }
+    if index < 0 || index >= len(list) {
+        unlockAllStores()
+        return Value{typ: "error", str: "ERR index out of range"}
+    }
+
+    trackMemory(int64(len(element) - len(list[index])))
+    list[index] = element
+    unlockAllStores()
+
+    touchKey(key)
+    notifyKeyspaceEvent(notifyList, "lset", key)
+
+    return Value{typ: "string", str: "OK"}
+}
+
+// lrem implements the Redis LREM command
+// It removes elements equal to element and returns how many were removed
+// A positive count removes up to count matches starting from the head, a
+// negative count up to -count matches starting from the tail, and 0 removes all
+// The command format is: LREM key count element
+func lrem(c *Client, args []Value) Value {
+    count, err := strconv.Atoi(args[1].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    key := args[0].bulk
+    element := args[2].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+
+    // Mark the matches to remove, walking from the end count points at
+    limit := count
+    if limit < 0 {
+        limit = -limit
+    }
+    remove := make([]bool, len(list))
+    removed := 0
+    for i := range list {
+        j := i
+        if count < 0 {
+            j = len(list) - 1 - i
+        }
+        if list[j] == element {
+            remove[j] = true
+            removed++
+            if removed == limit {
+                break
+            }
+        }
+    }
+
+    // Compact the list in place, keeping the order of what's left
+    kept := list[:0]
+    for i, e := range list {
+        if !remove[i] {
+            kept = append(kept, e)
+        }
+    }
+    LISTs[key] = kept
+    trackMemory(-int64(removed) * listElementMemory(element))
+    deleted := removeEmptyListLocked(key)
+    unlockAllStores()
+
+    if removed > 0 {
+        if !deleted {
+            touchKey(key)
+        }
+        notifyKeyspaceEvent(notifyList, "lrem", key)
+        if deleted {
+            notifyKeyspaceEvent(notifyGeneric, "del", key)
+        }
+    }
+
+    return Value{typ: "integer", num: removed}
+}
+
+// ltrim implements the Redis LTRIM command
+// It trims a list so only the elements between two indexes, both inclusive,
+// are left, deleting the list if that leaves nothing
+// Negative indexes count from the end (-1 is the last element), and indexes
+// out of range are clamped to the list like they are for LRANGE
+// The command format is: LTRIM key start stop
+func ltrim(c *Client, args []Value) Value {
+    start, err1 := strconv.Atoi(args[1].bulk)
+    stop, err2 := strconv.Atoi(args[2].bulk)
+    if err1 != nil || err2 != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "string", str: "OK"}
+    }
+
+    // Convert negative indexes and clamp the range to the list
+    n := len(list)
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    if start < 0 {
+        start = 0
+    }
+    if stop >= n {
+        stop = n - 1
+    }
+
+    // An empty range keeps nothing
+    kept := list[:0]
+    if start <= stop {
+        kept = list[start : stop+1]
+    }
+    for i, element := range list {
+        if i < start || i > stop {
+            trackMemory(-listElementMemory(element))
+        }
+    }
+    LISTs[key] = kept
+    deleted := removeEmptyListLocked(key)
+    unlockAllStores()
+
+    if !deleted {
+        touchKey(key)
+    }
+    notifyKeyspaceEvent(notifyList, "ltrim", key)
+    if deleted {
+        notifyKeyspaceEvent(notifyGeneric, "del", key)
+    }
+
+    return Value{typ: "string", str: "OK"}
+}
+
+// linsert implements the Redis LINSERT command
+// It inserts element just before or after the first occurrence of pivot
+// Returns the new length of the list, -1 if pivot wasn't found, or 0 if the
+// list doesn't exist
+// The command format is: LINSERT key BEFORE|AFTER pivot element
+func linsert(c *Client, args []Value) Value {
+    var after bool
+    switch strings.ToUpper(args[1].bulk) {
+    case "BEFORE":
+        after = false
+    case "AFTER":
+        after = true
+    default:
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+
+    key := args[0].bulk
+    pivot := args[2].bulk
+    element := args[3].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+
+    at := -1
+    for i, e := range list {
+        if e == pivot {
+            at = i
+            break
+        }
+    }
+    if at < 0 {
+        unlockAllStores()
+        return Value{typ: "integer", num: -1}
+    }
+    if after {
+        at++
+    }
+
+    list = append(list, "")
+    copy(list[at+1:], list[at:])
+    list[at] = element
+    LISTs[key] = list
+    trackMemory(listElementMemory(element))
+    length := len(list)
+    unlockAllStores()
+
+    touchKey(key)
+    notifyKeyspaceEvent(notifyList, "linsert", key)
+
+    return Value{typ: "integer", num: length}
+}
+
+// parseListSide reads a LEFT or RIGHT argument, reporting whether it was LEFT
+func parseListSide(arg string) (left bool, ok bool) {
+    switch strings.ToUpper(arg) {
+    case "LEFT":
+        return true, true
+    case "RIGHT":
+        return false, true
+    }
+    return false, false
+}
+
+// move pops an element from one end of the list at source and pushes it onto
+// one end of the list at destination, returning the element or null if source
+// doesn't exist. It is shared by LMOVE and RPOPLPUSH
+// Source and destination may be the same list, which rotates it
+func move(source, destination string, fromLeft, toLeft bool) Value {
+    expireIfNeeded(source)
+    expireIfNeeded(destination)
+
+    // Hold the write locks for the whole move so the element is never missing
+    // from both lists or present in both
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    if wrongTypeLocked(source, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    list, ok := LISTs[source]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "null"}
+    }
+    if wrongTypeLocked(destination, "list") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+
+    var element string
+    if fromLeft {
+        element, list = list[0], list[1:]
+    } else {
+        element, list = list[len(list)-1], list[:len(list)-1]
+    }
+    LISTs[source] = list
+
+    // Looked up after the pop, so a rotation pushes onto the shortened list
+    target, ok := LISTs[destination]
+    if !ok {
+        trackMemory(int64(keyOverhead + len(destination)))
+        indexKey(destination)
+    }
+    if toLeft {
+        target = append([]string{element}, target...)
+    } else {
+        target = append(target, element)
+    }
+    LISTs[destination] = target
+    signalListWaitersLocked(destination)
+    removed := removeEmptyListLocked(source)
+    unlockAllStores()
+
+    popEvent, pushEvent := "rpop", "rpush"
+    if fromLeft {
+        popEvent = "lpop"
+    }
+    if toLeft {
+        pushEvent = "lpush"
+    }
+
+    if !removed {
+        touchKey(source)
+    }
+    touchKey(destination)
+    notifyKeyspaceEvent(notifyList, popEvent, source)
+    if removed {
+        notifyKeyspaceEvent(notifyGeneric, "del", source)
+    }
+    notifyKeyspaceEvent(notifyList, pushEvent, destination)
+
+    return Value{typ: "bulk", bulk: element}
+}
+
+// lmove implements the Redis LMOVE command
+// It atomically moves an element from one end of a list to one end of another
+// The command format is: LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmove(c *Client, args []Value) Value {
+    fromLeft, ok1 := parseListSide(args[2].bulk)
+    toLeft, ok2 := parseListSide(args[3].bulk)
+    if !ok1 || !ok2 {
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+    return move(args[0].bulk, args[1].bulk, fromLeft, toLeft)
+}
+
+// rpoplpush implements the Redis RPOPLPUSH command
+// It atomically moves the tail element of a list to the head of another
+// The command format is: RPOPLPUSH source destination
+func rpoplpush(c *Client, args []Value) Value {
+    return move(args[0].bulk, args[1].bulk, false, true)
+}
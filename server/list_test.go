@@ -0,0 +1,81 @@
+// Package server tests the list commands
+package server
+
+import "testing"
+
+// TestMoveFromOtherType checks that LMOVE and RPOPLPUSH refuse a source
+// holding another type
+func TestMoveFromOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "src", "v")
+
+    expectError(t, run(c, "LMOVE", "src", "dst", "LEFT", "RIGHT"), "WRONGTYPE")
+    expectError(t, run(c, "RPOPLPUSH", "src", "dst"), "WRONGTYPE")
+    expectBulk(t, run(c, "GET", "src"), "v")
+    expectInteger(t, run(c, "LLEN", "dst"), 0)
+}
+
+// TestMoveToOtherType checks that LMOVE and RPOPLPUSH refuse a destination
+// holding another type, and leave the source list as it was
+func TestMoveToOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "RPUSH", "src", "a", "b")
+    run(c, "SET", "dst", "v")
+
+    expectError(t, run(c, "LMOVE", "src", "dst", "LEFT", "RIGHT"), "WRONGTYPE")
+    expectError(t, run(c, "RPOPLPUSH", "src", "dst"), "WRONGTYPE")
+    expectInteger(t, run(c, "LLEN", "src"), 2)
+    expectBulk(t, run(c, "GET", "dst"), "v")
+
+    LISTsMu.RLock()
+    _, inLISTs := LISTs["dst"]
+    LISTsMu.RUnlock()
+    if inLISTs {
+        t.Fatal("the element was pushed onto a list next to the string")
+    }
+}
+
+// TestMoveMissingSource checks that a missing source replies null, even when
+// the destination holds another type, like in Redis
+func TestMoveMissingSource(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "dst", "v")
+
+    if reply := run(c, "LMOVE", "src", "dst", "LEFT", "RIGHT"); reply.typ != "null" {
+        t.Fatalf("got %+v, want null", reply)
+    }
+}
+
+// TestMoveRotates checks that moving within one list rotates it
+func TestMoveRotates(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "RPUSH", "l", "a", "b", "c")
+
+    expectBulk(t, run(c, "RPOPLPUSH", "l", "l"), "c")
+    expectBulk(t, run(c, "LINDEX", "l", "0"), "c")
+    expectInteger(t, run(c, "LLEN", "l"), 3)
+}
+
+// TestListCommandsOnOtherType checks that every list command refuses a key
+// holding a string, instead of treating it as a missing list
+func TestListCommandsOnOtherType(t *testing.T) {
+    for _, command := range [][]string{
+        {"LPOP", "k"},
+        {"RPOP", "k", "2"},
+        {"LLEN", "k"},
+        {"LRANGE", "k", "0", "-1"},
+        {"LINDEX", "k", "0"},
+        {"LSET", "k", "0", "x"},
+        {"LREM", "k", "0", "x"},
+        {"LTRIM", "k", "0", "1"},
+        {"LINSERT", "k", "BEFORE", "a", "x"},
+    } {
+        t.Run(command[0], func(t *testing.T) {
+            c := newTestClient(t)
+            run(c, "SET", "k", "v")
+
+            expectError(t, run(c, command...), "WRONGTYPE")
+            expectBulk(t, run(c, "GET", "k"), "v")
+        })
+    }
+}
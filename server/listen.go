@@ -0,0 +1,54 @@
+// Package server implements the TCP listener setup
+// The socket options that make restarts and connection bursts smoother differ
+// between platforms, so the platform specific parts live in listen_*.go
+package server
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "syscall"
+    "time"
+)
+
+// defaultTCPBacklog is the accept queue length we ask for, the same as Redis
+const defaultTCPBacklog = 511
+
+// listenTCP opens the TCP listener on addr
+// SO_REUSEADDR is set so a restarted server can bind while connections from the
+// previous run are still in TIME_WAIT. With reusePort, SO_REUSEPORT is set too
+// where the platform has it
+//
+// Accepted connections get TCP keepalive probes every keepAlive, so the kernel
+// notices peers that vanished without closing the connection, such as a laptop
+// gone to sleep, and the connection handler is woken with an error. A keepAlive
+// of 0 turns the probes off
+//
+// Go doesn't let us pass a backlog to listen(2); it always asks for the kernel
+// maximum. backlog is therefore only a hint: if the kernel limit is lower we
+// warn at startup, like Redis does, so the operator knows to raise it
+func listenTCP(addr string, backlog int, reusePort bool, keepAlive time.Duration) (net.Listener, error) {
+    if limit, ok := kernelBacklogLimit(); ok && limit < backlog {
+        fmt.Fprintf(logOutput, "WARNING: The TCP backlog setting of %d cannot be enforced because the kernel limit is set to the lower value of %d\n", backlog, limit)
+    }
+
+    // A negative KeepAlive disables keepalives; zero would mean Go's default
+    if keepAlive == 0 {
+        keepAlive = -1
+    }
+
+    lc := net.ListenConfig{
+        KeepAlive: keepAlive,
+        Control: func(network, address string, rc syscall.RawConn) error {
+            var sockErr error
+            err := rc.Control(func(fd uintptr) {
+                sockErr = setReuseOptions(fd, reusePort)
+            })
+            if err != nil {
+                return err
+            }
+            return sockErr
+        },
+    }
+    return lc.Listen(context.Background(), "tcp", addr)
+}
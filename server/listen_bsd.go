@@ -0,0 +1,27 @@
+//go:build darwin || freebsd
+
+package server
+
+import "syscall"
+
+// setReuseOptions sets SO_REUSEADDR, and SO_REUSEPORT if reusePort is set, on fd
+// On the BSDs SO_REUSEPORT lets several processes bind the same port, but unlike
+// Linux it doesn't balance connections between them
+func setReuseOptions(fd uintptr, reusePort bool) error {
+    if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+        return err
+    }
+    if reusePort {
+        return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+    }
+    return nil
+}
+
+// kernelBacklogLimit returns kern.ipc.somaxconn, the cap on every listen backlog
+func kernelBacklogLimit() (int, bool) {
+    n, err := syscall.SysctlUint32("kern.ipc.somaxconn")
+    if err != nil {
+        return 0, false
+    }
+    return int(n), true
+}
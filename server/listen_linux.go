@@ -0,0 +1,39 @@
+package server
+
+import (
+    "os"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+// soReusePort is SO_REUSEPORT on Linux (3.9 and later)
+// The syscall package doesn't define it for Linux
+const soReusePort = 0xf
+
+// setReuseOptions sets SO_REUSEADDR, and SO_REUSEPORT if reusePort is set, on fd
+// With SO_REUSEPORT several processes can bind the same port and the kernel
+// spreads new connections between them
+func setReuseOptions(fd uintptr, reusePort bool) error {
+    if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+        return err
+    }
+    if reusePort {
+        return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+    }
+    return nil
+}
+
+// kernelBacklogLimit returns net.core.somaxconn, the cap Linux puts on every
+// listen backlog
+func kernelBacklogLimit() (int, bool) {
+    data, err := os.ReadFile("/proc/sys/net/core/somaxconn")
+    if err != nil {
+        return 0, false
+    }
+    n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        return 0, false
+    }
+    return n, true
+}
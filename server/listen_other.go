@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+// setReuseOptions leaves the socket options alone on other platforms
+// On Windows SO_REUSEADDR lets another process steal a port that is in use,
+// which is not what we want, and Go already sets it on the other Unix systems
+func setReuseOptions(fd uintptr, reusePort bool) error {
+    return nil
+}
+
+// kernelBacklogLimit reports that the backlog limit is unknown here
+func kernelBacklogLimit() (int, bool) {
+    return 0, false
+}
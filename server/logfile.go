@@ -0,0 +1,77 @@
+// Package server implements the server log's destination
+// Log messages go to stdout unless -logfile names a file; the file is
+// reopened on SIGHUP so tools like logrotate can move it out of the way
+package server
+
+import (
+    "fmt"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+)
+
+// logFile is an io.Writer for log messages
+// It writes to stdout until a file is opened, and is safe for concurrent use
+type logFile struct {
+    mu   sync.Mutex  // Keeps a reopen from racing with writes
+    path string      // File to write to, empty for stdout
+    file *os.File    // The open file, nil for stdout
+}
+
+// logOutput is where every log message is written
+var logOutput = &logFile{}
+
+// Write writes p to the log file, or to stdout if there is none
+func (l *logFile) Write(p []byte) (int, error) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.file == nil {
+        return os.Stdout.Write(p)
+    }
+    return l.file.Write(p)
+}
+
+// Open starts writing to the file at path, appending to it and creating it if needed
+func (l *logFile) Open(path string) error {
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+    if err != nil {
+        return err
+    }
+
+    l.mu.Lock()
+    old := l.file
+    l.path, l.file = path, f
+    l.mu.Unlock()
+
+    if old != nil {
+        old.Close()
+    }
+    return nil
+}
+
+// Reopen opens the log file again by name
+// After logrotate renames the file, this starts a new one at the old path
+// rather than carrying on in the renamed (or deleted) one
+func (l *logFile) Reopen() error {
+    l.mu.Lock()
+    path := l.path
+    l.mu.Unlock()
+
+    if path == "" {
+        return nil
+    }
+    return l.Open(path)
+}
+
+// reopenLogOnSIGHUP reopens the log file every time the process gets SIGHUP
+func reopenLogOnSIGHUP() {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    for range hup {
+        // If the file can't be opened again, keep writing to the old one
+        if err := logOutput.Reopen(); err != nil {
+            fmt.Fprintln(logOutput, "Error reopening log file:", err)
+        }
+    }
+}
@@ -0,0 +1,259 @@
+// Package server implements memory accounting and maxmemory eviction
+// Memory use is an estimate: we count the bytes of keys, fields and values plus
+// a fixed per-entry overhead, which is close enough to enforce a cache size cap
+package server
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Estimated bookkeeping overhead of one key, hash field or sorted set member
+// (map bucket slot, string headers, etc.)
+const (
+    keyOverhead   = 64
+    fieldOverhead = 32
+)
+
+// denyOOMCommands lists the commands that can grow the dataset
+// When we're over maxmemory and can't evict, these are refused with an OOM error
+var denyOOMCommands = map[string]bool{
+    "SET":     true,
+    "HSET":    true,
+    "HINCRBY": true,
+    "ZADD":    true,
+    "ZINCRBY": true,
+    "SETRANGE": true,
+    "SETBIT":   true,
+    "HMSET":   true,
+    "HSETNX":  true,
+    "LPUSH":   true,
+    "RPUSH":   true,
+    "LPUSHX":  true,
+    "RPUSHX":  true,
+    "LSET":    true,
+    "LINSERT": true,
+    "LMOVE":   true,
+    "RPOPLPUSH": true,
+    "SADD":    true,
+    "SINTERSTORE": true,
+    "SUNIONSTORE": true,
+    "SDIFFSTORE":  true,
+    "RESTORE":     true,
+}
+
+// usedMemory is the estimated number of bytes held by the dataset
+// It is updated with atomics by every write path and read by the eviction check
+var usedMemory int64
+
+// trackMemory adjusts the memory estimate by delta bytes
+func trackMemory(delta int64) {
+    atomic.AddInt64(&usedMemory, delta)
+}
+
+// UsedMemory returns the current memory estimate in bytes
+func UsedMemory() int64 {
+    return atomic.LoadInt64(&usedMemory)
+}
+
+// setStringLocked stores a string value and updates the memory estimate
+// The caller must hold SETsMu for writing
+func setStringLocked(key, value string) {
+    if old, ok := SETs[key]; ok {
+        trackMemory(int64(len(value) - len(old)))
+    } else {
+        trackMemory(int64(keyOverhead + len(key) + len(value)))
+        indexKey(key)
+    }
+    SETs[key] = value
+}
+
+// setHashFieldLocked stores a hash field, creating the hash if needed,
+// and updates the memory estimate. It returns true if the field is new
+// The caller must hold HSETsMu for writing
+func setHashFieldLocked(hash, field, value string) bool {
+    fields, ok := HSETs[hash]
+    if !ok {
+        fields = map[string]string{}
+        HSETs[hash] = fields
+        trackMemory(int64(keyOverhead + len(hash)))
+        indexKey(hash)
+    }
+
+    old, exists := fields[field]
+    if exists {
+        trackMemory(int64(len(value) - len(old)))
+    } else {
+        trackMemory(int64(fieldOverhead + len(field) + len(value)))
+        if config.HashPreserveOrder() {
+            hashFieldOrder[hash] = append(hashFieldOrder[hash], field)
+        }
+    }
+    fields[field] = value
+
+    return !exists
+}
+
+// zsetMemberMemory is the estimated size of one sorted set member and its score
+func zsetMemberMemory(member string) int64 {
+    return int64(fieldOverhead + len(member) + 8)
+}
+
+// keyMemoryLocked estimates how many bytes the value at key occupies
+// The caller must hold at least the read lock of every store
+func keyMemoryLocked(key string) int64 {
+    if value, ok := SETs[key]; ok {
+        return valueMemory(key, value)
+    }
+    if fields, ok := HSETs[key]; ok {
+        return valueMemory(key, fields)
+    }
+    if zset, ok := ZSETs[key]; ok {
+        return valueMemory(key, zset)
+    }
+    if list, ok := LISTs[key]; ok {
+        return valueMemory(key, list)
+    }
+    if members, ok := SSETs[key]; ok {
+        return valueMemory(key, members)
+    }
+    return 0
+}
+
+// valueMemory estimates how many bytes key and its value occupy
+// value is anything a store holds, so it can be sized after being taken out
+// of its store; collections are walked element by element, which is O(n)
+func valueMemory(key string, value interface{}) int64 {
+    size := int64(keyOverhead + len(key))
+    switch value := value.(type) {
+    case string:
+        size += int64(len(value))
+    case map[string]string:
+        for field, v := range value {
+            size += int64(fieldOverhead + len(field) + len(v))
+        }
+    case *SortedSet:
+        for member := range value.scores {
+            size += zsetMemberMemory(member)
+        }
+    case []string:
+        for _, element := range value {
+            size += listElementMemory(element)
+        }
+    case map[string]struct{}:
+        for member := range value {
+            size += setMemberMemory(member)
+        }
+    }
+    return size
+}
+
+// accessTimes records when each key was last read or written
+// It drives the approximate LRU eviction policy
+var accessTimes = map[string]time.Time{}
+
+// accessTimesMu protects accessTimes
+// Like expirationsMu it is a leaf lock, never held while acquiring a store lock
+var accessTimesMu = sync.Mutex{}
+
+// touchKey marks key as accessed just now
+func touchKey(key string) {
+    accessTimesMu.Lock()
+    accessTimes[key] = time.Now()
+    accessTimesMu.Unlock()
+}
+
+// keyIdleTime reports how long ago key was last read or written, and whether it exists
+// Looking at the idle time doesn't count as an access
+func keyIdleTime(key string) (time.Duration, bool) {
+    expireIfNeeded(key)
+
+    rLockAllStores()
+    exists := keyExistsLocked(key)
+    accessTimesMu.Lock()
+    last, ok := accessTimes[key]
+    accessTimesMu.Unlock()
+    rUnlockAllStores()
+
+    if !exists {
+        return 0, false
+    }
+    if !ok {
+        return 0, true
+    }
+    return time.Since(last), true
+}
+
+// forgetKeyAccess drops the access time of a deleted key
+func forgetKeyAccess(key string) {
+    accessTimesMu.Lock()
+    delete(accessTimes, key)
+    accessTimesMu.Unlock()
+}
+
+// Number of keys sampled per eviction under allkeys-lru, like Redis's maxmemory-samples
+const evictionSamples = 5
+
+// evictionCandidate picks the next key to evict according to policy
+// Go randomizes map iteration order, so ranging over accessTimes gives us
+// cheap random samples without building a list of every key
+func evictionCandidate(policy string) (string, bool) {
+    accessTimesMu.Lock()
+    defer accessTimesMu.Unlock()
+
+    victim := ""
+    var oldest time.Time
+    sampled := 0
+    for key, accessed := range accessTimes {
+        if sampled == 0 || accessed.Before(oldest) {
+            victim, oldest = key, accessed
+        }
+        sampled++
+
+        // allkeys-random takes the first key, allkeys-lru the oldest of a few samples
+        if policy == "allkeys-random" || sampled == evictionSamples {
+            break
+        }
+    }
+
+    return victim, sampled > 0
+}
+
+// freeMemoryIfNeeded evicts keys until the dataset fits under maxmemory
+// It returns false if we're still over the limit, either because the policy
+// is noeviction or because there is nothing left to evict; the caller must
+// then refuse the write with an OOM error
+// Each evicted key is logged to aof as a DEL
+func freeMemoryIfNeeded(aof *Aof) bool {
+    limit := config.MaxMemory()
+    if limit == 0 {
+        return true
+    }
+
+    policy := config.MaxMemoryPolicy()
+    for UsedMemory() > limit {
+        if policy == "noeviction" {
+            return false
+        }
+
+        key, ok := evictionCandidate(policy)
+        if !ok {
+            return false
+        }
+
+        lockAllStores()
+        deleted := deleteKeyLocked(key)
+        unlockAllStores()
+
+        if deleted {
+            notifyKeyspaceEvent(notifyEvicted, "evicted", key)
+
+            // Replaying the AOF doesn't evict, and replicas don't evict on
+            // their own, so both are told what went
+            persist(aof, []Value{{typ: "array", array: []Value{{typ: "bulk", bulk: "DEL"}, {typ: "bulk", bulk: key}}}})
+        }
+    }
+
+    return true
+}
@@ -0,0 +1,105 @@
+// Package server implements a Prometheus metrics endpoint
+// When -metrics-port is set, an HTTP server on that port serves /metrics in
+// the Prometheus text exposition format, next to the RESP listeners
+package server
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// commandStat accumulates the runs of one command
+type commandStat struct {
+    calls atomic.Int64 // Number of runs
+    usec  atomic.Int64 // Total time spent in the handler, in microseconds
+}
+
+// commandStats holds the statistics of each command, by upper-case name
+// It is filled from Handlers once at startup and never changes shape
+// afterwards, so the map itself needs no lock; only the counters change
+var commandStats = map[string]*commandStat{}
+
+// errorReplies counts every error reply sent to a client
+var errorReplies atomic.Int64
+
+func init() {
+    for name := range Handlers {
+        commandStats[name] = &commandStat{}
+    }
+}
+
+// recordCommand counts one run of command that took dur
+func recordCommand(command string, dur time.Duration) {
+    if stat, ok := commandStats[command]; ok {
+        stat.calls.Add(1)
+        stat.usec.Add(dur.Microseconds())
+    }
+}
+
+// resetStats zeroes the command statistics and the error count, for CONFIG RESETSTAT
+func resetStats() {
+    for _, stat := range commandStats {
+        stat.calls.Store(0)
+        stat.usec.Store(0)
+    }
+    errorReplies.Store(0)
+}
+
+// serveMetrics runs srv as the metrics HTTP server until it fails or is closed
+func serveMetrics(srv *http.Server, aof *Aof) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        w.Write([]byte(formatMetrics(aof)))
+    })
+    mux.HandleFunc("/healthz", healthHandler(aof))
+    srv.Handler = mux
+
+    fmt.Fprintln(logOutput, "Serving metrics on " + srv.Addr + "/metrics")
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+        fmt.Fprintln(logOutput, "Metrics server stopped:", err)
+    }
+}
+
+// formatMetrics renders the current metrics in Prometheus text format
+func formatMetrics(aof *Aof) string {
+    var b strings.Builder
+
+    b.WriteString("# HELP redis_commands_processed_total Number of commands processed, by command.\n")
+    b.WriteString("# TYPE redis_commands_processed_total counter\n")
+    names := make([]string, 0, len(commandStats))
+    for name := range commandStats {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(&b, "redis_commands_processed_total{cmd=%q} %d\n", strings.ToLower(name), commandStats[name].calls.Load())
+    }
+
+    b.WriteString("# HELP redis_errors_total Number of error replies sent to clients.\n")
+    b.WriteString("# TYPE redis_errors_total counter\n")
+    fmt.Fprintf(&b, "redis_errors_total %d\n", errorReplies.Load())
+
+    clientsMu.RLock()
+    connected := len(clients)
+    clientsMu.RUnlock()
+    b.WriteString("# HELP redis_connected_clients Number of client connections.\n")
+    b.WriteString("# TYPE redis_connected_clients gauge\n")
+    fmt.Fprintf(&b, "redis_connected_clients %d\n", connected)
+
+    b.WriteString("# HELP redis_db_keys Number of keys, by database.\n")
+    b.WriteString("# TYPE redis_db_keys gauge\n")
+    fmt.Fprintf(&b, "redis_db_keys{db=\"db0\"} %d\n", len(allKeys()))
+
+    if size, err := aof.Size(); err == nil {
+        b.WriteString("# HELP redis_aof_size_bytes Size of the append only file, including buffered writes.\n")
+        b.WriteString("# TYPE redis_aof_size_bytes gauge\n")
+        fmt.Fprintf(&b, "redis_aof_size_bytes %d\n", size)
+    }
+
+    return b.String()
+}
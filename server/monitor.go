@@ -0,0 +1,100 @@
+// Package server implements the MONITOR command
+// A connection that sends MONITOR gets a line for every command any other
+// client runs from then on, which makes it easy to watch what a server does
+package server
+
+import (
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+)
+
+// monitors is the set of connections in monitor mode
+var monitors = map[*Client]bool{}
+
+// monitorsMu protects monitors
+// It is a leaf lock, apart from the writers of the monitors it writes to
+var monitorsMu = sync.RWMutex{}
+
+// monitor implements the Redis MONITOR command
+// It replies OK, then streams every command run by the other clients
+// The command format is: MONITOR
+func monitor(c *Client, args []Value) Value {
+    monitorsMu.Lock()
+    monitors[c] = true
+    monitorsMu.Unlock()
+
+    return Value{typ: "string", str: "OK"}
+}
+
+// stopMonitoring takes a disconnecting client out of monitor mode
+func stopMonitoring(c *Client) {
+    monitorsMu.Lock()
+    delete(monitors, c)
+    monitorsMu.Unlock()
+}
+
+// feedMonitors sends the command value, about to be run by c, to every monitor
+// A monitor's own commands aren't echoed, to it or to the others
+func feedMonitors(c *Client, value Value) {
+    monitorsMu.RLock()
+    defer monitorsMu.RUnlock()
+
+    if len(monitors) == 0 || monitors[c] {
+        return
+    }
+
+    // Like Redis: <seconds>.<microseconds> [<db> <addr>] "command" "arg" ...
+    now := time.Now()
+    var b strings.Builder
+    fmt.Fprintf(&b, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, c.conn.RemoteAddr().String())
+
+    // Passwords never show up in the stream
+    redacted := strings.EqualFold(value.array[0].bulk, "AUTH")
+    for i, arg := range value.array {
+        b.WriteString(" ")
+        if redacted && i > 0 {
+            b.WriteString(`"(redacted)"`)
+            continue
+        }
+        b.WriteString(quoteMonitorArg(arg.bulk))
+    }
+
+    line := Value{typ: "string", str: b.String()}
+    for m := range monitors {
+        m.writer.Write(line)
+    }
+}
+
+// quoteMonitorArg quotes s the way Redis prints arguments in MONITOR, escaping
+// quotes, backslashes and unprintable bytes so the line stays on one line
+func quoteMonitorArg(s string) string {
+    var b strings.Builder
+    b.WriteByte('"')
+    for i := 0; i < len(s); i++ {
+        switch ch := s[i]; ch {
+        case '\\', '"':
+            b.WriteByte('\\')
+            b.WriteByte(ch)
+        case '\n':
+            b.WriteString(`\n`)
+        case '\r':
+            b.WriteString(`\r`)
+        case '\t':
+            b.WriteString(`\t`)
+        case '\a':
+            b.WriteString(`\a`)
+        case '\b':
+            b.WriteString(`\b`)
+        default:
+            if ch < 0x20 || ch > 0x7e {
+                fmt.Fprintf(&b, `\x%02x`, ch)
+            } else {
+                b.WriteByte(ch)
+            }
+        }
+    }
+    b.WriteByte('"')
+    return b.String()
+}
@@ -0,0 +1,242 @@
+// Package server implements transactions: MULTI/EXEC/DISCARD and WATCH/UNWATCH
+// Between MULTI and EXEC a client's commands are queued instead of run, and EXEC
+// runs them all with no other command in between. WATCH adds optimistic locking:
+// if a watched key changes before EXEC, the transaction is not run at all
+package server
+
+import "sync"
+
+// execMu keeps EXEC atomic
+// Every command holds it for reading while it runs and EXEC holds it for writing,
+// so no other command can run between EXEC checking the watched keys and the
+// last queued command finishing. It is taken before any store lock
+var execMu = sync.RWMutex{}
+
+// writeMu puts writes in the AOF and the replication stream in the order they
+// were applied. A write command holds it from before it changes the dataset
+// until its records are persisted and propagated, so two writes to the same
+// key can't be logged the other way round. It is taken after execMu and
+// before any store lock
+var writeMu = sync.Mutex{}
+
+// keyVersions holds the version of every key that exists or is watched
+// WATCH remembers a key's version and EXEC compares it again. Versions come
+// from versionClock, so a key deleted and recreated after WATCH gets a newer
+// one and still looks changed. A key without an entry is at version 0
+// A deleted key loses its entry unless a client watches it, so the map
+// doesn't grow with every key ever written
+var keyVersions = map[string]uint64{}
+
+// keyWatchers counts the clients watching each key
+var keyWatchers = map[string]int{}
+
+// versionClock is the last version handed out to a key
+var versionClock uint64
+
+// keyVersionsMu protects keyVersions, keyWatchers and versionClock
+// It is a leaf lock, taken either on its own or under scanIndexMu, which
+// tells whether a key still exists
+var keyVersionsMu = sync.Mutex{}
+
+// transactionCommands are run straight away even inside MULTI
+var transactionCommands = map[string]bool{
+    "MULTI":   true,
+    "EXEC":    true,
+    "DISCARD": true,
+    "WATCH":   true,
+    "QUIT":    true,
+}
+
+// queuedCommand is a command waiting in a transaction for EXEC
+type queuedCommand struct {
+    name  string
+    cmd   Command
+    value Value
+}
+
+// signalModifiedKey bumps the version of key, failing EXEC for every client
+// watching it, and invalidates it for clients caching it (see tracking.go)
+func signalModifiedKey(key string) {
+    scanIndexMu.Lock()
+    keyVersionsMu.Lock()
+    versionClock++
+    if keyWatchers[key] > 0 || indexedLocked(key) {
+        keyVersions[key] = versionClock
+    }
+    keyVersionsMu.Unlock()
+    scanIndexMu.Unlock()
+
+    invalidateKey(key)
+}
+
+// forgetKeyVersionLocked drops the version of key, which no store holds any
+// more, unless a client watches it
+// The caller must hold scanIndexMu
+func forgetKeyVersionLocked(key string) {
+    keyVersionsMu.Lock()
+    defer keyVersionsMu.Unlock()
+    if keyWatchers[key] == 0 {
+        delete(keyVersions, key)
+    }
+}
+
+// touchWatchedKeys gives every watched key a new version, failing EXEC for
+// all their watchers, for when the whole dataset is replaced at once
+func touchWatchedKeys() {
+    keyVersionsMu.Lock()
+    defer keyVersionsMu.Unlock()
+
+    versionClock++
+    for key := range keyWatchers {
+        keyVersions[key] = versionClock
+    }
+}
+
+// watchKey starts watching key for one more client and returns its version
+func watchKey(key string) uint64 {
+    keyVersionsMu.Lock()
+    defer keyVersionsMu.Unlock()
+
+    keyWatchers[key]++
+    return keyVersions[key]
+}
+
+// unwatchKeys forgets every key c watches
+// A key nobody watches any more keeps its version only if it still exists
+func unwatchKeys(c *Client) {
+    if len(c.watched) == 0 {
+        c.watched = nil
+        return
+    }
+
+    scanIndexMu.Lock()
+    keyVersionsMu.Lock()
+    for key := range c.watched {
+        keyWatchers[key]--
+        if keyWatchers[key] > 0 {
+            continue
+        }
+        delete(keyWatchers, key)
+        if !indexedLocked(key) {
+            delete(keyVersions, key)
+        }
+    }
+    keyVersionsMu.Unlock()
+    scanIndexMu.Unlock()
+
+    c.watched = nil
+}
+
+// keyVersion returns the current version of key
+func keyVersion(key string) uint64 {
+    keyVersionsMu.Lock()
+    defer keyVersionsMu.Unlock()
+    return keyVersions[key]
+}
+
+// watchedKeysChanged reports whether any key c watches changed since WATCH
+// A key that expired since WATCH counts as changed even if nobody has looked it up yet
+func watchedKeysChanged(c *Client) bool {
+    for key, version := range c.watched {
+        if keyVersion(key) != version || isExpired(key) {
+            return true
+        }
+    }
+    return false
+}
+
+// discardTransaction ends the transaction of c, if any, and forgets its watched keys
+func discardTransaction(c *Client) {
+    c.inMulti = false
+    c.queued = nil
+    c.multiAborted = false
+    unwatchKeys(c)
+}
+
+// multi implements the Redis MULTI command
+// It starts a transaction: the following commands are queued until EXEC
+// The command format is: MULTI
+func multi(c *Client, args []Value) Value {
+    if c.inMulti {
+        return Value{typ: "error", str: "ERR MULTI calls can not be nested"}
+    }
+
+    c.inMulti = true
+    return Value{typ: "string", str: "OK"}
+}
+
+// exec implements the Redis EXEC command
+// It runs the queued commands and replies with an array of their replies
+// If a watched key changed, nothing is run and the reply is a null array
+// The command format is: EXEC
+func exec(c *Client, args []Value) Value {
+    if !c.inMulti {
+        return Value{typ: "error", str: "ERR EXEC without MULTI"}
+    }
+
+    queued, aborted := c.queued, c.multiAborted
+    changed := watchedKeysChanged(c)
+    discardTransaction(c)
+
+    if aborted {
+        return Value{typ: "error", str: "EXECABORT Transaction discarded because of previous errors."}
+    }
+    if changed {
+        return Value{typ: "null_array"}
+    }
+
+    // The caller holds execMu for writing, so the commands run back to back
+    c.inExec = true
+    defer func() { c.inExec = false }()
+    replies := make([]Value, 0, len(queued))
+    for _, q := range queued {
+        replies = append(replies, call(c, q.name, q.cmd, q.value))
+    }
+    return Value{typ: "array", array: replies}
+}
+
+// discard implements the Redis DISCARD command
+// It drops the queued commands and unwatches every key
+// The command format is: DISCARD
+func discard(c *Client, args []Value) Value {
+    if !c.inMulti {
+        return Value{typ: "error", str: "ERR DISCARD without MULTI"}
+    }
+
+    discardTransaction(c)
+    return Value{typ: "string", str: "OK"}
+}
+
+// watch implements the Redis WATCH command
+// It marks keys to be checked by the next EXEC
+// The command format is: WATCH key [key ...]
+func watch(c *Client, args []Value) Value {
+    if c.inMulti {
+        return Value{typ: "error", str: "ERR WATCH inside MULTI is not allowed"}
+    }
+
+    if c.watched == nil {
+        c.watched = map[string]uint64{}
+    }
+    for _, arg := range args {
+        key := arg.bulk
+        // Watching a key twice keeps the version from the first WATCH
+        if _, ok := c.watched[key]; ok {
+            continue
+        }
+        // Delete the key first if it has already expired, so EXEC only sees
+        // expiries that happen after this point
+        expireIfNeeded(key)
+        c.watched[key] = watchKey(key)
+    }
+
+    return Value{typ: "string", str: "OK"}
+}
+
+// unwatch implements the Redis UNWATCH command
+// It forgets every watched key
+// The command format is: UNWATCH
+func unwatch(c *Client, args []Value) Value {
+    unwatchKeys(c)
+    return Value{typ: "string", str: "OK"}
+}
@@ -0,0 +1,61 @@
+// Package server tests transactions and the key versions behind WATCH
+package server
+
+import "testing"
+
+// storedVersion reports whether keyVersions holds an entry for key
+func storedVersion(key string) bool {
+    keyVersionsMu.Lock()
+    defer keyVersionsMu.Unlock()
+    _, ok := keyVersions[key]
+    return ok
+}
+
+// TestKeyVersionsOnlyForLiveOrWatchedKeys checks that a deleted key keeps its
+// version only while a client watches it
+func TestKeyVersionsOnlyForLiveOrWatchedKeys(t *testing.T) {
+    c := newTestClient(t)
+
+    run(c, "SET", "k", "v")
+    if !storedVersion("k") {
+        t.Fatal("an existing key has no version")
+    }
+    run(c, "DEL", "k")
+    if storedVersion("k") {
+        t.Fatal("a deleted key nobody watches kept its version")
+    }
+
+    run(c, "SET", "w", "v")
+    run(c, "WATCH", "w")
+    run(c, "DEL", "w")
+    if !storedVersion("w") {
+        t.Fatal("a deleted key lost its version while watched")
+    }
+    run(c, "UNWATCH")
+    if storedVersion("w") {
+        t.Fatal("a deleted key kept its version after its last watcher left")
+    }
+}
+
+// TestWatchSeesReload checks that replacing the dataset fails EXEC for a
+// client watching a key, even one whose value comes back the same
+func TestWatchSeesReload(t *testing.T) {
+    for _, key := range []string{"k", "missing"} {
+        t.Run(key, func(t *testing.T) {
+            c := newTestClient(t)
+            other := newPeerClient(t, c.aof)
+            run(c, "SET", "k", "v")
+
+            run(c, "WATCH", key)
+            if reply := run(other, "DEBUG", "RELOAD"); reply.typ == "error" {
+                t.Fatal(reply.str)
+            }
+            run(c, "MULTI")
+            run(c, "SET", "k", "w")
+            if reply := run(c, "EXEC"); reply.typ != "null_array" {
+                t.Fatalf("EXEC after DEBUG RELOAD: got %+v, want a null array", reply)
+            }
+            expectBulk(t, run(c, "GET", "k"), "v")
+        })
+    }
+}
@@ -0,0 +1,115 @@
+// Package server implements keyspace notifications
+// When enabled, changes to keys are published as pub/sub messages on the special
+// __keyspace@0__:<key> and __keyevent@0__:<event> channels, so clients can
+// react to writes, deletions and expirations (e.g. to invalidate a cache)
+package server
+
+import (
+    "errors"
+    "strings"
+)
+
+// Keyspace notification classes, selected with the notify-keyspace-events
+// config parameter using the same letters as Redis
+const (
+    notifyKeyspace = 1 << iota  // K: publish on __keyspace@0__:<key>
+    notifyKeyevent              // E: publish on __keyevent@0__:<event>
+    notifyGeneric               // g: generic commands like DEL and EXPIRE
+    notifyString                // $: string commands
+    notifyList                  // l: list commands
+    notifySet                   // s: set commands
+    notifyHash                  // h: hash commands
+    notifyZset                  // z: sorted set commands
+    notifyExpired               // x: a key expired
+    notifyEvicted               // e: a key was evicted by maxmemory
+    notifyStream                // t: stream commands
+    notifyKeyMiss               // m: a key was looked up but missing
+    notifyNew                   // n: a new key was created
+
+    // A: alias for g$lshzxet
+    notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+        notifyZset | notifyExpired | notifyEvicted | notifyStream
+)
+
+// notifyFlagLetters pairs each class with its letter, in the order
+// formatKeyspaceEvents prints them
+var notifyFlagLetters = []struct {
+    flag   int
+    letter byte
+}{
+    {notifyGeneric, 'g'},
+    {notifyString, '$'},
+    {notifyList, 'l'},
+    {notifySet, 's'},
+    {notifyHash, 'h'},
+    {notifyZset, 'z'},
+    {notifyExpired, 'x'},
+    {notifyEvicted, 'e'},
+    {notifyStream, 't'},
+    {notifyKeyMiss, 'm'},
+    {notifyNew, 'n'},
+    {notifyKeyspace, 'K'},
+    {notifyKeyevent, 'E'},
+}
+
+// parseKeyspaceEvents converts a notify-keyspace-events string such as "KEA" into flags
+func parseKeyspaceEvents(s string) (int, error) {
+    flags := 0
+    for i := 0; i < len(s); i++ {
+        if s[i] == 'A' {
+            flags |= notifyAll
+            continue
+        }
+
+        found := false
+        for _, f := range notifyFlagLetters {
+            if f.letter == s[i] {
+                flags |= f.flag
+                found = true
+                break
+            }
+        }
+        if !found {
+            return 0, errors.New("unknown keyspace event class")
+        }
+    }
+    return flags, nil
+}
+
+// formatKeyspaceEvents converts flags back into their letters, using A where possible
+func formatKeyspaceEvents(flags int) string {
+    var b strings.Builder
+    if flags&notifyAll == notifyAll {
+        b.WriteByte('A')
+        flags &^= notifyAll
+    }
+    for _, f := range notifyFlagLetters {
+        if flags&f.flag != 0 {
+            b.WriteByte(f.letter)
+        }
+    }
+    return b.String()
+}
+
+// notifyKeyspaceEvent publishes that event happened to key, if class is enabled
+// Callers should avoid holding store locks where they can, since delivering
+// the message writes to the subscribers' connections
+func notifyKeyspaceEvent(class int, event, key string) {
+    // Every change to a key is reported here, so this is also where its
+    // version is bumped for WATCH, whether or not anyone listens for events
+    signalModifiedKey(key)
+
+    flags := config.NotifyKeyspaceEvents()
+
+    // Nothing is published unless the class is enabled and at least one of K or E is
+    if flags&class == 0 || flags&(notifyKeyspace|notifyKeyevent) == 0 {
+        return
+    }
+
+    if flags&notifyKeyspace != 0 {
+        publish("__keyspace@0__:"+key, event)
+    }
+    if flags&notifyKeyevent != 0 {
+        publish("__keyevent@0__:"+event, key)
+    }
+}
@@ -0,0 +1,423 @@
+// Package server implements publish/subscribe messaging
+// Clients SUBSCRIBE to channels, or PSUBSCRIBE to glob-style channel patterns,
+// and receive every message PUBLISHed to a matching channel
+package server
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// pubsubChannels maps each channel to the set of clients subscribed to it
+var pubsubChannels = map[string]map[*Client]bool{}
+
+// pubsubPatterns maps each pattern to the set of clients subscribed to it
+var pubsubPatterns = map[string]map[*Client]bool{}
+
+// pubsubMu protects pubsubChannels, pubsubPatterns and each client's
+// channels and patterns sets
+var pubsubMu = sync.RWMutex{}
+
+// subscribeContextCommands are the only commands a RESP2 client may send while
+// it has subscriptions. Anything else would have its reply mixed in with the
+// pushed messages, which RESP2 clients can't tell apart. RESP3 marks pushes
+// differently, so there every command is allowed
+var subscribeContextCommands = map[string]bool{
+    "SUBSCRIBE":    true,
+    "UNSUBSCRIBE":  true,
+    "PSUBSCRIBE":   true,
+    "PUNSUBSCRIBE": true,
+    "PING":         true,
+    "QUIT":         true,
+}
+
+// subscriptionCount returns how many channels and patterns c is subscribed to
+// Only c's own connection handler changes its subscriptions, so it may call this without pubsubMu
+func (c *Client) subscriptionCount() int {
+    return len(c.channels) + len(c.patterns)
+}
+
+// subscribed reports whether c is in subscribe mode, that is has at least one subscription
+func (c *Client) subscribed() bool {
+    return c.subscriptionCount() > 0
+}
+
+// publish delivers message to every subscriber of channel, and to every
+// subscriber of a pattern matching channel
+// It returns the number of deliveries; a client subscribed to both the channel
+// and a matching pattern, or to several matching patterns, counts once for each
+//
+// Messages are queued for each subscriber rather than written straight away,
+// so a slow subscriber can't hold up the publisher, see pushQueue
+func publish(channel, message string) int {
+    msg := Value{typ: "array", array: []Value{
+        {typ: "bulk", bulk: "message"},
+        {typ: "bulk", bulk: channel},
+        {typ: "bulk", bulk: message},
+    }}
+    size := int64(messageOverhead + len(channel) + len(message))
+    limits := config.PubsubOutputLimits()
+
+    pubsubMu.RLock()
+    defer pubsubMu.RUnlock()
+
+    for c := range pubsubChannels[channel] {
+        deliver(c, msg, size, limits)
+    }
+    received := len(pubsubChannels[channel])
+
+    // Pattern subscribers also learn which pattern matched
+    for pattern, subscribers := range pubsubPatterns {
+        if !matchPattern(pattern, channel) {
+            continue
+        }
+        pmsg := Value{typ: "array", array: []Value{
+            {typ: "bulk", bulk: "pmessage"},
+            {typ: "bulk", bulk: pattern},
+            {typ: "bulk", bulk: channel},
+            {typ: "bulk", bulk: message},
+        }}
+        for c := range subscribers {
+            deliver(c, pmsg, size+int64(len(pattern)), limits)
+        }
+        received += len(subscribers)
+    }
+
+    return received
+}
+
+// deliver queues a published message for subscriber c
+// A subscriber that has let too much pile up is disconnected instead, like
+// Redis does once a client goes over its output buffer limits
+// The caller must hold pubsubMu, for reading at least
+func deliver(c *Client, msg Value, size int64, limits outputLimits) {
+    if c.pushes.push(msg, size, limits) {
+        return
+    }
+    fmt.Fprintln(logOutput, "Closing subscriber", c.conn.RemoteAddr(), "for going over the pubsub output buffer limits")
+    c.conn.Close()
+}
+
+// subscriptionReply builds the confirmation sent for each (un)subscribed channel
+func subscriptionReply(kind string, channel Value, count int) Value {
+    return Value{typ: "array", array: []Value{
+        {typ: "bulk", bulk: kind},
+        channel,
+        {typ: "integer", num: count},
+    }}
+}
+
+// subscribe implements the Redis SUBSCRIBE command
+// Each channel gets its own ["subscribe", channel, count] reply
+// The command format is: SUBSCRIBE channel [channel ...]
+func subscribe(c *Client, args []Value) Value {
+    pubsubMu.Lock()
+    c.startPushQueue()
+    replies := []Value{}
+    for _, arg := range args {
+        channel := arg.bulk
+        if !c.channels[channel] {
+            c.channels[channel] = true
+            if pubsubChannels[channel] == nil {
+                pubsubChannels[channel] = map[*Client]bool{}
+            }
+            pubsubChannels[channel][c] = true
+        }
+        replies = append(replies, subscriptionReply("subscribe", arg, c.subscriptionCount()))
+    }
+    pubsubMu.Unlock()
+
+    // Send all but the last confirmation now; the last one is our reply
+    for _, reply := range replies[:len(replies)-1] {
+        c.writer.Write(reply)
+    }
+    return replies[len(replies)-1]
+}
+
+// unsubscribe implements the Redis UNSUBSCRIBE command
+// Without arguments the client is unsubscribed from every channel
+// The command format is: UNSUBSCRIBE [channel ...]
+func unsubscribe(c *Client, args []Value) Value {
+    pubsubMu.Lock()
+
+    // No arguments means every channel we're subscribed to
+    if len(args) == 0 {
+        for channel := range c.channels {
+            args = append(args, Value{typ: "bulk", bulk: channel})
+        }
+    }
+
+    replies := []Value{}
+    for _, arg := range args {
+        unsubscribeLocked(c, arg.bulk)
+        replies = append(replies, subscriptionReply("unsubscribe", arg, c.subscriptionCount()))
+    }
+    pubsubMu.Unlock()
+
+    // Unsubscribing from nothing still gets a single reply with a null channel
+    if len(replies) == 0 {
+        return subscriptionReply("unsubscribe", Value{typ: "null"}, c.subscriptionCount())
+    }
+
+    for _, reply := range replies[:len(replies)-1] {
+        c.writer.Write(reply)
+    }
+    return replies[len(replies)-1]
+}
+
+// unsubscribeLocked removes c from channel
+// The caller must hold pubsubMu for writing
+func unsubscribeLocked(c *Client, channel string) {
+    delete(c.channels, channel)
+    if subscribers, ok := pubsubChannels[channel]; ok {
+        delete(subscribers, c)
+        if len(subscribers) == 0 {
+            delete(pubsubChannels, channel)
+        }
+    }
+}
+
+// psubscribe implements the Redis PSUBSCRIBE command
+// Each pattern gets its own ["psubscribe", pattern, count] reply
+// The command format is: PSUBSCRIBE pattern [pattern ...]
+func psubscribe(c *Client, args []Value) Value {
+    pubsubMu.Lock()
+    c.startPushQueue()
+    replies := []Value{}
+    for _, arg := range args {
+        pattern := arg.bulk
+        if !c.patterns[pattern] {
+            c.patterns[pattern] = true
+            if pubsubPatterns[pattern] == nil {
+                pubsubPatterns[pattern] = map[*Client]bool{}
+            }
+            pubsubPatterns[pattern][c] = true
+        }
+        replies = append(replies, subscriptionReply("psubscribe", arg, c.subscriptionCount()))
+    }
+    pubsubMu.Unlock()
+
+    // Send all but the last confirmation now; the last one is our reply
+    for _, reply := range replies[:len(replies)-1] {
+        c.writer.Write(reply)
+    }
+    return replies[len(replies)-1]
+}
+
+// punsubscribe implements the Redis PUNSUBSCRIBE command
+// Without arguments the client is unsubscribed from every pattern
+// The command format is: PUNSUBSCRIBE [pattern ...]
+func punsubscribe(c *Client, args []Value) Value {
+    pubsubMu.Lock()
+
+    // No arguments means every pattern we're subscribed to
+    if len(args) == 0 {
+        for pattern := range c.patterns {
+            args = append(args, Value{typ: "bulk", bulk: pattern})
+        }
+    }
+
+    replies := []Value{}
+    for _, arg := range args {
+        punsubscribeLocked(c, arg.bulk)
+        replies = append(replies, subscriptionReply("punsubscribe", arg, c.subscriptionCount()))
+    }
+    pubsubMu.Unlock()
+
+    // Unsubscribing from nothing still gets a single reply with a null pattern
+    if len(replies) == 0 {
+        return subscriptionReply("punsubscribe", Value{typ: "null"}, c.subscriptionCount())
+    }
+
+    for _, reply := range replies[:len(replies)-1] {
+        c.writer.Write(reply)
+    }
+    return replies[len(replies)-1]
+}
+
+// punsubscribeLocked removes c from pattern
+// The caller must hold pubsubMu for writing
+func punsubscribeLocked(c *Client, pattern string) {
+    delete(c.patterns, pattern)
+    if subscribers, ok := pubsubPatterns[pattern]; ok {
+        delete(subscribers, c)
+        if len(subscribers) == 0 {
+            delete(pubsubPatterns, pattern)
+        }
+    }
+}
+
+// unsubscribeAll drops every subscription of a disconnecting client
+func unsubscribeAll(c *Client) {
+    pubsubMu.Lock()
+    defer pubsubMu.Unlock()
+
+    for channel := range c.channels {
+        unsubscribeLocked(c, channel)
+    }
+    for pattern := range c.patterns {
+        punsubscribeLocked(c, pattern)
+    }
+
+    // Nothing can be published to c any more, so its writer can finish
+    if c.pushes != nil {
+        c.pushes.close()
+        c.pushes = nil
+    }
+}
+
+// publishCommand implements the Redis PUBLISH command
+// It returns the number of clients that received the message
+// The command format is: PUBLISH channel message
+func publishCommand(c *Client, args []Value) Value {
+    return Value{typ: "integer", num: publish(args[0].bulk, args[1].bulk)}
+}
+
+// pubsubCommand implements the Redis PUBSUB command family
+// The command format is: PUBSUB <subcommand> [arguments ...]
+// Supported subcommands:
+//   PUBSUB CHANNELS [pattern]        - channels with at least one subscriber, optionally filtered
+//   PUBSUB NUMSUB [channel ...]      - [channel, subscriber count, ...] for the given channels
+//   PUBSUB NUMPAT                    - number of patterns with at least one subscriber
+func pubsubCommand(c *Client, args []Value) Value {
+    pubsubMu.RLock()
+    defer pubsubMu.RUnlock()
+
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch {
+    case subcommand == "CHANNELS" && len(args) <= 2:
+        // Pattern subscriptions don't count, like in Redis
+        channels := []string{}
+        for channel := range pubsubChannels {
+            if len(args) == 1 || matchPattern(args[1].bulk, channel) {
+                channels = append(channels, channel)
+            }
+        }
+        sort.Strings(channels)
+
+        values := make([]Value, 0, len(channels))
+        for _, channel := range channels {
+            values = append(values, Value{typ: "bulk", bulk: channel})
+        }
+        return Value{typ: "array", array: values}
+    case subcommand == "NUMSUB":
+        values := make([]Value, 0, 2*(len(args)-1))
+        for _, arg := range args[1:] {
+            values = append(values, arg, Value{typ: "integer", num: len(pubsubChannels[arg.bulk])})
+        }
+        return Value{typ: "array", array: values}
+    case subcommand == "NUMPAT" && len(args) == 1:
+        return Value{typ: "integer", num: len(pubsubPatterns)}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try PUBSUB HELP."}
+    }
+}
+
+// messageOverhead is roughly what a published message adds to its channel
+// name and payload once framed as RESP, for the output buffer limits
+const messageOverhead = 32
+
+// pushQueue holds the published messages waiting to be written to one subscriber
+// Publishers only append to it; a goroutine per subscriber does the writing,
+// so a subscriber that reads slowly, or not at all, only holds up itself
+type pushQueue struct {
+    mu        sync.Mutex
+    pending   []queuedPush
+    bytes     int64          // Estimated size of pending and of the batch being written
+    softSince time.Time      // When bytes went over the soft limit, zero while under it
+    closed    bool           // No more messages will be pushed
+    wake      chan struct{}  // Signals the writer that there's something to do
+}
+
+// queuedPush is one message in a pushQueue, with its estimated size
+type queuedPush struct {
+    value Value
+    size  int64
+}
+
+// startPushQueue gives c a queue for published messages, if it has none yet,
+// and starts the goroutine that writes them to c
+// The caller must hold pubsubMu for writing
+func (c *Client) startPushQueue() {
+    if c.pushes != nil {
+        return
+    }
+    q := &pushQueue{wake: make(chan struct{}, 1)}
+    c.pushes = q
+    go q.run(c.writer)
+}
+
+// push queues msg, of estimated size bytes, unless that takes the queue over
+// limits. It returns false if it did, in which case the subscriber should be
+// dropped: over the hard limit at once, over the soft limit once that has
+// lasted longer than the soft limit's duration
+func (q *pushQueue) push(msg Value, size int64, limits outputLimits) bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return true
+    }
+
+    total := q.bytes + size
+    if limits.hard > 0 && total > limits.hard {
+        return false
+    }
+    if limits.soft > 0 && total > limits.soft {
+        if q.softSince.IsZero() {
+            q.softSince = time.Now()
+        } else if time.Since(q.softSince) > limits.softDuration {
+            return false
+        }
+    } else {
+        q.softSince = time.Time{}
+    }
+
+    q.pending = append(q.pending, queuedPush{msg, size})
+    q.bytes = total
+
+    // The writer may already have a wake-up waiting, which is just as good
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+    return true
+}
+
+// close stops the writer once it has written what is already queued
+func (q *pushQueue) close() {
+    q.mu.Lock()
+    q.closed = true
+    q.mu.Unlock()
+
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+}
+
+// run writes queued messages to w until the queue is closed and empty
+func (q *pushQueue) run(w *Writer) {
+    for range q.wake {
+        q.mu.Lock()
+        batch, closed := q.pending, q.closed
+        q.pending = nil
+        q.mu.Unlock()
+
+        // Once a write fails the connection is gone, and the rest are dropped
+        // by the Writer, which remembers the error
+        for _, p := range batch {
+            w.Write(p.value)
+            q.mu.Lock()
+            q.bytes -= p.size
+            q.mu.Unlock()
+        }
+
+        if closed {
+            return
+        }
+    }
+}
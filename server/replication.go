@@ -0,0 +1,418 @@
+// Package server implements master/replica replication
+// A replica connects to its master with REPLICAOF host port, receives a
+// snapshot of the whole dataset and then every write the master runs, so
+// it can serve reads of the same data. The handshake follows Redis's (PING,
+// REPLCONF, PSYNC), but the snapshot is in our AOF format rather than an RDB
+// file, so a replica of this server must be this server too
+//
+// There is no partial resync: a replica that loses its link reconnects and
+// loads a fresh snapshot. Replicas don't acknowledge what they've applied,
+// so WAIT still counts none
+package server
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// replicas maps each connected replica to the queue of records waiting to be sent to it
+var replicas = map[*Client]*pushQueue{}
+
+// replicasMu protects replicas and replOffset
+// It is taken after execMu and is otherwise a leaf lock
+var replicasMu = sync.RWMutex{}
+
+// replOffset counts the bytes of replication stream sent since the first
+// replica connected, Redis's master_repl_offset
+var replOffset int64
+
+// replID identifies this server's replication stream, Redis's master_replid
+var replID = newReplID()
+
+// replicaOutputLimits bounds how far a replica may fall behind before it is
+// disconnected and has to sync again, Redis's "replica 256mb 64mb 60"
+var replicaOutputLimits = outputLimits{256 * 1024 * 1024, 64 * 1024 * 1024, 60 * time.Second}
+
+// replicaListeningPort is the TCP port this server accepts clients on, which
+// it tells its master in the handshake, for the master's INFO
+var replicaListeningPort int
+
+// The replica side: the master we replicate from, if any
+var (
+    masterHost   string         // Empty while we're a master
+    masterPort   string
+    masterLinkUp bool           // The snapshot is loaded and the stream is being applied
+    masterStop   chan struct{}  // Closed to stop replicating from the current master
+    masterConn   net.Conn       // Link to the master, nil while connecting
+    masterMu     sync.Mutex     // Protects the master* variables
+)
+
+// masterDialTimeout bounds connecting to the master, and each handshake reply
+const masterDialTimeout = 5 * time.Second
+
+// masterRetryDelay is how long a replica waits before reconnecting to its master
+const masterRetryDelay = time.Second
+
+// newReplID returns a random 40-character hex replication id
+func newReplID() string {
+    b := make([]byte, 20)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// replicating reports whether this server is a replica
+func replicating() bool {
+    masterMu.Lock()
+    defer masterMu.Unlock()
+    return masterHost != ""
+}
+
+// replicaof implements the Redis REPLICAOF command, and SLAVEOF, its old name
+// REPLICAOF host port makes this server a replica of that master, dropping
+// its own dataset once the master's snapshot arrives. REPLICAOF NO ONE makes
+// it a master again, keeping the data it has
+// The command format is: REPLICAOF host port | REPLICAOF NO ONE
+func replicaof(c *Client, args []Value) Value {
+    if strings.EqualFold(args[0].bulk, "NO") && strings.EqualFold(args[1].bulk, "ONE") {
+        masterMu.Lock()
+        if masterHost != "" {
+            fmt.Fprintln(logOutput, "Stopped replicating from "+net.JoinHostPort(masterHost, masterPort))
+        }
+        stopReplicationLocked()
+        masterMu.Unlock()
+        return Value{typ: "string", str: "OK"}
+    }
+
+    host, port := args[0].bulk, args[1].bulk
+    if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+        return Value{typ: "error", str: "ERR Invalid master port"}
+    }
+
+    masterMu.Lock()
+    defer masterMu.Unlock()
+
+    if masterHost == host && masterPort == port {
+        return Value{typ: "string", str: "OK Already connected to specified master"}
+    }
+    stopReplicationLocked()
+
+    masterHost, masterPort = host, port
+    masterStop = make(chan struct{})
+    go replicate(net.JoinHostPort(host, port), masterStop, c.aof)
+
+    fmt.Fprintln(logOutput, "Replicating from "+net.JoinHostPort(host, port))
+    return Value{typ: "string", str: "OK"}
+}
+
+// stopReplicationLocked stops replicating from the current master, if any
+// The caller must hold masterMu
+func stopReplicationLocked() {
+    if masterStop != nil {
+        close(masterStop)
+        masterStop = nil
+    }
+    if masterConn != nil {
+        masterConn.Close()
+        masterConn = nil
+    }
+    masterHost, masterPort = "", ""
+    masterLinkUp = false
+}
+
+// replicate is replicateFrom, assigned in init: the replica applies the
+// master's commands through dispatch, which looks them up in Handlers, so
+// REPLICAOF naming it directly would make Handlers depend on itself
+var replicate func(addr string, stop chan struct{}, aof *Aof)
+
+func init() {
+    replicate = replicateFrom
+}
+
+// replicateFrom keeps this server in sync with the master at addr until stop
+// is closed, reconnecting whenever the link is lost
+// Writes from the master are logged to aof, like any other write
+func replicateFrom(addr string, stop chan struct{}, aof *Aof) {
+    for {
+        err := syncWithMaster(addr, stop, aof)
+
+        select {
+        case <-stop:
+            return
+        default:
+        }
+        fmt.Fprintf(logOutput, "Lost connection to master %s: %v; reconnecting in %v\n", addr, err, masterRetryDelay)
+
+        select {
+        case <-stop:
+            return
+        case <-time.After(masterRetryDelay):
+        }
+    }
+}
+
+// syncWithMaster connects to the master at addr, loads its snapshot and then
+// applies the commands it streams, until the link breaks or stop is closed
+func syncWithMaster(addr string, stop chan struct{}, aof *Aof) error {
+    // Keepalive probes notice a master that went away without closing the link
+    dialer := net.Dialer{Timeout: masterDialTimeout, KeepAlive: 15 * time.Second}
+    conn, err := dialer.Dial("tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    masterMu.Lock()
+    if masterStop != stop {
+        masterMu.Unlock()
+        return errors.New("replication stopped")
+    }
+    masterConn = conn
+    masterMu.Unlock()
+
+    defer func() {
+        masterMu.Lock()
+        if masterStop == stop {
+            masterConn = nil
+            masterLinkUp = false
+        }
+        masterMu.Unlock()
+    }()
+
+    resp := NewResp(conn)
+    writer := NewWriter(conn)
+
+    // request sends one handshake command and waits for its reply
+    request := func(args ...string) (Value, error) {
+        values := make([]Value, 0, len(args))
+        for _, arg := range args {
+            values = append(values, Value{typ: "bulk", bulk: arg})
+        }
+        if err := writer.Write(Value{typ: "array", array: values}); err != nil {
+            return Value{}, err
+        }
+        conn.SetReadDeadline(time.Now().Add(masterDialTimeout))
+        reply, err := resp.ReadReply()
+        if err == nil && reply.typ == "error" {
+            err = errors.New(strings.Join(args, " ") + ": " + reply.str)
+        }
+        return reply, err
+    }
+
+    if _, err := request("PING"); err != nil {
+        return err
+    }
+    if _, err := request("REPLCONF", "listening-port", strconv.Itoa(replicaListeningPort)); err != nil {
+        return err
+    }
+    if _, err := request("REPLCONF", "capa", "psync2"); err != nil {
+        return err
+    }
+
+    // We never have a stream to continue, so ask for a full resync
+    reply, err := request("PSYNC", "?", "-1")
+    if err != nil {
+        return err
+    }
+    if reply.typ != "string" || !strings.HasPrefix(reply.str, "FULLRESYNC ") {
+        return errors.New("unexpected reply to PSYNC: " + reply.str)
+    }
+
+    // The snapshot is as big as the dataset, so it gets no deadline
+    conn.SetReadDeadline(time.Time{})
+    snapshot, err := resp.Read()
+    if err != nil {
+        return err
+    }
+    if snapshot.typ != "bulk" {
+        return errors.New("expected the snapshot as a bulk string")
+    }
+    if err := loadSnapshot(snapshot.bulk, aof); err != nil {
+        return err
+    }
+
+    masterMu.Lock()
+    if masterStop != stop {
+        masterMu.Unlock()
+        return errors.New("replication stopped")
+    }
+    masterLinkUp = true
+    masterMu.Unlock()
+    fmt.Fprintln(logOutput, "Synchronized with master "+addr)
+
+    // From here on the master's writes are run like a client's, except that
+    // nobody reads the replies
+    client := NewClient(conn, aof)
+    client.authenticated = true
+    client.master = true
+    for {
+        value, err := resp.ReadCommand()
+        if err != nil {
+            return err
+        }
+        if reply := dispatch(client, value); reply.typ == "error" {
+            fmt.Fprintln(logOutput, "Error applying command from master:", reply.str)
+        }
+    }
+}
+
+// loadSnapshot replaces the dataset with the one described by snapshot, the
+// commands of a rewritten AOF, and rewrites aof to match
+// Every command is kept out meanwhile, so clients never see it half loaded
+func loadSnapshot(snapshot string, aof *Aof) error {
+    execMu.Lock()
+    defer execMu.Unlock()
+
+    emptyDataset()
+
+    // Like AOF replay, the commands run as a client with no connection and
+    // no AOF, so they aren't logged one by one
+    loader := &Client{authenticated: true, channels: map[string]bool{}, patterns: map[string]bool{}}
+    reader := NewResp(strings.NewReader(snapshot))
+    for {
+        value, err := reader.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if reply := dispatch(loader, value); reply.typ == "error" {
+            fmt.Fprintln(logOutput, "Error loading snapshot from master:", reply.str)
+        }
+    }
+
+    if aof == nil {
+        return nil
+    }
+    return aof.RewriteLocked()
+}
+
+// psync implements the Redis PSYNC command, sent by a replica to start replicating
+// We always answer with a full resync: +FULLRESYNC <replid> <offset>, then
+// the snapshot as a bulk string, then the stream of write commands. From
+// then on the connection belongs to the replication stream
+// dispatch runs it holding execMu for writing (see runsAlone), so no write
+// lands between the snapshot and the start of the stream
+// The command format is: PSYNC replicationid offset
+func psync(c *Client, args []Value) Value {
+    if c.aof == nil || c.master {
+        return Value{typ: "error", str: "ERR PSYNC is only allowed from a client connection"}
+    }
+
+    q := &pushQueue{wake: make(chan struct{}, 1)}
+    go q.run(c.writer)
+
+    snapshot := rewriteCommands()
+
+    replicasMu.Lock()
+    header := Value{typ: "string", str: "FULLRESYNC " + replID + " " + strconv.FormatInt(replOffset, 10)}
+    q.push(header, int64(len(header.str)), outputLimits{})
+    q.push(Value{typ: "bulk", bulk: string(snapshot)}, int64(len(snapshot)), outputLimits{})
+    replicas[c] = q
+    replicasMu.Unlock()
+
+    c.replica = true
+    fmt.Fprintln(logOutput, "Replica", c.conn.RemoteAddr(), "is synchronizing")
+
+    // An empty Value marshals to nothing, so no reply is written
+    return Value{}
+}
+
+// replconf implements the Redis REPLCONF command, used in the replication handshake
+// The options are accepted and, apart from listening-port, ignored
+// The command format is: REPLCONF option value [option value ...]
+func replconf(c *Client, args []Value) Value {
+    if len(args)%2 != 0 {
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+    for i := 0; i < len(args); i += 2 {
+        if strings.EqualFold(args[i].bulk, "listening-port") {
+            port, err := strconv.Atoi(args[i+1].bulk)
+            if err != nil {
+                return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+            }
+            c.replicaPort = port
+        }
+    }
+    return Value{typ: "string", str: "OK"}
+}
+
+// propagate sends records, the AOF records of a write about to run, to every replica
+// A replica that has fallen too far behind is disconnected, and syncs again
+// when it reconnects
+func propagate(records []Value) {
+    replicasMu.Lock()
+    defer replicasMu.Unlock()
+
+    if len(replicas) == 0 {
+        return
+    }
+    for _, record := range records {
+        size := int64(len(record.Marshal()))
+        replOffset += size
+        for r, q := range replicas {
+            if !q.push(record, size, replicaOutputLimits) {
+                fmt.Fprintln(logOutput, "Closing replica", r.conn.RemoteAddr(), "for going over the replica output buffer limits")
+                r.conn.Close()
+            }
+        }
+    }
+}
+
+// stopReplica forgets a disconnecting replica
+func stopReplica(c *Client) {
+    if !c.replica {
+        return
+    }
+
+    replicasMu.Lock()
+    q := replicas[c]
+    delete(replicas, c)
+    replicasMu.Unlock()
+
+    if q != nil {
+        q.close()
+    }
+    fmt.Fprintln(logOutput, "Replica", c.conn.RemoteAddr(), "disconnected")
+}
+
+// infoReplication describes the replication role, and the replicas or the master
+func infoReplication(b *strings.Builder) {
+    masterMu.Lock()
+    host, port, linkUp := masterHost, masterPort, masterLinkUp
+    masterMu.Unlock()
+
+    if host != "" {
+        b.WriteString("role:slave\r\n")
+        fmt.Fprintf(b, "master_host:%s\r\n", host)
+        fmt.Fprintf(b, "master_port:%s\r\n", port)
+        if linkUp {
+            b.WriteString("master_link_status:up\r\n")
+        } else {
+            b.WriteString("master_link_status:down\r\n")
+        }
+    } else {
+        b.WriteString("role:master\r\n")
+    }
+
+    replicasMu.RLock()
+    defer replicasMu.RUnlock()
+
+    fmt.Fprintf(b, "connected_slaves:%d\r\n", len(replicas))
+    i := 0
+    for r := range replicas {
+        ip, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+        fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=online\r\n", i, ip, r.replicaPort)
+        i++
+    }
+    fmt.Fprintf(b, "master_replid:%s\r\n", replID)
+    fmt.Fprintf(b, "master_repl_offset:%d\r\n", replOffset)
+}
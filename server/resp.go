@@ -0,0 +1,622 @@
+// Package server implements the RESP (Redis Serialization Protocol) parser and writer
+// RESP is the protocol Redis uses for client-server communication
+package server
+
+// Import necessary packages for I/O operations and data conversion
+import (
+    "bufio"     // Provides buffered I/O for efficient reading
+    "errors"    // For defining protocol error values
+    "fmt"       // For formatting and printing error messages
+    "io"        // Basic interfaces for I/O operations
+    "strconv"   // For converting between strings and numbers
+    "sync"      // For serializing concurrent writes
+)
+
+// RESP protocol type markers
+// Each RESP data type starts with a specific character that identifies its type
+const (
+    STRING  = '+'  // Simple String: "+OK\r\n"
+    ERROR   = '-'  // Error: "-Error message\r\n"
+    INTEGER = ':'  // Integer: ":1000\r\n"
+    BULK    = '$'  // Bulk String: "$5\r\nHello\r\n"
+    ARRAY   = '*'  // Array: "*2\r\n$5\r\nHello\r\n$5\r\nWorld\r\n"
+    MAP     = '%'  // RESP3 Map: "%1\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+    NULL    = '_'  // RESP3 Null: "_\r\n"
+    PUSH    = '>'  // RESP3 Push: ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n"
+)
+
+// Protocol limits
+// A client controls the lengths it announces, so without an upper bound a single
+// "$1000000000\r\n" header would make us allocate a gigabyte before reading any data.
+// These are set from command-line flags in main.
+var (
+    MaxBulkLen  = 512 * 1024 * 1024  // Largest bulk string we accept, in bytes
+    MaxArrayLen = 1024 * 1024        // Largest number of elements we accept in an array
+    MaxQueryLen = 1024 * 1024 * 1024 // Largest command we accept, in bytes, all arguments together
+)
+
+// maxLineLen bounds a header line such as "*3" or "$5", like Redis's inline limit
+// Without it a client could send a line that never ends and have us buffer it all
+const maxLineLen = 64 * 1024
+
+// ProtocolError reports input that isn't valid RESP
+// After one of these the stream can't be trusted to be in sync any more,
+// so the connection handler replies with the error and hangs up
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+    return "Protocol error: " + string(e)
+}
+
+// Protocol errors returned by the parser when a header or line ending is invalid
+var (
+    ErrInvalidBulkLength      = ProtocolError("invalid bulk length")
+    ErrInvalidMultibulkLength = ProtocolError("invalid multibulk length")
+    ErrInvalidLine            = ProtocolError("expected '\\r\\n'")
+    ErrLineTooLong            = ProtocolError("too big line")
+    ErrQueryTooLong           = ProtocolError("too big request")
+)
+
+// Value represents a RESP data type and its contents
+// This is our internal representation of RESP data
+type Value struct {
+    typ   string    // Type of value ("string", "error", "integer", "bulk", "array", "map", "push", "null", "null_array")
+    str   string    // Holds simple strings and error messages
+    num   int       // Holds integer values
+    bulk  string    // Holds bulk strings, which may contain any bytes (Go strings aren't required to be UTF-8)
+    array []Value   // Holds arrays and pushes (can contain any other RESP values), and maps as alternating keys and values
+}
+
+// Constructors for the common reply types
+// They are shorthand for the matching Value literals, which keep working too
+
+// NewString returns a simple string reply, such as OK
+func NewString(s string) Value {
+    return Value{typ: "string", str: s}
+}
+
+// NewError returns an error reply; msg should start with an error code such as ERR
+func NewError(msg string) Value {
+    return Value{typ: "error", str: msg}
+}
+
+// NewInteger returns an integer reply
+func NewInteger(n int) Value {
+    return Value{typ: "integer", num: n}
+}
+
+// NewBulk returns a bulk string reply
+func NewBulk(s string) Value {
+    return Value{typ: "bulk", bulk: s}
+}
+
+// NewArray returns an array reply holding vals
+func NewArray(vals []Value) Value {
+    return Value{typ: "array", array: vals}
+}
+
+// NewNull returns a null reply, the reply for a missing key
+func NewNull() Value {
+    return Value{typ: "null"}
+}
+
+// Resp represents a RESP protocol parser
+// It wraps a buffered reader for efficient reading of RESP data
+type Resp struct {
+    reader *bufio.Reader
+    offset int64  // Total number of bytes consumed so far
+    limit  int64  // Offset the command being read must end by, 0 for no limit
+}
+
+// NewResp creates a new RESP parser from any io.Reader
+// This could be a network connection, file, or any other input stream
+func NewResp(rd io.Reader) *Resp {
+    return &Resp{reader: bufio.NewReader(rd)}
+}
+
+// Offset returns the number of bytes consumed from the underlying reader
+// Because reads are buffered, this can be less than what was actually read
+// from it; it's the position just past the last value returned by Read
+func (r *Resp) Offset() int64 {
+    return r.offset
+}
+
+// readLine reads a RESP line ending with \r\n
+// Returns the line without \r\n, the number of bytes read, and any error
+// The returned slice may point into the reader's buffer, so it is only valid
+// until the next read
+func (r *Resp) readLine() (line []byte, n int, err error) {
+    // ReadSlice finds the \n inside the buffered data without copying it
+    // A line longer than the buffer comes back in pieces, which we join up
+    line, err = r.reader.ReadSlice('\n')
+    if err == bufio.ErrBufferFull {
+        // The next ReadSlice overwrites the buffer, so copy what we have first
+        line = append([]byte(nil), line...)
+        for err == bufio.ErrBufferFull {
+            if len(line) > maxLineLen {
+                return nil, 0, ErrLineTooLong
+            }
+            var more []byte
+            more, err = r.reader.ReadSlice('\n')
+            line = append(line, more...)
+        }
+    }
+    n = len(line)
+    r.offset += int64(n)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // Only a \r directly before the \n ends the line; a bare \r elsewhere is
+    // part of the data, and a \n without its \r means the stream is malformed
+    if n < 2 || line[n-2] != '\r' {
+        return nil, 0, ErrInvalidLine
+    }
+
+    // Return line without the trailing \r\n
+    return line[:n-2], n, nil
+}
+
+// readInteger reads a RESP integer
+// Used for array lengths and bulk string lengths
+func (r *Resp) readInteger() (x int, n int, err error) {
+    // Read the line containing the number
+    line, n, err := r.readLine()
+    if err != nil {
+        return 0, 0, err
+    }
+    
+    // Convert string to integer
+    i64, err := strconv.ParseInt(string(line), 10, 64)
+    if err != nil {
+        return 0, n, err
+    }
+    
+    return int(i64), n, nil
+}
+
+// Read reads a complete RESP value
+// This is the main entry point for parsing RESP data
+func (r *Resp) Read() (Value, error) {
+    // Read the type marker byte
+    _type, err := r.reader.ReadByte()
+    if err != nil {
+        return Value{}, err
+    }
+    r.offset++
+
+    // Parse different RESP types based on the marker
+    var v Value
+    switch _type {
+    case ARRAY:
+        v, err = r.readArray()
+    case BULK:
+        v, err = r.readBulk()
+    default:
+        return Value{}, ProtocolError(fmt.Sprintf("expected '$', got '%c'", _type))
+    }
+
+    // Once the marker is read we're inside a value, so running out of input
+    // isn't a clean end of stream; a partly read value is never returned
+    if err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return Value{}, err
+    }
+    return v, nil
+}
+
+// ReadReply reads a reply sent by another server, such as a master answering
+// the replication handshake
+// Replies may also be simple strings, errors and integers, which a command
+// never contains
+func (r *Resp) ReadReply() (Value, error) {
+    // Peek so a bulk or array marker is left for Read to consume
+    marker, err := r.reader.Peek(1)
+    if err != nil {
+        return Value{}, err
+    }
+    kind := marker[0]
+    if kind != STRING && kind != ERROR && kind != INTEGER {
+        return r.Read()
+    }
+    r.reader.ReadByte()
+    r.offset++
+
+    line, _, err := r.readLine()
+    if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+    }
+    if err != nil {
+        return Value{}, err
+    }
+
+    switch kind {
+    case STRING:
+        return Value{typ: "string", str: string(line)}, nil
+    case ERROR:
+        return Value{typ: "error", str: string(line)}, nil
+    }
+    n, err := strconv.Atoi(string(line))
+    if err != nil {
+        return Value{}, ProtocolError("invalid integer")
+    }
+    return Value{typ: "integer", num: n}, nil
+}
+
+// ReadCommand reads a client command, which must be a RESP array
+// Anything else at the top level is a protocol error
+func (r *Resp) ReadCommand() (Value, error) {
+    // Peek so the marker is left for Read to consume
+    marker, err := r.reader.Peek(1)
+    if err != nil {
+        return Value{}, err
+    }
+    if marker[0] != ARRAY {
+        return Value{}, ProtocolError(fmt.Sprintf("expected '*', got '%c'", marker[0]))
+    }
+
+    // The lengths a command announces must fit in MaxQueryLen all together,
+    // so no combination of them can make us buffer more than that
+    r.limit = r.offset + int64(MaxQueryLen)
+    defer func() { r.limit = 0 }()
+    return r.Read()
+}
+
+// readArray reads a RESP array
+// Format: *<length>\r\n<element-1>...<element-n>
+func (r *Resp) readArray() (Value, error) {
+    v := Value{}
+    v.typ = "array"
+
+    // Read array length
+    len, _, err := r.readInteger()
+    var numErr *strconv.NumError
+    if errors.As(err, &numErr) {
+        return v, ErrInvalidMultibulkLength
+    }
+    if err != nil {
+        return v, err
+    }
+
+    // A length of -1 is a null array (*-1\r\n), which has no elements to read
+    if len == -1 {
+        return Value{typ: "null_array"}, nil
+    }
+
+    // Reject other negative or oversized lengths before allocating anything
+    if len < 0 || len > MaxArrayLen {
+        return v, ErrInvalidMultibulkLength
+    }
+
+    // Every element takes at least 4 bytes ("$0\r\n" and its "\r\n", or "*0\r\n"),
+    // so an array that can't fit in what's left of the request is refused now
+    if r.limit > 0 && r.offset+4*int64(len) > r.limit {
+        return v, ErrQueryTooLong
+    }
+
+    // Initialize array to store elements
+    // A header is cheap to send, so it only sizes the array up to a point;
+    // beyond that it grows as elements actually arrive
+    v.array = make([]Value, 0, min(len, 1024))
+    
+    // Read each array element
+    for i := 0; i < len; i++ {
+        // Recursively read each value
+        // An element that fails fails the whole array, not just its own slot
+        val, err := r.Read()
+        if err != nil {
+            return Value{}, err
+        }
+        // Add value to array
+        v.array = append(v.array, val)
+    }
+
+    return v, nil
+}
+
+// readBulk reads a RESP bulk string
+// Format: $<length>\r\n<data>\r\n
+// The data is read by length, never scanned for line endings, so it may
+// contain \r, \n, NUL or invalid UTF-8 and still comes back byte for byte
+func (r *Resp) readBulk() (Value, error) {
+    v := Value{}
+    v.typ = "bulk"
+
+    // Read string length
+    len, _, err := r.readInteger()
+    var numErr *strconv.NumError
+    if errors.As(err, &numErr) {
+        return v, ErrInvalidBulkLength
+    }
+    if err != nil {
+        return v, err
+    }
+
+    // A length of -1 is a null bulk string ($-1\r\n)
+    // It carries no data and no trailing CRLF, so we're done
+    if len == -1 {
+        return Value{typ: "null"}, nil
+    }
+
+    // Reject other negative or oversized lengths before allocating the buffer
+    if len < 0 || len > MaxBulkLen {
+        return v, ErrInvalidBulkLength
+    }
+    if r.limit > 0 && r.offset+int64(len)+2 > r.limit {
+        return v, ErrQueryTooLong
+    }
+
+    // Allocate buffer for string data
+    bulk := make([]byte, len)
+    
+    // Read the string data
+    // io.ReadFull keeps reading until the whole bulk has arrived, and reports
+    // io.ErrUnexpectedEOF if the stream ends part way through it
+    n, err := io.ReadFull(r.reader, bulk)
+    r.offset += int64(n)
+    if err != nil {
+        return v, err
+    }
+    v.bulk = string(bulk)
+
+    // Read the trailing \r\n, which must follow the data immediately
+    // Anything in between means the announced length was wrong
+    _, n, err = r.readLine()
+    if err != nil {
+        return v, err
+    }
+    if n != 2 {
+        return v, ErrInvalidLine
+    }
+
+    return v, nil
+}
+
+// Marshal converts a Value into RESP2 wire format
+// Used for the AOF file, which is always written in RESP2
+func (v Value) Marshal() []byte {
+    return v.appendMarshal(make([]byte, 0, v.marshalSize()), 2)
+}
+
+// marshalSize estimates how many bytes v takes on the wire
+// Marshal uses it to allocate the output buffer once instead of growing it
+func (v Value) marshalSize() int {
+    // Type marker, a length or number of up to 20 digits, and CRLF
+    size := 1 + 20 + 2
+    size += len(v.str) + len(v.bulk) + 2
+    for _, elem := range v.array {
+        size += elem.marshalSize()
+    }
+    return size
+}
+
+// appendMarshal appends v to b in the wire format of protocol version proto (2 or 3)
+// Used when sending responses back to clients, which pick a version with HELLO
+// Appending to a caller-owned buffer lets the writer reuse one buffer per
+// connection, so most replies are encoded without allocating
+func (v Value) appendMarshal(b []byte, proto int) []byte {
+    // Choose appropriate marshaling method based on value type
+    switch v.typ {
+    case "array":
+        return v.marshalArray(b, proto)
+    case "map":
+        // RESP2 has no map type, so maps are sent as a flat key/value array
+        if proto < 3 {
+            return v.marshalArray(b, proto)
+        }
+        return v.marshalMap(b)
+    case "push":
+        // RESP2 has no push type either; those clients get a plain array
+        if proto < 3 {
+            return v.marshalArray(b, proto)
+        }
+        return v.marshalPush(b)
+    case "bulk":
+        return v.marshalBulk(b)
+    case "string":
+        return v.marshalString(b)
+    case "integer":
+        return v.marshalInteger(b)
+    case "null", "null_array":
+        // RESP3 has a single null type for both
+        if proto >= 3 {
+            return v.marshallNull3(b)
+        }
+        if v.typ == "null_array" {
+            return v.marshallNullArray(b)
+        }
+        return v.marshallNull(b)
+    case "error":
+        return v.marshallError(b)
+    default:
+        return b
+    }
+}
+
+// marshalString formats a RESP simple string
+// Format: +<string>\r\n
+func (v Value) marshalString(bytes []byte) []byte {
+    bytes = append(bytes, STRING)            // Add type marker
+    bytes = append(bytes, v.str...)          // Add string content
+    bytes = append(bytes, '\r', '\n')        // Add CRLF
+    return bytes
+}
+
+// marshalInteger formats a RESP integer
+// Format: :<number>\r\n
+func (v Value) marshalInteger(bytes []byte) []byte {
+    bytes = append(bytes, INTEGER)                          // Add type marker
+    bytes = strconv.AppendInt(bytes, int64(v.num), 10)      // Add number
+    bytes = append(bytes, '\r', '\n')                       // Add CRLF
+    return bytes
+}
+
+// marshalBulk formats a RESP bulk string
+// Format: $<length>\r\n<string>\r\n
+func (v Value) marshalBulk(bytes []byte) []byte {
+    bytes = append(bytes, BULK)                                  // Add type marker
+    bytes = strconv.AppendInt(bytes, int64(len(v.bulk)), 10)     // Add length
+    bytes = append(bytes, '\r', '\n')                            // Add CRLF
+    bytes = append(bytes, v.bulk...)                             // Add string content
+    bytes = append(bytes, '\r', '\n')                            // Add CRLF
+    return bytes
+}
+
+// marshalArray formats a RESP array
+// Format: *<length>\r\n<element-1>...<element-n>
+func (v Value) marshalArray(bytes []byte, proto int) []byte {
+    bytes = append(bytes, ARRAY)                                 // Add type marker
+    bytes = strconv.AppendInt(bytes, int64(len(v.array)), 10)    // Add array length
+    bytes = append(bytes, '\r', '\n')                            // Add CRLF
+
+    // Marshal each array element
+    for _, elem := range v.array {
+        bytes = elem.appendMarshal(bytes, proto)
+    }
+
+    return bytes
+}
+
+// marshalMap formats a RESP3 map
+// Format: %<number of pairs>\r\n<key-1><value-1>...<key-n><value-n>
+func (v Value) marshalMap(bytes []byte) []byte {
+    bytes = append(bytes, MAP)                                     // Add type marker
+    bytes = strconv.AppendInt(bytes, int64(len(v.array)/2), 10)    // Add number of pairs
+    bytes = append(bytes, '\r', '\n')                              // Add CRLF
+
+    // Keys and values are stored alternately, so each element is marshaled in turn
+    for _, elem := range v.array {
+        bytes = elem.appendMarshal(bytes, 3)
+    }
+
+    return bytes
+}
+
+// marshalPush formats a RESP3 push, data the server sends without being asked
+// Format: ><length>\r\n<element-1>...<element-n>
+func (v Value) marshalPush(bytes []byte) []byte {
+    bytes = append(bytes, PUSH)                                  // Add type marker
+    bytes = strconv.AppendInt(bytes, int64(len(v.array)), 10)    // Add number of elements
+    bytes = append(bytes, '\r', '\n')                            // Add CRLF
+
+    for _, elem := range v.array {
+        bytes = elem.appendMarshal(bytes, 3)
+    }
+
+    return bytes
+}
+
+// marshallError formats a RESP error
+// Format: -<error>\r\n
+func (v Value) marshallError(bytes []byte) []byte {
+    bytes = append(bytes, ERROR)             // Add type marker
+    bytes = append(bytes, v.str...)          // Add error message
+    bytes = append(bytes, '\r', '\n')        // Add CRLF
+    return bytes
+}
+
+// marshallNull formats a RESP null value
+// Format: $-1\r\n
+func (v Value) marshallNull(bytes []byte) []byte {
+    return append(bytes, "$-1\r\n"...)
+}
+
+// marshallNullArray formats a RESP null array
+// Format: *-1\r\n
+// Some commands (e.g. an aborted EXEC) must reply with a null array rather
+// than a null bulk string, and clients parse the two differently
+func (v Value) marshallNullArray(bytes []byte) []byte {
+    return append(bytes, "*-1\r\n"...)
+}
+
+// marshallNull3 formats a RESP3 null value
+// Format: _\r\n
+func (v Value) marshallNull3(bytes []byte) []byte {
+    return append(bytes, NULL, '\r', '\n')
+}
+
+// Writer wraps an io.Writer for writing RESP values
+// Used to send responses back to Redis clients
+// It is safe for concurrent use: a subscriber's connection may receive
+// published messages from other goroutines while replying to its own commands
+type Writer struct {
+    writer io.Writer
+    mu     sync.Mutex  // Keeps each value's bytes together on the wire
+    proto  int         // Protocol version replies are written in, protected by mu
+    buf    []byte      // Reused between writes to encode replies, protected by mu
+    err    error       // First write error; once set the stream is broken, protected by mu
+}
+
+// NewWriter creates a new RESP writer
+// It starts out writing RESP2, like a freshly connected Redis client
+func NewWriter(w io.Writer) *Writer {
+    return &Writer{writer: w, proto: 2}
+}
+
+// SetProtocol switches the writer to protocol version proto (2 or 3)
+func (w *Writer) SetProtocol(proto int) {
+    w.mu.Lock()
+    w.proto = proto
+    w.mu.Unlock()
+}
+
+// Protocol returns the protocol version the writer is using
+func (w *Writer) Protocol() int {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.proto
+}
+
+// Write writes a Value in RESP format to the underlying writer
+func (w *Writer) Write(v Value) error {
+    // Count error replies here, since they come from many places
+    if v.typ == "error" {
+        errorReplies.Add(1)
+    }
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    // Marshal the value in the protocol version this connection negotiated,
+    // reusing the buffer from the previous reply
+    w.buf = v.appendMarshal(w.buf[:0], w.proto)
+
+    // A reply that was only partly sent has left the stream out of sync,
+    // so nothing more may be written after a failure
+    if w.err != nil {
+        return w.err
+    }
+
+    // Write to the underlying writer
+    if err := writeFull(w.writer, w.buf); err != nil {
+        w.err = err
+
+        // Hang up, so the client sees a closed connection rather than
+        // a half-written reply followed by whatever comes next
+        if c, ok := w.writer.(io.Closer); ok {
+            c.Close()
+        }
+        return err
+    }
+    
+    return nil
+}
+
+// writeFull writes all of p to w
+// A well-behaved io.Writer already reports an error for a short write, but
+// we keep going on whatever is left rather than rely on it
+func writeFull(w io.Writer, p []byte) error {
+    for len(p) > 0 {
+        n, err := w.Write(p)
+        if err != nil {
+            return err
+        }
+        if n == 0 {
+            return io.ErrShortWrite
+        }
+        p = p[n:]
+    }
+    return nil
+}
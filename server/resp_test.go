@@ -0,0 +1,269 @@
+// Package server tests the RESP parser
+package server
+
+import (
+    "errors"
+    "io"
+    "strings"
+    "testing"
+)
+
+// getCommand is a complete command, as a client would send it
+const getCommand = "*2\r\n$3\r\nGET\r\n$5\r\nhello\r\n"
+
+// TestReadTruncated checks that a stream cut off inside a value is reported
+// as io.ErrUnexpectedEOF and never comes back as a partly read value
+func TestReadTruncated(t *testing.T) {
+    tests := []struct {
+        name  string
+        input string
+    }{
+        {"array header", "*"},
+        {"array length", "*2"},
+        {"array length without \\n", "*2\r"},
+        {"bulk header", "*2\r\n$"},
+        {"bulk length", "*2\r\n$3"},
+        {"mid-bulk", "*2\r\n$3\r\nGE"},
+        {"bulk without CRLF", "*2\r\n$3\r\nGET"},
+        {"bulk without \\n", "*2\r\n$3\r\nGET\r"},
+        {"mid-array", "*2\r\n$3\r\nGET\r\n"},
+        {"mid-array, second bulk", "*2\r\n$3\r\nGET\r\n$5\r\nhel"},
+        {"lone bulk", "$5\r\nhel"},
+        {"nested array", "*1\r\n*2\r\n$1\r\na\r\n"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            value, err := NewResp(strings.NewReader(tt.input)).Read()
+            if !errors.Is(err, io.ErrUnexpectedEOF) {
+                t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+            }
+            if value.typ != "" || value.array != nil {
+                t.Fatalf("got partial value %+v", value)
+            }
+        })
+    }
+}
+
+// TestReadEveryTruncation cuts a command off after every byte and checks that
+// each prefix fails, and that only the empty one is a clean end of stream
+func TestReadEveryTruncation(t *testing.T) {
+    for i := 0; i < len(getCommand); i++ {
+        value, err := NewResp(strings.NewReader(getCommand[:i])).Read()
+
+        want := io.ErrUnexpectedEOF
+        if i == 0 {
+            want = io.EOF
+        }
+        if err != want {
+            t.Fatalf("%q: got error %v, want %v", getCommand[:i], err, want)
+        }
+        if value.typ != "" {
+            t.Fatalf("%q: got partial value %+v", getCommand[:i], value)
+        }
+    }
+}
+
+// TestReadComplete checks that a whole command is read, and that reading
+// on at the end of the stream is a clean io.EOF
+func TestReadComplete(t *testing.T) {
+    resp := NewResp(strings.NewReader(getCommand))
+
+    value, err := resp.Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if value.typ != "array" || len(value.array) != 2 || value.array[0].bulk != "GET" || value.array[1].bulk != "hello" {
+        t.Fatalf("got %+v", value)
+    }
+    if resp.Offset() != int64(len(getCommand)) {
+        t.Fatalf("offset %d, want %d", resp.Offset(), len(getCommand))
+    }
+
+    if _, err := resp.Read(); err != io.EOF {
+        t.Fatalf("got error %v at the end of the stream, want io.EOF", err)
+    }
+}
+
+// TestReadLineBareCR checks that only \r\n ends a line: a \r elsewhere is
+// part of the line, and a \n without its \r is an error
+func TestReadLineBareCR(t *testing.T) {
+    tests := []struct {
+        name  string
+        input string
+        line  string
+        err   error
+    }{
+        {"embedded \\r", "ab\rcd\r\n", "ab\rcd", nil},
+        {"\\r before \\r\\n", "ab\r\r\n", "ab\r", nil},
+        {"bare \\n", "ab\n", "", ErrInvalidLine},
+        {"\\n after embedded \\r", "a\rb\n", "", ErrInvalidLine},
+        {"lone \\n", "\n", "", ErrInvalidLine},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            line, _, err := NewResp(strings.NewReader(tt.input)).readLine()
+            if err != tt.err || string(line) != tt.line {
+                t.Fatalf("got %q, %v; want %q, %v", line, err, tt.line, tt.err)
+            }
+        })
+    }
+}
+
+// TestReadBulkWithCR checks that a \r inside a bulk string, or in a length
+// line, doesn't end the line early and desync the values after it
+func TestReadBulkWithCR(t *testing.T) {
+    resp := NewResp(strings.NewReader("*2\r\n$4\r\na\rbc\r\n$1\r\nd\r\n$3\rx\r\n"))
+
+    value, err := resp.Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(value.array) != 2 || value.array[0].bulk != "a\rbc" || value.array[1].bulk != "d" {
+        t.Fatalf("got %+v", value)
+    }
+
+    // The \r inside "3\rx" is part of the length, which makes it invalid
+    if _, err := resp.Read(); err != ErrInvalidBulkLength {
+        t.Fatalf("got error %v, want %v", err, ErrInvalidBulkLength)
+    }
+}
+
+// shortWriter accepts at most max bytes per Write, like a slow TCP connection,
+// and fails once it has taken failAfter bytes if failAfter is set
+type shortWriter struct {
+    max       int
+    failAfter int
+    written   []byte
+    closed    bool
+}
+
+// Write takes up to max bytes of p
+func (w *shortWriter) Write(p []byte) (int, error) {
+    if w.failAfter > 0 && len(w.written) >= w.failAfter {
+        return 0, errors.New("connection reset")
+    }
+    n := min(len(p), w.max)
+    w.written = append(w.written, p[:n]...)
+    return n, nil
+}
+
+// Close records that the writer was closed
+func (w *shortWriter) Close() error {
+    w.closed = true
+    return nil
+}
+
+// TestWriterShortWrites checks that a reply is written in full even when the
+// underlying writer takes it a few bytes at a time
+func TestWriterShortWrites(t *testing.T) {
+    w := &shortWriter{max: 3}
+    writer := NewWriter(w)
+    reply := Value{typ: "array", array: []Value{
+        {typ: "bulk", bulk: strings.Repeat("x", 100)},
+        {typ: "integer", num: 42},
+    }}
+
+    for i := 0; i < 2; i++ {
+        if err := writer.Write(reply); err != nil {
+            t.Fatal(err)
+        }
+    }
+    want := strings.Repeat(string(reply.Marshal()), 2)
+    if string(w.written) != want {
+        t.Fatalf("got %q, want %q", w.written, want)
+    }
+}
+
+// TestWriterFailedWrite checks that a reply that fails part way closes the
+// connection and that nothing more is written after it
+func TestWriterFailedWrite(t *testing.T) {
+    w := &shortWriter{max: 3, failAfter: 6}
+    writer := NewWriter(w)
+
+    if err := writer.Write(Value{typ: "bulk", bulk: "hello world"}); err == nil {
+        t.Fatal("a failed write was reported as written")
+    }
+    if !w.closed {
+        t.Fatal("the connection was left open after a partial reply")
+    }
+
+    w.failAfter = 0
+    if err := writer.Write(Value{typ: "string", str: "OK"}); err == nil {
+        t.Fatal("a write after a failure was accepted")
+    }
+    if len(w.written) != 6 {
+        t.Fatalf("%d bytes written, want the 6 before the failure", len(w.written))
+    }
+}
+
+// TestReadMalformedLength checks that a length that isn't a valid number
+// fails with the protocol error Redis replies with
+func TestReadMalformedLength(t *testing.T) {
+    tests := []struct {
+        input string
+        err   error
+    }{
+        {"*abc\r\n", ErrInvalidMultibulkLength},
+        {"*\r\n", ErrInvalidMultibulkLength},
+        {"*-5\r\n", ErrInvalidMultibulkLength},
+        {"*99999999999999999999\r\n", ErrInvalidMultibulkLength},
+        {"*1\r\n$x1\r\n", ErrInvalidBulkLength},
+        {"*1\r\n$\r\n", ErrInvalidBulkLength},
+        {"*1\r\n$-2\r\n", ErrInvalidBulkLength},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.input, func(t *testing.T) {
+            if _, err := NewResp(strings.NewReader(tt.input)).Read(); err != tt.err {
+                t.Fatalf("got error %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
+
+// TestReadEmptyBulk checks that $0 is an empty string, distinct from the
+// null bulk $-1, alone and inside an array, and that both round-trip
+func TestReadEmptyBulk(t *testing.T) {
+    tests := []struct {
+        input string
+        want  Value
+    }{
+        {"$0\r\n\r\n", Value{typ: "bulk", bulk: ""}},
+        {"$-1\r\n", Value{typ: "null"}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.input, func(t *testing.T) {
+            value, err := NewResp(strings.NewReader(tt.input)).Read()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if value.typ != tt.want.typ || value.bulk != tt.want.bulk {
+                t.Fatalf("got %+v, want %+v", value, tt.want)
+            }
+            if got := string(value.Marshal()); got != tt.input {
+                t.Fatalf("marshaled as %q, want %q", got, tt.input)
+            }
+        })
+    }
+
+    value, err := NewResp(strings.NewReader("*2\r\n$0\r\n\r\n$-1\r\n")).Read()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(value.array) != 2 || value.array[0].typ != "bulk" || value.array[0].bulk != "" || value.array[1].typ != "null" {
+        t.Fatalf("got %+v", value)
+    }
+}
+
+// TestReadShortLine checks that a line cut off before its CRLF is an error,
+// never a line with bytes sliced off its end
+func TestReadShortLine(t *testing.T) {
+    for _, input := range []string{"$", "$0", "$0\r", "$0\r\n", "$0\r\n\r", "$1\r\nx\n"} {
+        if value, err := NewResp(strings.NewReader(input)).Read(); err == nil {
+            t.Errorf("%q: got %+v, want an error", input, value)
+        }
+    }
+}
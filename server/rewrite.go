@@ -0,0 +1,113 @@
+// Package server implements AOF rewriting (BGREWRITEAOF)
+// The AOF only ever grows, even when the same key is overwritten again and
+// again. A rewrite replaces it with one set of commands per live key
+package server
+
+import "strconv"
+
+// rewriteItemsPerCommand caps how many elements go into one command of the
+// rewritten AOF, so a huge collection doesn't become one huge command
+const rewriteItemsPerCommand = 64
+
+// rewriteCommands returns the AOF records that rebuild the whole dataset
+// The caller must hold execMu for writing, so nothing changes while we read
+func rewriteCommands() []byte {
+    rLockAllStores()
+    defer rUnlockAllStores()
+
+    b := []byte{}
+
+    // emit appends one command to the output
+    emit := func(args ...string) {
+        values := make([]Value, 0, len(args))
+        for _, arg := range args {
+            values = append(values, Value{typ: "bulk", bulk: arg})
+        }
+        b = append(b, Value{typ: "array", array: values}.Marshal()...)
+    }
+
+    // emitBatched appends cmd key followed by items, split into several
+    // commands of at most rewriteItemsPerCommand items each
+    // Each item is one or more arguments (a member, or a field and its value)
+    emitBatched := func(cmd, key string, items [][]string) {
+        for start := 0; start < len(items); start += rewriteItemsPerCommand {
+            end := start + rewriteItemsPerCommand
+            if end > len(items) {
+                end = len(items)
+            }
+            args := []string{cmd, key}
+            for _, item := range items[start:end] {
+                args = append(args, item...)
+            }
+            emit(args...)
+        }
+    }
+
+    for key, value := range SETs {
+        emit("SET", key, value)
+    }
+    for key, fields := range HSETs {
+        // In field order, so a replayed hash-preserve-order hash keeps its order
+        items := make([][]string, 0, len(fields))
+        for _, field := range hashFieldsLocked(key) {
+            items = append(items, []string{field, fields[field]})
+        }
+        emitBatched("HMSET", key, items)
+    }
+    for key, zset := range ZSETs {
+        items := make([][]string, 0, zset.Len())
+        for _, entry := range zset.entries {
+            items = append(items, []string{formatScore(entry.score), entry.member})
+        }
+        emitBatched("ZADD", key, items)
+    }
+    for key, list := range LISTs {
+        items := make([][]string, 0, len(list))
+        for _, element := range list {
+            items = append(items, []string{element})
+        }
+        emitBatched("RPUSH", key, items)
+    }
+    for key, members := range SSETs {
+        items := make([][]string, 0, len(members))
+        for member := range members {
+            items = append(items, []string{member})
+        }
+        emitBatched("SADD", key, items)
+    }
+
+    // Expiries are written as absolute times, so they don't move on replay
+    expirationsMu.Lock()
+    for key, when := range expirations {
+        if !keyExistsLocked(key) {
+            continue
+        }
+        emit("PEXPIREAT", key, strconv.FormatInt(when.UnixMilli(), 10))
+    }
+    expirationsMu.Unlock()
+
+    return b
+}
+
+// bgrewriteaof implements the Redis BGREWRITEAOF command
+// It starts an AOF rewrite in the background and replies straight away
+// The command format is: BGREWRITEAOF
+func bgrewriteaof(c *Client, args []Value) Value {
+    // Replaying the AOF has nothing to rewrite yet
+    if c.aof == nil {
+        return Value{typ: "error", str: "ERR Append only file is not available"}
+    }
+
+    c.aof.mu.Lock()
+    rewriting := c.aof.rewriting
+    c.aof.mu.Unlock()
+    if rewriting {
+        return Value{typ: "error", str: "ERR " + errRewriteInProgress.Error()}
+    }
+
+    // The rewrite has to wait for this command to finish before it can
+    // snapshot the dataset, so it must run on its own goroutine
+    go c.aof.rewriteInBackground()
+
+    return Value{typ: "string", str: "Background append only file rewriting started"}
+}
@@ -0,0 +1,199 @@
+// Package server implements the SCAN command
+// SCAN walks the keyspace a batch at a time, so a client can list every key
+// without one huge reply and without blocking the server for long
+//
+// The cursor works like Redis's. Keys are spread over a power-of-two number of
+// buckets by the low bits of their hash, with about as many buckets as keys,
+// and the cursor is the next bucket to visit. Buckets are visited in
+// reverse-binary order: the cursor is incremented from its highest bit down.
+// When the keyspace grows or shrinks between calls the bucket count changes,
+// but every bucket of the new size still descends from, or folds into, the
+// buckets visited so far, so a key that exists for the whole scan is returned
+// at least once. Keys may be returned more than once, as in Redis
+//
+// The buckets are kept in scanIndex as keys come and go, so a call only looks
+// at the buckets it returns and takes none of the store locks
+package server
+
+import (
+    "hash/fnv"
+    "math/bits"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// scanIndex holds every stored key in its SCAN bucket, counting the stores
+// that hold it, which is normally one. It starts with a single bucket, doubles
+// once there are more keys than buckets and halves once they drop under a
+// quarter, so a key added and removed at a boundary doesn't rebuild it each time
+// Every store adds and removes its keys through indexKey and unindexKey
+var scanIndex = []map[string]int{{}}
+
+// scanIndexKeys is the number of distinct keys in scanIndex
+var scanIndexKeys = 0
+
+// scanIndexMu protects scanIndex and scanIndexKeys
+// It is taken while holding store locks, and only keyVersionsMu is taken
+// while holding it
+var scanIndexMu = sync.Mutex{}
+
+// indexKey records that a store now holds key
+// The caller must hold the write lock of that store
+func indexKey(key string) {
+    scanIndexMu.Lock()
+    defer scanIndexMu.Unlock()
+
+    bucket := scanIndex[scanHash(key)&uint64(len(scanIndex)-1)]
+    bucket[key]++
+    if bucket[key] > 1 {
+        return
+    }
+    scanIndexKeys++
+    if scanIndexKeys > len(scanIndex) {
+        resizeScanIndexLocked(len(scanIndex) * 2)
+    }
+}
+
+// unindexKey records that a store no longer holds key
+// The caller must hold the write lock of that store
+func unindexKey(key string) {
+    scanIndexMu.Lock()
+    defer scanIndexMu.Unlock()
+
+    bucket := scanIndex[scanHash(key)&uint64(len(scanIndex)-1)]
+    switch bucket[key] {
+    case 0:
+        return
+    case 1:
+        delete(bucket, key)
+        forgetKeyVersionLocked(key)
+    default:
+        bucket[key]--
+        return
+    }
+    scanIndexKeys--
+    if len(scanIndex) > 1 && scanIndexKeys < len(scanIndex)/4 {
+        resizeScanIndexLocked(len(scanIndex) / 2)
+    }
+}
+
+// indexedLocked reports whether any store holds key
+// The caller must hold scanIndexMu
+func indexedLocked(key string) bool {
+    return scanIndex[scanHash(key)&uint64(len(scanIndex)-1)][key] > 0
+}
+
+// resizeScanIndexLocked spreads the indexed keys over size buckets
+// size must be a power of two. The caller must hold scanIndexMu
+func resizeScanIndexLocked(size int) {
+    buckets := make([]map[string]int, size)
+    for i := range buckets {
+        buckets[i] = map[string]int{}
+    }
+    for _, bucket := range scanIndex {
+        for key, stores := range bucket {
+            buckets[scanHash(key)&uint64(size-1)][key] = stores
+        }
+    }
+    scanIndex = buckets
+}
+
+// scanBucketKeys returns the keys in bucket cursor&mask of scanIndex, along
+// with mask, which is the number of buckets minus one
+func scanBucketKeys(cursor uint64) ([]string, uint64) {
+    scanIndexMu.Lock()
+    defer scanIndexMu.Unlock()
+
+    mask := uint64(len(scanIndex) - 1)
+    bucket := scanIndex[cursor&mask]
+    keys := make([]string, 0, len(bucket))
+    for key := range bucket {
+        keys = append(keys, key)
+    }
+    return keys, mask
+}
+
+// scanHash returns the hash that places key in a SCAN bucket
+func scanHash(key string) uint64 {
+    h := fnv.New64a()
+    h.Write([]byte(key))
+    return h.Sum64()
+}
+
+// nextScanCursor returns the bucket to visit after cursor, with mask being
+// the number of buckets minus one. Only the bits under mask are incremented,
+// starting from the highest, so the walk is the same whatever the table size;
+// it wraps to 0 once every bucket has been visited
+func nextScanCursor(cursor, mask uint64) uint64 {
+    cursor |= ^mask
+    cursor = bits.Reverse64(cursor)
+    cursor++
+    return bits.Reverse64(cursor)
+}
+
+// scan implements the Redis SCAN command
+// It returns the next cursor and a batch of keys; a cursor of 0 starts a new
+// scan and a returned cursor of 0 means the scan is complete
+// The command format is: SCAN cursor [MATCH pattern] [COUNT count]
+func scan(c *Client, args []Value) Value {
+    // The cursor must be a non-negative integer; "0" starts a new iteration
+    cursor, err := strconv.ParseUint(args[0].bulk, 10, 64)
+    if err != nil {
+        return Value{typ: "error", str: "ERR invalid cursor"}
+    }
+
+    // Parse the optional MATCH and COUNT arguments
+    pattern := ""
+    count := 10
+    for i := 1; i < len(args); i++ {
+        option := strings.ToUpper(args[i].bulk)
+        switch {
+        case option == "MATCH" && i+1 < len(args):
+            pattern = args[i+1].bulk
+            i++
+        case option == "COUNT" && i+1 < len(args):
+            count, err = strconv.Atoi(args[i+1].bulk)
+            if err != nil {
+                return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+            }
+            if count < 1 {
+                return Value{typ: "error", str: "ERR syntax error"}
+            }
+            i++
+        default:
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+    }
+
+    // Visit whole buckets until we have about count keys
+    // Like Redis, COUNT is a hint: a bucket is never split between two calls,
+    // and MATCH is applied afterwards, so a batch may even come back empty
+    // while the iteration is still going
+    // Each bucket is read on its own, so the index may be resized between
+    // two of them; the cursor stays valid across that, as between calls
+    batch := []Value{}
+    visited := 0
+    for {
+        keys, mask := scanBucketKeys(cursor)
+        for _, key := range keys {
+            visited++
+            // Keys whose time to live has run out are logically gone
+            if isExpired(key) {
+                continue
+            }
+            if pattern == "" || matchPattern(pattern, key) {
+                batch = append(batch, Value{typ: "bulk", bulk: key})
+            }
+        }
+        cursor = nextScanCursor(cursor, mask)
+        if cursor == 0 || visited >= count {
+            break
+        }
+    }
+
+    return Value{typ: "array", array: []Value{
+        {typ: "bulk", bulk: strconv.FormatUint(cursor, 10)},
+        {typ: "array", array: batch},
+    }}
+}
@@ -0,0 +1,153 @@
+// Package server tests SCAN
+package server
+
+import (
+    "fmt"
+    "testing"
+)
+
+// scanAll runs a SCAN to completion with the given COUNT, calling between
+// after every call except the last, and returns how often each key came back
+func scanAll(t *testing.T, c *Client, count string, between func(call int)) map[string]int {
+    t.Helper()
+
+    seen := map[string]int{}
+    cursor := "0"
+    for call := 0; ; call++ {
+        reply := run(c, "SCAN", cursor, "COUNT", count)
+        if reply.typ != "array" || len(reply.array) != 2 {
+            t.Fatalf("SCAN %s: got %+v", cursor, reply)
+        }
+        for _, key := range reply.array[1].array {
+            seen[key.bulk]++
+        }
+        cursor = reply.array[0].bulk
+        if cursor == "0" {
+            return seen
+        }
+        if call > 10000 {
+            t.Fatal("SCAN never finished")
+        }
+        between(call)
+    }
+}
+
+// TestScanReturnsStableKeys adds and removes keys while a scan runs, growing
+// and then shrinking the keyspace, and checks that every key that existed for
+// the whole scan was returned
+func TestScanReturnsStableKeys(t *testing.T) {
+    c := newTestClient(t)
+    for i := 0; i < 200; i++ {
+        run(c, "SET", fmt.Sprint("stable:", i), "v")
+    }
+
+    added, calls := 0, 0
+    seen := scanAll(t, c, "10", func(call int) {
+        calls++
+        // Grow for the first calls, so the bucket count doubles several
+        // times, then delete the added keys again so it shrinks
+        if call < 8 {
+            for i := 0; i < 100; i++ {
+                run(c, "SET", fmt.Sprint("churn:", added), "v")
+                added++
+            }
+            return
+        }
+        for i := 0; i < 100 && added > 0; i++ {
+            added--
+            run(c, "DEL", fmt.Sprint("churn:", added))
+        }
+    })
+    if calls <= 8 {
+        t.Fatalf("the scan took %d calls, too few to shrink the keyspace again", calls+1)
+    }
+
+    for i := 0; i < 200; i++ {
+        if key := fmt.Sprint("stable:", i); seen[key] == 0 {
+            t.Errorf("%s was never returned", key)
+        }
+    }
+}
+
+// TestScanWithoutWrites checks that a scan of an unchanging keyspace returns
+// every key exactly once
+func TestScanWithoutWrites(t *testing.T) {
+    c := newTestClient(t)
+    for i := 0; i < 100; i++ {
+        run(c, "SET", fmt.Sprint("k", i), "v")
+    }
+    run(c, "HSET", "h", "f", "v")
+    run(c, "RPUSH", "l", "a")
+
+    seen := scanAll(t, c, "7", func(int) {})
+    if len(seen) != 102 {
+        t.Fatalf("got %d keys, want 102", len(seen))
+    }
+    for key, n := range seen {
+        if n != 1 {
+            t.Errorf("%s was returned %d times", key, n)
+        }
+    }
+}
+
+// TestScanIndexMatchesStores creates and removes keys of every type in the
+// ways the commands can, and checks that the SCAN index ends up holding
+// exactly the stored keys
+func TestScanIndexMatchesStores(t *testing.T) {
+    c := newTestClient(t)
+    for _, command := range [][]string{
+        {"SET", "s", "v"},
+        {"SET", "s", "w"},
+        {"HSET", "h", "f", "v"},
+        {"RPUSH", "l", "a", "b"},
+        {"LMOVE", "l", "l2", "LEFT", "RIGHT"},
+        {"LPOP", "l"},
+        {"SADD", "set", "a", "b"},
+        {"SINTERSTORE", "inter", "set"},
+        {"SREM", "set", "a", "b"},
+        {"ZADD", "z", "1", "a"},
+        {"ZINCRBY", "z2", "1", "a"},
+        {"ZREM", "z", "a"},
+        {"GETDEL", "s"},
+        {"SET", "gone", "v"},
+        {"UNLINK", "gone"},
+        {"SET", "d", "v"},
+    } {
+        if reply := run(c, command...); reply.typ == "error" {
+            t.Fatalf("%v: %s", command, reply.str)
+        }
+    }
+    dumped := run(c, "DUMP", "d")
+    run(c, "DEL", "d")
+    run(c, "RESTORE", "d", "0", dumped.bulk)
+
+    rLockAllStores()
+    stored := storedKeysLocked()
+    rUnlockAllStores()
+
+    scanIndexMu.Lock()
+    indexed := map[string]int{}
+    for _, bucket := range scanIndex {
+        for key, stores := range bucket {
+            indexed[key] = stores
+        }
+    }
+    keys := scanIndexKeys
+    scanIndexMu.Unlock()
+
+    if len(indexed) != len(stored) || keys != len(stored) {
+        t.Fatalf("indexed %v (%d keys), stored %v", indexed, keys, stored)
+    }
+    for _, key := range stored {
+        if indexed[key] != 1 {
+            t.Errorf("%s is indexed %d times", key, indexed[key])
+        }
+    }
+
+    emptyDataset()
+    scanIndexMu.Lock()
+    defer scanIndexMu.Unlock()
+    if scanIndexKeys != 0 {
+        t.Fatalf("%d keys left in the index after emptying the dataset", scanIndexKeys)
+    }
+}
@@ -0,0 +1,796 @@
+// Package server implements a Redis-like server with basic command handling functionality
+// A Server listens for clients, rebuilds the dataset from the AOF and serves
+// commands until it is closed or a client runs SHUTDOWN. The program in the
+// repository root wraps it with command-line flags
+//
+// The dataset, the configuration and the registries of clients and
+// subscriptions are package-level state, so only one Server may run in a
+// process at a time
+package server
+
+// Import necessary standard library packages:
+// - crypto/tls, crypto/x509: for encrypted connections
+// - errors: for reporting invalid TLS setup
+// - fmt: for printing messages and errors
+// - net: for network functionality (TCP and Unix socket servers)
+// - net/http: for the metrics and health check endpoints
+// - os: for socket files and the data directory
+// - path/filepath: for placing data files in the data directory
+// - sort: for applying the configuration in a fixed order
+// - strings: for string manipulation (converting commands to uppercase)
+// - sync, sync/atomic: for the state shared by every Server
+// - time: for client idle timeouts
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Options configures a Server
+// At least one of Addrs and UnixSocket must be set
+type Options struct {
+    Addrs      []string  // TCP addresses to listen on, like ":6379"; port 0 picks a free port
+    UnixSocket string    // Path of a Unix domain socket to listen on, empty for none
+
+    // Accept queue and port sharing for the TCP listeners, see listen.go
+    TCPBacklog   int            // TCP accept queue length, 0 for the default; a hint, capped by the kernel limit
+    ReusePort    bool           // Set SO_REUSEPORT so several servers can share the TCP port
+    TCPKeepalive time.Duration  // Time between TCP keepalive probes on client connections, 0 disables them
+
+    // TLS for the TCP listeners
+    // Setting both a certificate and a key turns it on; adding a CA bundle
+    // also requires clients to present a certificate signed by it (mTLS)
+    TLSCert string  // TLS certificate file (PEM)
+    TLSKey  string  // TLS private key file (PEM)
+    TLSCA   string  // CA certificate file (PEM) used to verify client certificates
+
+    Dir         string  // Directory for data files such as the AOF, created if missing; empty means the working directory
+    MetricsPort int     // HTTP port serving Prometheus metrics on /metrics, 0 disables it
+    HealthzPort int     // HTTP port serving only the /healthz health check, 0 disables it
+    LogFile     string  // File to append log messages to, reopened on SIGHUP; empty means stdout
+
+    // Starting values of runtime parameters, by their CONFIG SET name, such
+    // as "maxmemory". CONFIG SET can still change them later
+    Config map[string]string
+}
+
+// Server is one instance of the server, from NewServer until it is closed
+type Server struct {
+    opts      Options
+    tlsConfig *tls.Config      // nil without TLS
+    listeners []net.Listener   // All the listeners we accept connections on
+    http      []*http.Server   // The metrics and health check endpoints
+    aof       *Aof
+    open      bool             // Listen claimed the process for this server, see running
+    serving   atomic.Bool      // Serve has started, so Close waits for it
+    done      chan struct{}    // Closed once Serve has shut the server down
+}
+
+// errServerRunning is returned by Listen while another Server is running
+var errServerRunning = errors.New("a server is already running in this process")
+
+// running is set from Listen until the server is closed, see errServerRunning
+var running atomic.Bool
+
+// activeExpireOnce starts the active expiry sweeper with the first Server
+// It keeps running for the life of the process, and skips an empty dataset cheaply
+var activeExpireOnce sync.Once
+
+// reopenLogOnce starts the SIGHUP handler with the first Server that logs to a file
+var reopenLogOnce sync.Once
+
+// NewServer checks opts and applies its configuration
+// Nothing is opened yet apart from the log file; see Listen and Serve
+func NewServer(opts Options) (*Server, error) {
+    // Open the log file first, so every message after this ends up in it
+    if opts.LogFile != "" {
+        if err := logOutput.Open(opts.LogFile); err != nil {
+            return nil, fmt.Errorf("Can't open log file: %w", err)
+        }
+        reopenLogOnce.Do(func() { go reopenLogOnSIGHUP() })
+    }
+
+    tlsConfig, err := loadTLSConfig(opts.TLSCert, opts.TLSKey, opts.TLSCA)
+    if err != nil {
+        return nil, err
+    }
+
+    // Applied in name order, so a bad value is always reported the same way
+    names := make([]string, 0, len(opts.Config))
+    for name := range opts.Config {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if err := config.Set(name, opts.Config[name]); err != nil {
+            return nil, fmt.Errorf("Invalid %s: %s", name, opts.Config[name])
+        }
+    }
+
+    if opts.TCPKeepalive < 0 {
+        return nil, fmt.Errorf("Invalid TCP keepalive: %v", opts.TCPKeepalive)
+    }
+    if len(opts.Addrs) == 0 && opts.UnixSocket == "" {
+        return nil, errors.New("Nothing to listen on: set a TCP address or a Unix socket")
+    }
+    if opts.TCPBacklog == 0 {
+        opts.TCPBacklog = defaultTCPBacklog
+    }
+    if opts.Dir == "" {
+        opts.Dir = "."
+    }
+
+    return &Server{opts: opts, tlsConfig: tlsConfig, done: make(chan struct{})}, nil
+}
+
+// ListenAndServe runs Listen and then Serve
+func (s *Server) ListenAndServe() error {
+    if err := s.Listen(); err != nil {
+        return err
+    }
+    return s.Serve()
+}
+
+// Listen opens the listeners and the AOF, and loads the dataset from the AOF
+// Clients can connect once it returns, but aren't served until Serve runs
+func (s *Server) Listen() error {
+    if !running.CompareAndSwap(false, true) {
+        return errServerRunning
+    }
+    s.open = true
+    if err := s.listen(); err != nil {
+        s.release()
+        return err
+    }
+    return nil
+}
+
+// listen does the work of Listen
+// Whatever it opened before failing is left for release to close
+func (s *Server) listen() error {
+    // A server earlier in the process may have shut down; this one starts afresh
+    shuttingDown.Store(false)
+    select {
+    case <-shutdownRequests:
+    default:
+    }
+
+    // One listener per TCP address
+    tcpListeners := 0
+    for _, addr := range s.opts.Addrs {
+        l, err := listenTCP(addr, s.opts.TCPBacklog, s.opts.ReusePort, s.opts.TCPKeepalive)
+
+        // Error handling: if we couldn't create the listener (e.g., the address
+        // isn't local or the port is already in use) report it and try the
+        // other addresses; we only give up below if none of them worked
+        if err != nil {
+            fmt.Fprintln(logOutput, err)
+            continue
+        }
+
+        // Told to our master, if we become a replica, for its INFO
+        // Taken from the listener, so a port picked by the system is reported right
+        if tcpListeners == 0 {
+            replicaListeningPort = l.Addr().(*net.TCPAddr).Port
+        }
+        addr = l.Addr().String()
+
+        // With TLS configured, every accepted connection is a *tls.Conn
+        // It satisfies net.Conn, so the connection handler works unchanged
+        if s.tlsConfig != nil {
+            l = tls.NewListener(l, s.tlsConfig)
+            addr += " (TLS)"
+        }
+
+        // Print a message indicating that our server is starting up
+        // This will help users know the server is running
+        fmt.Fprintln(logOutput, "Listening on " + addr)
+        s.listeners = append(s.listeners, l)
+        tcpListeners++
+    }
+    if len(s.opts.Addrs) > 0 && tcpListeners == 0 {
+        return errors.New("Could not bind any TCP address")
+    }
+
+    if s.opts.UnixSocket != "" {
+        // A socket file left behind by a crashed server would make Listen fail,
+        // so remove it first - but never delete something that isn't a socket
+        if info, err := os.Stat(s.opts.UnixSocket); err == nil {
+            if info.Mode()&os.ModeSocket == 0 {
+                return errors.New("Refusing to remove non-socket file: " + s.opts.UnixSocket)
+            }
+            os.Remove(s.opts.UnixSocket)
+        }
+
+        l, err := net.Listen("unix", s.opts.UnixSocket)
+        if err != nil {
+            return err
+        }
+
+        fmt.Fprintln(logOutput, "Listening on unix socket " + s.opts.UnixSocket)
+        s.listeners = append(s.listeners, l)
+    }
+
+    // Keep every data file in one directory, so a single volume can hold them
+    if err := os.MkdirAll(s.opts.Dir, 0755); err != nil {
+        return err
+    }
+
+    // Create a new Append-Only File (AOF) for persistence
+    // This is how Redis maintains data across server restarts
+    // The file will be named "database.aof", inside Dir
+    aof, err := NewAof(filepath.Join(s.opts.Dir, aofFilename))
+    if err != nil {
+        return err
+    }
+    s.aof = aof
+
+    // Read existing commands from the AOF file and replay them
+    // This restores our database to its state before the last shutdown
+    // Anything a server earlier in the process left in memory goes first
+    emptyDataset()
+
+    // A truncated last command is repaired by Read, so any error here means
+    // the file is corrupt in a way we can't safely recover from
+    if err := loadAof(aof); err != nil {
+        return fmt.Errorf("Error loading AOF file: %w", err)
+    }
+    return nil
+}
+
+// Addrs returns the addresses the server listens on, once Listen has returned
+func (s *Server) Addrs() []net.Addr {
+    addrs := make([]net.Addr, len(s.listeners))
+    for i, l := range s.listeners {
+        addrs[i] = l.Addr()
+    }
+    return addrs
+}
+
+// Serve accepts clients on every listener until the server is closed, by
+// Close or by a client running SHUTDOWN, and then shuts down gracefully
+// Listen must have succeeded first
+func (s *Server) Serve() error {
+    s.serving.Store(true)
+    defer close(s.done)
+
+    // Start the background sweeper that deletes expired keys nobody reads
+    activeExpireOnce.Do(func() { go activeExpireCycle() })
+
+    // Start the metrics endpoint only when asked for
+    if s.opts.MetricsPort != 0 {
+        srv := &http.Server{Addr: fmt.Sprintf(":%d", s.opts.MetricsPort)}
+        s.http = append(s.http, srv)
+        go serveMetrics(srv, s.aof)
+    }
+    if s.opts.HealthzPort != 0 {
+        srv := &http.Server{Addr: fmt.Sprintf(":%d", s.opts.HealthzPort)}
+        s.http = append(s.http, srv)
+        go serveHealth(srv, s.aof)
+    }
+
+    // Serve every listener in its own goroutine
+    // Each accepted connection gets the same handler, whichever listener it came from
+    for _, l := range s.listeners {
+        go serve(l, s.aof)
+    }
+
+    // Block until we're asked to stop
+    save := <-shutdownRequests
+    fmt.Fprintln(logOutput, "Shutting down")
+    shuttingDown.Store(true)
+
+    // Close every listener so no new clients are accepted, and give the
+    // connected clients a moment to finish what they're running
+    for _, l := range s.listeners {
+        l.Close()
+    }
+    drainClients(shutdownDrainTimeout)
+
+    // Make sure every logged write is on disk, unless SHUTDOWN NOSAVE asked us not to
+    if save {
+        if err := s.aof.Sync(); err != nil {
+            fmt.Fprintln(logOutput, "Error syncing AOF file:", err)
+        }
+    }
+
+    s.release()
+    return nil
+}
+
+// Close shuts the server down the way SHUTDOWN does, syncing the AOF first,
+// and waits for Serve to finish. A server that was never served just closes
+// what Listen opened
+func (s *Server) Close() error {
+    if !s.serving.Load() {
+        s.release()
+        return nil
+    }
+
+    // Once this server has stopped, a request would only stop the next one
+    select {
+    case <-s.done:
+        return nil
+    default:
+    }
+
+    // Only the first request counts if SHUTDOWN came in at the same time
+    select {
+    case shutdownRequests <- true:
+    default:
+    }
+    <-s.done
+    return nil
+}
+
+// release closes the listeners, the HTTP endpoints and the AOF, and lets
+// another Server run. A Unix listener removes its socket file as it closes
+func (s *Server) release() {
+    if !s.open {
+        return
+    }
+    s.open = false
+
+    for _, l := range s.listeners {
+        l.Close()
+    }
+    s.listeners = nil
+    for _, srv := range s.http {
+        srv.Close()
+    }
+    s.http = nil
+    if s.aof != nil {
+        if err := s.aof.Close(); err != nil {
+            fmt.Fprintln(logOutput, "Error closing AOF file:", err)
+        }
+        s.aof = nil
+    }
+    running.Store(false)
+}
+
+// loadTLSConfig builds the TLS configuration from the certificate files in Options
+// It returns nil when TLS isn't configured
+// The minimum accepted version is TLS 1.2
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+    if certFile == "" && keyFile == "" {
+        if caFile != "" {
+            return nil, errors.New("-tls-ca requires -tls-cert and -tls-key")
+        }
+        return nil, nil
+    }
+    if certFile == "" || keyFile == "" {
+        return nil, errors.New("-tls-cert and -tls-key must be set together")
+    }
+
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, err
+    }
+
+    tlsConfig := &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        MinVersion:   tls.VersionTLS12,
+    }
+
+    // With a CA bundle, only clients holding a certificate it signed may connect
+    if caFile != "" {
+        pem, err := os.ReadFile(caFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, errors.New("no certificates found in " + caFile)
+        }
+        tlsConfig.ClientCAs = pool
+        tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+
+    return tlsConfig, nil
+}
+
+// serve accepts connections from a listener until it is closed
+// Each client is handled in its own goroutine so they don't block each other
+func serve(l net.Listener, aof *Aof) {
+    // How long to wait before accepting again after a temporary error
+    var backoff time.Duration
+
+    for {
+        // Accept a new connection from a client
+        // This blocks until a client connects
+        conn, err := l.Accept()
+
+        if err != nil {
+            // The listener was closed during shutdown, so we're done
+            if errors.Is(err, net.ErrClosed) {
+                return
+            }
+
+            // Running out of file descriptors and similar conditions go away
+            // once some clients disconnect, so wait a little and try again,
+            // doubling the wait each time up to a second, like net/http does
+            var netErr net.Error
+            if errors.As(err, &netErr) && netErr.Temporary() {
+                if backoff == 0 {
+                    backoff = 5 * time.Millisecond
+                } else if backoff *= 2; backoff > time.Second {
+                    backoff = time.Second
+                }
+                fmt.Fprintf(logOutput, "Accept error: %v; retrying in %v\n", err, backoff)
+                time.Sleep(backoff)
+                continue
+            }
+
+            // Anything else means the listener itself is broken
+            fmt.Fprintln(logOutput, err)
+            return
+        }
+        backoff = 0
+
+        // Past maxclients, turn the connection away instead of serving it
+        // The count is taken here, before the handler starts, so a burst of
+        // connections can't all slip in before any of them is counted
+        if connectedClients.Add(1) > int64(config.MaxClients()) {
+            connectedClients.Add(-1)
+            go rejectConnection(conn)
+            continue
+        }
+
+        go handleConnection(conn, aof)
+    }
+}
+
+// rejectConnection tells a client the server is full and hangs up
+// The write gets a deadline, so a client that doesn't read can't hold on to
+// the connection (or, with TLS, stall in the handshake)
+func rejectConnection(conn net.Conn) {
+    defer conn.Close()
+    conn.SetWriteDeadline(time.Now().Add(time.Second))
+    NewWriter(conn).Write(Value{typ: "error", str: "ERR max number of clients reached"})
+}
+
+// handleConnection runs the command loop for a single client connection
+func handleConnection(conn net.Conn, aof *Aof) {
+    // Ensure we close the connection when we're done with it
+    defer conn.Close()
+    defer connectedClients.Add(-1)
+
+    // Per-connection state, such as authentication and subscriptions
+    client := NewClient(conn, aof)
+
+    // Make the client visible to CLIENT LIST while it's connected, and drop
+    // its registry entry and subscriptions once it disconnects
+    registerClient(client)
+    defer unregisterClient(client)
+    defer unsubscribeAll(client)
+    defer stopMonitoring(client)
+    defer stopTracking(client)
+    defer unwatchKeys(client)
+    defer stopReplica(client)
+
+    // Create one RESP (Redis Serialization Protocol) reader for the whole connection
+    // Its buffer may already hold the next pipelined command, so it must outlive each loop
+    resp := NewResp(conn)
+
+    // Connection loop - this runs until the client disconnects, processing its commands
+    for {
+        // If an idle timeout is configured, give up on clients that stay silent too long
+        // The config is read on every iteration so CONFIG SET timeout applies immediately
+        // A replica only listens to the stream we send it, so it's never idle
+        if timeout := config.Timeout(); timeout > 0 && !client.replica {
+            conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+        } else {
+            conn.SetReadDeadline(time.Time{})
+        }
+        
+        // Read the next command from the client
+        // Commands must be RESP arrays; ReadCommand rejects anything else
+        value, err := resp.ReadCommand()
+        
+        // If there was an error reading (e.g., client disconnected),
+        // print it and exit
+        // Malformed input gets the error as a reply first, like Redis does,
+        // so the client isn't left waiting for an answer that never comes
+        if err != nil {
+            fmt.Fprintln(logOutput, err)
+            var protoErr ProtocolError
+            if errors.As(err, &protoErr) {
+                client.writer.Write(Value{typ: "error", str: "ERR " + err.Error()})
+            }
+            return
+        }
+
+        // Remember when this client was last active, for CLIENT LIST's idle time
+        client.lastActive.Store(time.Now().UnixNano())
+
+        // Execute the command and send the result back to the client
+        result := dispatch(client, value)
+
+        // A blocking command with nothing to do yet waits for its reply here
+        if client.blocked != nil {
+            var ok bool
+            if result, ok = serveBlocked(client, value, resp); !ok {
+                return
+            }
+        }
+
+        // If the reply couldn't be sent, the connection is already closed
+        if err := client.writer.Write(result); err != nil {
+            fmt.Fprintln(logOutput, err)
+            return
+        }
+
+        // CLIENT KILL aimed at this connection closes it once the reply is sent
+        if client.closeAfterReply {
+            return
+        }
+    }
+}
+
+// aofRecords returns what to append to the AOF for a command that has run and
+// replied result. A command that failed, or a blocking command that is still
+// waiting, changed nothing and isn't logged at all
+// Most write commands are logged as they were sent, but some are logged as
+// commands that have the same effect when replayed later:
+//   - GETDEL becomes DEL, since replaying it only needs to remove the key
+//   - EXPIRE, PEXPIRE and EXPIREAT become PEXPIREAT, and a RESTORE with a TTL
+//     is followed by one, so a restart doesn't push the deadline back
+//   - GETEX becomes PEXPIREAT too when it sets a TTL, and is only logged at
+//     all when it changes the TTL of a string it found
+func aofRecords(command string, cmd Command, value Value, result Value) []Value {
+    if !cmd.write || result.typ == "error" || result.typ == "" {
+        return nil
+    }
+
+    args := value.array[1:]
+    bulk := func(s string) Value { return Value{typ: "bulk", bulk: s} }
+    pexpireat := func(key Value, ms int64) Value {
+        return Value{typ: "array", array: []Value{bulk("PEXPIREAT"), key, bulk(strconv.FormatInt(ms, 10))}}
+    }
+
+    switch command {
+    case "GETDEL":
+        // Only a bulk reply means there was a string to delete
+        if result.typ != "bulk" {
+            return nil
+        }
+        return []Value{{typ: "array", array: []Value{bulk("DEL"), args[0]}}}
+    case "EXPIRE", "PEXPIRE", "EXPIREAT":
+        if ms, ok := expireTime(args[1].bulk, expireVariants[command]); ok {
+            return []Value{pexpireat(args[0], ms)}
+        }
+    case "GETEX":
+        ms, setTTL, persist, errMsg := parseGetexOptions(args[1:])
+        switch {
+        case errMsg != "" || result.typ != "bulk":
+            return nil
+        case setTTL:
+            return []Value{pexpireat(args[0], ms)}
+        case persist:
+            return []Value{value}
+        }
+        return nil
+    case "RESTORE":
+        if ms, ok := expireTime(args[1].bulk, expireVariants["PEXPIRE"]); ok && ms > time.Now().UnixMilli() {
+            restore := append([]Value{bulk("RESTORE"), args[0], bulk("0")}, args[2:]...)
+            return []Value{{typ: "array", array: restore}, pexpireat(args[0], ms)}
+        }
+    }
+
+    return []Value{value}
+}
+
+// dispatch runs one command, given as the RESP array it was sent as, for client c
+// and returns the reply. It is used both for commands read from a connection and
+// for commands replayed from the AOF, so both are validated the same way
+func dispatch(c *Client, value Value) Value {
+    // Every command needs at least a command name
+    // An empty array gets no reply at all, like in Redis
+    if value.typ != "array" || len(value.array) == 0 {
+        fmt.Fprintln(logOutput, "Invalid request, expected array length > 0")
+        return Value{}
+    }
+
+    // Extract the command name and convert to uppercase
+    // Commands in Redis are case-insensitive
+    command := strings.ToUpper(value.array[0].bulk)
+
+    // Get the command arguments (everything after the command name)
+    args := value.array[1:]
+
+    // EXEC runs a whole transaction, so it keeps every other command out until it's done
+    // Replayed commands take no lock: they run either at startup, before any
+    // client is served, or inside DEBUG RELOAD, which already holds it
+    switch {
+    case c.aof == nil:
+    case runsAlone(command, args):
+        execMu.Lock()
+        defer execMu.Unlock()
+    default:
+        execMu.RLock()
+        defer execMu.RUnlock()
+    }
+
+    // Look up the registry entry for this command
+    cmd, ok := Handlers[command]
+
+    var rejected Value
+    switch {
+    case !c.authenticated && command != "AUTH" && command != "HELLO" && command != "QUIT":
+        // When a password is configured, refuse everything but AUTH until it succeeds
+        // HELLO is let through too, since it can authenticate with its AUTH option,
+        // and so is QUIT, since anyone may hang up
+        rejected = Value{typ: "error", str: "NOAUTH Authentication required."}
+    case !ok:
+        // Like Redis, echo the command as sent and the start of its arguments
+        rejected = Value{typ: "error", str: unknownCommandError(value.array[0].bulk, args)}
+        // Replay reports its own failures, and its client has no connection
+        if c.aof != nil {
+            fmt.Fprintf(logOutput, "Unknown command %q from %s\n", value.array[0].bulk, c.conn.RemoteAddr())
+        }
+    case !cmd.arityOK(len(args)):
+        // Reject the command before it runs if it has too few or too many arguments
+        rejected = Value{typ: "error", str: "ERR wrong number of arguments for '" + strings.ToLower(command) + "' command"}
+    case cmd.write && c.aof != nil && !c.master && replicating():
+        // A replica only changes its data the way its master tells it to
+        rejected = Value{typ: "error", str: "READONLY You can't write against a read only replica."}
+    case c.subscribed() && c.writer.Protocol() == 2 && !subscribeContextCommands[command]:
+        // A RESP2 subscriber may only manage its subscriptions until it leaves them all
+        rejected = Value{typ: "error", str: "ERR Can't execute '" + strings.ToLower(command) + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"}
+    }
+    if rejected.typ != "" {
+        // A transaction with a command that couldn't be queued is refused by EXEC
+        if c.inMulti {
+            c.multiAborted = true
+        }
+        return rejected
+    }
+
+    // Inside MULTI, commands are queued for EXEC instead of run
+    if c.inMulti && !transactionCommands[command] {
+        c.queued = append(c.queued, queuedCommand{command, cmd, value})
+        return Value{typ: "string", str: "QUEUED"}
+    }
+
+    return call(c, command, cmd, value)
+}
+
+// runsAlone reports whether a command must run with every other command kept
+// out, by holding execMu for writing: EXEC, PSYNC, which snapshots the dataset,
+// and DEBUG RELOAD, which replaces it
+func runsAlone(command string, args []Value) bool {
+    if command == "EXEC" || command == "PSYNC" {
+        return true
+    }
+    return command == "DEBUG" && len(args) > 0 && strings.ToUpper(args[0].bulk) == "RELOAD"
+}
+
+// loadAof rebuilds the dataset by replaying every command in aof
+// Replayed commands run as an already authenticated client with no connection,
+// and without an AOF, so they aren't logged a second time
+// They go through the same dispatch as live commands, so a record is validated
+// exactly like the command that produced it. Replies are dropped, but a record
+// that fails is reported
+func loadAof(aof *Aof) error {
+    aofClient := &Client{authenticated: true, channels: map[string]bool{}, patterns: map[string]bool{}}
+    return aof.Read(func(value Value) {
+        if reply := dispatch(aofClient, value); reply.typ == "error" {
+            fmt.Fprintln(logOutput, "Error replaying AOF record:", reply.str)
+        }
+    })
+}
+
+// misconfError is the reply to a write refused because the AOF is failing
+func misconfError(err error) Value {
+    return Value{typ: "error", str: "MISCONF Errors writing to the AOF file: " + err.Error()}
+}
+
+// unknownArgsLen caps how much of an unknown command and of its arguments is
+// echoed back, as in Redis
+const unknownArgsLen = 128
+
+// unknownCommandError builds the error message for a command we don't implement
+// Arguments are added, each quoted, until unknownArgsLen bytes of them have
+// been echoed. An error reply is a single line, so line breaks become spaces
+func unknownCommandError(name string, args []Value) string {
+    if len(name) > unknownArgsLen {
+        name = name[:unknownArgsLen]
+    }
+    echoed := ""
+    for _, arg := range args {
+        if len(echoed) >= unknownArgsLen {
+            break
+        }
+        s := arg.bulk
+        if room := unknownArgsLen - len(echoed); len(s) > room {
+            s = s[:room]
+        }
+        echoed += "'" + s + "' "
+    }
+    msg := "ERR unknown command '" + name + "', with args beginning with: " + echoed
+    return strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+}
+
+// call runs a command that dispatch has already validated
+// EXEC uses it too, for each of the commands it queued
+// Commands replayed from the AOF (c.aof is nil) are neither logged again nor
+// checked against maxmemory, and they don't show up in the slow log, the metrics
+// or MONITOR
+func call(c *Client, command string, cmd Command, value Value) Value {
+    args := value.array[1:]
+
+    // Replaying the AOF only rebuilds what was already there, so it just runs the command
+    if c.aof == nil {
+        return cmd.handler(c, args)
+    }
+
+    // While the AOF is failing, a write would only live in memory, so unless
+    // stop-writes-on-persistence-error is off it is refused instead
+    if cmd.write && config.StopWritesOnPersistenceError() {
+        if err := c.aof.Err(); err != nil {
+            return misconfError(err)
+        }
+    }
+
+    // A write is applied and persisted as one step, see writeMu
+    // Evicting to make room for it is a write too, so that is included
+    if cmd.write {
+        writeMu.Lock()
+        defer writeMu.Unlock()
+    }
+
+    // Before running a command that can grow the dataset, make room for it
+    // If we're over maxmemory and nothing can be evicted, refuse the write
+    // A replica runs whatever its master ran, since the master already made room
+    if denyOOMCommands[command] && !c.master && !freeMemoryIfNeeded(c.aof) {
+        return Value{typ: "error", str: "OOM command not allowed when used memory > 'maxmemory'."}
+    }
+
+    // Show the command to anyone running MONITOR
+    feedMonitors(c, value)
+
+    // Remember what a tracking client reads, so it hears when that changes
+    trackReads(c, command, args)
+
+    // The handler is timed for the slow log and INFO commandstats
+    start := time.Now()
+    result := cmd.handler(c, args)
+    dur := time.Since(start)
+    slowlog.Record(value, dur, c.conn.RemoteAddr().String())
+    recordCommand(command, dur)
+
+    // If this was a write, write what it did to the AOF file for persistence
+    // It's logged once it has run, so only changes that were actually made
+    // are replayed. The change is already in memory if the AOF can't take
+    // it, but the client is still told it wasn't persisted
+    if err := persist(c.aof, aofRecords(command, cmd, value, result)); err != nil && config.StopWritesOnPersistenceError() {
+        return misconfError(err)
+    }
+
+    return result
+}
+
+// persist appends records to aof and sends them to the replicas
+func persist(aof *Aof, records []Value) error {
+    var err error
+    for _, record := range records {
+        if werr := aof.Write(record); werr != nil && err == nil {
+            err = werr
+        }
+    }
+
+    // Replicas get the same records the AOF does
+    propagate(records)
+    return err
+}
@@ -0,0 +1,216 @@
+// Package server tests the command dispatch path
+// The helpers here run commands the way a connection does, through dispatch,
+// against an empty dataset and an AOF in a temporary directory
+package server
+
+import (
+    "io"
+    "net"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// newTestClient empties the dataset and returns a client whose writes are
+// logged to a fresh AOF, closed again when the test ends
+func newTestClient(t testing.TB) *Client {
+    t.Helper()
+
+    emptyDataset()
+
+    aof, err := NewAof(filepath.Join(t.TempDir(), aofFilename))
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := newPeerClient(t, aof)
+    t.Cleanup(func() { c.aof.Close() })
+    return c
+}
+
+// newPeerClient returns another client logging to aof, for tests that need
+// several connections at once
+func newPeerClient(t testing.TB, aof *Aof) *Client {
+    conn, peer := net.Pipe()
+    t.Cleanup(func() {
+        conn.Close()
+        peer.Close()
+    })
+    return NewClient(conn, aof)
+}
+
+// run sends one command for c through dispatch and returns the reply
+func run(c *Client, args ...string) Value {
+    command := make([]Value, len(args))
+    for i, arg := range args {
+        command[i] = Value{typ: "bulk", bulk: arg}
+    }
+    return dispatch(c, Value{typ: "array", array: command})
+}
+
+// restart simulates restarting the server: the AOF is closed, the dataset
+// dropped, and the file replayed into an empty dataset the way startup does
+func restart(t *testing.T, c *Client) {
+    t.Helper()
+
+    path := c.aof.path
+    if err := c.aof.Close(); err != nil {
+        t.Fatal(err)
+    }
+    emptyDataset()
+
+    aof, err := NewAof(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := loadAof(aof); err != nil {
+        t.Fatal(err)
+    }
+    c.aof = aof
+}
+
+// expectError fails the test unless reply is an error starting with prefix
+func expectError(t *testing.T, reply Value, prefix string) {
+    t.Helper()
+    if reply.typ != "error" || len(reply.str) < len(prefix) || reply.str[:len(prefix)] != prefix {
+        t.Fatalf("got %+v, want an error starting with %q", reply, prefix)
+    }
+}
+
+// expectBulk fails the test unless reply is the bulk string want
+func expectBulk(t *testing.T, reply Value, want string) {
+    t.Helper()
+    if reply.typ != "bulk" || reply.bulk != want {
+        t.Fatalf("got %+v, want bulk %q", reply, want)
+    }
+}
+
+// expectInteger fails the test unless reply is the integer want
+func expectInteger(t *testing.T, reply Value, want int) {
+    t.Helper()
+    if reply.typ != "integer" || reply.num != want {
+        t.Fatalf("got %+v, want integer %d", reply, want)
+    }
+}
+
+// TestProtocolErrorReply checks that a client sending a malformed length gets
+// the protocol error as a reply before the connection is closed
+func TestProtocolErrorReply(t *testing.T) {
+    tests := []struct {
+        input string
+        reply string
+    }{
+        {"*abc\r\n", "-ERR Protocol error: invalid multibulk length\r\n"},
+        {"*1\r\n$x\r\n", "-ERR Protocol error: invalid bulk length\r\n"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.input, func(t *testing.T) {
+            c := newTestClient(t)
+            conn, peer := net.Pipe()
+            connectedClients.Add(1)
+            done := make(chan struct{})
+            go func() {
+                handleConnection(conn, c.aof)
+                close(done)
+            }()
+
+            go peer.Write([]byte(tt.input))
+            reply, err := io.ReadAll(peer)
+            if err != nil {
+                t.Fatal(err)
+            }
+            <-done
+            if string(reply) != tt.reply {
+                t.Fatalf("got %q, want %q", reply, tt.reply)
+            }
+        })
+    }
+}
+
+// startServer runs a Server on a free port with its data in dir
+func startServer(t *testing.T, dir string) (*Server, net.Conn) {
+    t.Helper()
+
+    s, err := NewServer(Options{Addrs: []string{"127.0.0.1:0"}, Dir: dir})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Listen(); err != nil {
+        t.Fatal(err)
+    }
+    go s.Serve()
+
+    conn, err := net.Dial("tcp", s.Addrs()[0].String())
+    if err != nil {
+        s.Close()
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        conn.Close()
+        s.Close()
+    })
+    return s, conn
+}
+
+// send writes a command on conn and reads back the reply
+func send(t *testing.T, conn net.Conn, resp *Resp, args ...string) Value {
+    t.Helper()
+
+    command := make([]Value, len(args))
+    for i, arg := range args {
+        command[i] = Value{typ: "bulk", bulk: arg}
+    }
+    if _, err := conn.Write(Value{typ: "array", array: command}.Marshal()); err != nil {
+        t.Fatal(err)
+    }
+    reply, err := resp.ReadReply()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return reply
+}
+
+// TestServerLifecycle runs a Server in the test process, restarts it from its
+// AOF, and checks that only one Server runs at a time
+func TestServerLifecycle(t *testing.T) {
+    dir := t.TempDir()
+
+    s, conn := startServer(t, dir)
+    resp := NewResp(conn)
+    if reply := send(t, conn, resp, "SET", "k", "v"); reply.str != "OK" {
+        t.Fatalf("SET: got %+v", reply)
+    }
+
+    other, err := NewServer(Options{Addrs: []string{"127.0.0.1:0"}, Dir: t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := other.Listen(); err != errServerRunning {
+        t.Fatalf("a second Listen: got %v, want %v", err, errServerRunning)
+    }
+
+    if err := s.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    // The new server loads what the first one wrote, and nothing else
+    run(newTestClient(t), "SET", "leftover", "v")
+    _, conn = startServer(t, dir)
+    resp = NewResp(conn)
+    expectBulk(t, send(t, conn, resp, "GET", "k"), "v")
+    if reply := send(t, conn, resp, "GET", "leftover"); reply.typ != "null" {
+        t.Fatalf("GET leftover: got %+v, want null", reply)
+    }
+}
+
+// TestServerShutdownCommand checks that SHUTDOWN stops Serve
+func TestServerShutdownCommand(t *testing.T) {
+    s, conn := startServer(t, t.TempDir())
+    conn.Write(Value{typ: "array", array: []Value{{typ: "bulk", bulk: "SHUTDOWN"}}}.Marshal())
+
+    select {
+    case <-s.done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Serve didn't return after SHUTDOWN")
+    }
+}
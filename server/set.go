@@ -0,0 +1,560 @@
+// Package server implements the set data type
+// A set is an unordered collection of unique strings
+package server
+
+import (
+    "math/rand"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// SSETs is our set store
+// It maps each key to its members. The name follows the S* commands, since
+// SETs already holds plain string values
+var SSETs = map[string]map[string]struct{}{}
+
+// SSETsMu protects access to the SSETs map and the sets inside it
+var SSETsMu = sync.RWMutex{}
+
+// setMemberMemory is the estimated size of one set member
+func setMemberMemory(member string) int64 {
+    return int64(fieldOverhead + len(member))
+}
+
+// removeEmptySetLocked deletes key once its last member is gone
+// Like Redis, an empty set doesn't exist, so it also loses its expiry
+// The caller must hold SSETsMu for writing
+func removeEmptySetLocked(key string) bool {
+    if len(SSETs[key]) > 0 {
+        return false
+    }
+    delete(SSETs, key)
+    trackMemory(-int64(keyOverhead + len(key)))
+    unindexKey(key)
+    clearExpiration(key)
+    forgetKeyAccess(key)
+    return true
+}
+
+// memberArray converts set members into a sorted array reply
+// Redis returns members in no particular order; sorting makes replies stable
+func memberArray(members map[string]struct{}) Value {
+    sorted := make([]string, 0, len(members))
+    for member := range members {
+        sorted = append(sorted, member)
+    }
+    sort.Strings(sorted)
+
+    values := make([]Value, 0, len(sorted))
+    for _, member := range sorted {
+        values = append(values, Value{typ: "bulk", bulk: member})
+    }
+    return Value{typ: "array", array: values}
+}
+
+// parseRandomCount parses the count argument of SRANDMEMBER and HRANDFIELD
+// A negative count asks for that many picks whatever the size of the key, so
+// it is capped at MaxArrayLen, the longest array we'd accept from a client;
+// that also keeps -count from overflowing
+func parseRandomCount(arg Value) (int, bool) {
+    count, err := strconv.Atoi(arg.bulk)
+    if err != nil || count < -MaxArrayLen {
+        return 0, false
+    }
+    return count, true
+}
+
+// randomPicks chooses count positions out of n, the way SRANDMEMBER and
+// HRANDFIELD do: a positive count picks distinct positions, at most n of them,
+// and a negative count picks -count positions that may repeat
+func randomPicks(n, count int) []int {
+    if count < 0 {
+        if n == 0 {
+            return nil
+        }
+        picks := make([]int, -count)
+        for i := range picks {
+            picks[i] = rand.Intn(n)
+        }
+        return picks
+    }
+
+    // Shuffle just the first count positions into place
+    picks := make([]int, n)
+    for i := range picks {
+        picks[i] = i
+    }
+    count = min(count, n)
+    for i := 0; i < count; i++ {
+        j := i + rand.Intn(n-i)
+        picks[i], picks[j] = picks[j], picks[i]
+    }
+    return picks[:count]
+}
+
+// sadd implements the Redis SADD command
+// It adds members to a set and returns how many of them were new
+// The command format is: SADD key member [member ...]
+func sadd(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    if wrongTypeLocked(key, "set") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    if !ok {
+        members = map[string]struct{}{}
+        SSETs[key] = members
+        trackMemory(int64(keyOverhead + len(key)))
+        indexKey(key)
+    }
+
+    added := 0
+    for _, arg := range args[1:] {
+        if _, exists := members[arg.bulk]; exists {
+            continue
+        }
+        members[arg.bulk] = struct{}{}
+        trackMemory(setMemberMemory(arg.bulk))
+        added++
+    }
+    unlockAllStores()
+
+    touchKey(key)
+    if added > 0 {
+        notifyKeyspaceEvent(notifySet, "sadd", key)
+    }
+
+    return Value{typ: "integer", num: added}
+}
+
+// srem implements the Redis SREM command
+// It removes members from a set and returns how many of them were there
+// The command format is: SREM key member [member ...]
+func srem(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "set") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+
+    removed := 0
+    for _, arg := range args[1:] {
+        if _, exists := members[arg.bulk]; !exists {
+            continue
+        }
+        delete(members, arg.bulk)
+        trackMemory(-setMemberMemory(arg.bulk))
+        removed++
+    }
+    deleted := removeEmptySetLocked(key)
+    unlockAllStores()
+
+    if removed > 0 {
+        if !deleted {
+            touchKey(key)
+        }
+        notifyKeyspaceEvent(notifySet, "srem", key)
+        if deleted {
+            notifyKeyspaceEvent(notifyGeneric, "del", key)
+        }
+    }
+
+    return Value{typ: "integer", num: removed}
+}
+
+// smembers implements the Redis SMEMBERS command
+// It returns every member of a set, or an empty array if the set doesn't exist
+// The command format is: SMEMBERS key
+func smembers(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(key, "set") {
+        return wrongTypeError
+    }
+
+    members, ok := SSETs[key]
+    if ok {
+        touchKey(key)
+    }
+    return memberArray(members)
+}
+
+// sismember implements the Redis SISMEMBER command
+// It returns 1 if member is in the set and 0 otherwise
+// The command format is: SISMEMBER key member
+func sismember(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    if wrongTypeLocked(key, "set") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    _, found := members[args[1].bulk]
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(key)
+    }
+    if found {
+        return Value{typ: "integer", num: 1}
+    }
+    return Value{typ: "integer", num: 0}
+}
+
+// smismember implements the Redis SMISMEMBER command
+// It returns an array with 1 for each member that is in the set and 0 for each that isn't
+// The command format is: SMISMEMBER key member [member ...]
+func smismember(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    if wrongTypeLocked(key, "set") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    values := make([]Value, 0, len(args)-1)
+    for _, arg := range args[1:] {
+        if _, found := members[arg.bulk]; found {
+            values = append(values, Value{typ: "integer", num: 1})
+        } else {
+            values = append(values, Value{typ: "integer", num: 0})
+        }
+    }
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(key)
+    }
+    return Value{typ: "array", array: values}
+}
+
+// srandmember implements the Redis SRANDMEMBER command
+// Without a count it returns one random member, or null if the set doesn't exist
+// With a count it returns an array: up to count distinct members when count is
+// positive, and exactly -count members, possibly repeated, when it's negative
+// The command format is: SRANDMEMBER key [count]
+func srandmember(c *Client, args []Value) Value {
+    key := args[0].bulk
+    count := 1
+    if len(args) > 1 {
+        var ok bool
+        if count, ok = parseRandomCount(args[1]); !ok {
+            return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+        }
+    }
+    expireIfNeeded(key)
+
+    // Go maps can't be indexed by position, so the members are listed first
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    if wrongTypeLocked(key, "set") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    all := make([]string, 0, len(members))
+    for member := range members {
+        all = append(all, member)
+    }
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(key)
+    }
+
+    picks := randomPicks(len(all), count)
+    if len(args) == 1 {
+        if len(picks) == 0 {
+            return Value{typ: "null"}
+        }
+        return Value{typ: "bulk", bulk: all[picks[0]]}
+    }
+
+    values := make([]Value, 0, len(picks))
+    for _, i := range picks {
+        values = append(values, Value{typ: "bulk", bulk: all[i]})
+    }
+    return Value{typ: "array", array: values}
+}
+
+// scard implements the Redis SCARD command
+// It returns the number of members in a set, or 0 if the set doesn't exist
+// The command format is: SCARD key
+func scard(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    if wrongTypeLocked(key, "set") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    members, ok := SSETs[key]
+    n := len(members)
+    rUnlockAllStores()
+
+    if ok {
+        touchKey(key)
+    }
+    return Value{typ: "integer", num: n}
+}
+
+// Set operations understood by setAlgebraLocked
+const (
+    setInter = iota
+    setUnion
+    setDiff
+)
+
+// setAlgebraLocked combines the sets at keys with op and returns a new set
+// A missing key counts as an empty set. For setDiff the result is the first
+// set minus all the others
+// The caller must hold at least the read lock of SSETsMu
+func setAlgebraLocked(op int, keys []Value) map[string]struct{} {
+    result := map[string]struct{}{}
+
+    switch op {
+    case setInter:
+        // Start from the smallest set, since the result can't be bigger than it
+        smallest := -1
+        for i, key := range keys {
+            members := SSETs[key.bulk]
+            if len(members) == 0 {
+                return result
+            }
+            if smallest < 0 || len(members) < len(SSETs[keys[smallest].bulk]) {
+                smallest = i
+            }
+        }
+        for member := range SSETs[keys[smallest].bulk] {
+            inAll := true
+            for _, key := range keys {
+                if _, ok := SSETs[key.bulk][member]; !ok {
+                    inAll = false
+                    break
+                }
+            }
+            if inAll {
+                result[member] = struct{}{}
+            }
+        }
+    case setUnion:
+        for _, key := range keys {
+            for member := range SSETs[key.bulk] {
+                result[member] = struct{}{}
+            }
+        }
+    case setDiff:
+        for member := range SSETs[keys[0].bulk] {
+            result[member] = struct{}{}
+        }
+        for _, key := range keys[1:] {
+            for member := range SSETs[key.bulk] {
+                delete(result, member)
+            }
+        }
+    }
+
+    return result
+}
+
+// sintercard implements the Redis SINTERCARD command
+// It returns the size of the intersection of the given sets without building it
+// With LIMIT, counting stops once limit members are found (0 means no limit)
+// The command format is: SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercard(c *Client, args []Value) Value {
+    numKeys, err := strconv.Atoi(args[0].bulk)
+    if err != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+    if numKeys <= 0 {
+        return Value{typ: "error", str: "ERR numkeys should be greater than 0"}
+    }
+    if numKeys > len(args)-1 {
+        return Value{typ: "error", str: "ERR Number of keys can't be greater than number of args"}
+    }
+    keys := args[1 : 1+numKeys]
+
+    limit := 0
+    rest := args[1+numKeys:]
+    switch {
+    case len(rest) == 0:
+    case len(rest) == 2 && strings.ToUpper(rest[0].bulk) == "LIMIT":
+        limit, err = strconv.Atoi(rest[1].bulk)
+        if err != nil {
+            return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+        }
+        if limit < 0 {
+            return Value{typ: "error", str: "ERR LIMIT can't be negative"}
+        }
+    default:
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+
+    for _, key := range keys {
+        expireIfNeeded(key.bulk)
+    }
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if anyWrongTypeLocked(keys, "set") {
+        return wrongTypeError
+    }
+
+    // Walk the smallest set and look each member up in the others
+    smallest := SSETs[keys[0].bulk]
+    for _, key := range keys[1:] {
+        if len(SSETs[key.bulk]) < len(smallest) {
+            smallest = SSETs[key.bulk]
+        }
+    }
+
+    count := 0
+    for member := range smallest {
+        inAll := true
+        for _, key := range keys {
+            if _, ok := SSETs[key.bulk][member]; !ok {
+                inAll = false
+                break
+            }
+        }
+        if inAll {
+            count++
+            if count == limit {
+                break
+            }
+        }
+    }
+
+    return Value{typ: "integer", num: count}
+}
+
+// setAlgebra runs op over the sets named in args and replies with the members
+// It is shared by SINTER, SUNION and SDIFF
+func setAlgebra(args []Value, op int) Value {
+    for _, arg := range args {
+        expireIfNeeded(arg.bulk)
+    }
+
+    // Every store is read-locked so an input of another type is refused
+    rLockAllStores()
+    if anyWrongTypeLocked(args, "set") {
+        rUnlockAllStores()
+        return wrongTypeError
+    }
+    result := setAlgebraLocked(op, args)
+    rUnlockAllStores()
+
+    return memberArray(result)
+}
+
+// setAlgebraStore runs op over the sets named in args[1:] and stores the result at args[0]
+// It is shared by SINTERSTORE, SUNIONSTORE and SDIFFSTORE and replies with the
+// size of the stored set. An empty result deletes the destination, like in Redis
+func setAlgebraStore(args []Value, op int, event string) Value {
+    dest := args[0].bulk
+    for _, arg := range args {
+        expireIfNeeded(arg.bulk)
+    }
+
+    // Hold the write locks across reading the inputs and writing the destination,
+    // so the stored result matches the inputs even if dest is one of them
+    // Every store is locked because dest may hold a value of any type
+    // The inputs must be sets, but dest is replaced whatever it holds
+    lockAllStores()
+    if anyWrongTypeLocked(args[1:], "set") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    result := setAlgebraLocked(op, args[1:])
+
+    // Drop the old destination, whatever its type, including its expiry,
+    // before storing the new one
+    existed := deleteKeyLocked(dest)
+    if len(result) > 0 {
+        SSETs[dest] = result
+        trackMemory(int64(keyOverhead + len(dest)))
+        indexKey(dest)
+        for member := range result {
+            trackMemory(setMemberMemory(member))
+        }
+    }
+    unlockAllStores()
+
+    if len(result) > 0 {
+        touchKey(dest)
+        notifyKeyspaceEvent(notifySet, event, dest)
+    } else if existed {
+        notifyKeyspaceEvent(notifyGeneric, "del", dest)
+    }
+
+    return Value{typ: "integer", num: len(result)}
+}
+
+// sinter implements the Redis SINTER command
+// It returns the members present in every given set
+// The command format is: SINTER key [key ...]
+func sinter(c *Client, args []Value) Value {
+    return setAlgebra(args, setInter)
+}
+
+// sunion implements the Redis SUNION command
+// It returns the members present in any of the given sets
+// The command format is: SUNION key [key ...]
+func sunion(c *Client, args []Value) Value {
+    return setAlgebra(args, setUnion)
+}
+
+// sdiff implements the Redis SDIFF command
+// It returns the members of the first set that are in none of the others
+// The command format is: SDIFF key [key ...]
+func sdiff(c *Client, args []Value) Value {
+    return setAlgebra(args, setDiff)
+}
+
+// sinterstore implements the Redis SINTERSTORE command
+// The command format is: SINTERSTORE destination key [key ...]
+func sinterstore(c *Client, args []Value) Value {
+    return setAlgebraStore(args, setInter, "sinterstore")
+}
+
+// sunionstore implements the Redis SUNIONSTORE command
+// The command format is: SUNIONSTORE destination key [key ...]
+func sunionstore(c *Client, args []Value) Value {
+    return setAlgebraStore(args, setUnion, "sunionstore")
+}
+
+// sdiffstore implements the Redis SDIFFSTORE command
+// The command format is: SDIFFSTORE destination key [key ...]
+func sdiffstore(c *Client, args []Value) Value {
+    return setAlgebraStore(args, setDiff, "sdiffstore")
+}
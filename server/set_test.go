@@ -0,0 +1,106 @@
+// Package server tests the set commands
+package server
+
+import "testing"
+
+// TestSaddOnOtherType checks that SADD refuses a key holding another type
+func TestSaddOnOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+
+    expectError(t, run(c, "SADD", "k", "m"), "WRONGTYPE")
+    expectBulk(t, run(c, "GET", "k"), "v")
+
+    SSETsMu.RLock()
+    _, inSSETs := SSETs["k"]
+    SSETsMu.RUnlock()
+    if inSSETs {
+        t.Fatal("SADD created a set next to the string")
+    }
+}
+
+// TestStoreReplacesOtherType checks that the *STORE commands replace a
+// destination holding another type instead of storing the set next to it
+func TestStoreReplacesOtherType(t *testing.T) {
+    for _, command := range []string{"SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE"} {
+        t.Run(command, func(t *testing.T) {
+            c := newTestClient(t)
+            run(c, "SADD", "src", "a", "b")
+            run(c, "HSET", "dest", "f", "v")
+
+            expectInteger(t, run(c, command, "dest", "src"), 2)
+            expectInteger(t, run(c, "SCARD", "dest"), 2)
+            expectError(t, run(c, "HGET", "dest", "f"), "WRONGTYPE")
+
+            HSETsMu.RLock()
+            _, inHSETs := HSETs["dest"]
+            HSETsMu.RUnlock()
+            if inHSETs {
+                t.Fatal("the old hash is still stored")
+            }
+        })
+    }
+}
+
+// TestEmptyStoreDeletesOtherType checks that an empty result deletes a
+// destination of another type, as it deletes a set
+func TestEmptyStoreDeletesOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "dest", "v")
+
+    expectInteger(t, run(c, "SINTERSTORE", "dest", "missing"), 0)
+    if reply := run(c, "GET", "dest"); reply.typ != "null" {
+        t.Fatalf("GET dest: got %+v, want null", reply)
+    }
+}
+
+// TestRandomCountOutOfRange checks that SRANDMEMBER and HRANDFIELD refuse a
+// negative count too big to allocate, including one that can't be negated
+func TestRandomCountOutOfRange(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SADD", "s", "a", "b")
+    run(c, "HSET", "h", "f", "v")
+
+    for _, tt := range []struct{ command, key string }{
+        {"SRANDMEMBER", "s"},
+        {"HRANDFIELD", "h"},
+    } {
+        command, key := tt.command, tt.key
+        for _, count := range []string{"-9223372036854775808", "-9223372036854775807", "-1000000000000"} {
+            expectError(t, run(c, command, key, count), "ERR value is not an integer or out of range")
+        }
+
+        // A negative count within the limit still repeats picks
+        if reply := run(c, command, key, "-5"); reply.typ != "array" || len(reply.array) != 5 {
+            t.Fatalf("%s %s -5: got %+v, want 5 picks", command, key, reply)
+        }
+    }
+}
+
+// TestSetCommandsOnOtherType checks that every set command refuses a key
+// holding a string, whether it's the only key or one of several inputs
+func TestSetCommandsOnOtherType(t *testing.T) {
+    for _, command := range [][]string{
+        {"SREM", "k", "a"},
+        {"SMEMBERS", "k"},
+        {"SISMEMBER", "k", "a"},
+        {"SMISMEMBER", "k", "a"},
+        {"SRANDMEMBER", "k"},
+        {"SCARD", "k"},
+        {"SINTER", "s", "k"},
+        {"SUNION", "s", "k"},
+        {"SDIFF", "s", "k"},
+        {"SINTERCARD", "2", "s", "k"},
+        {"SUNIONSTORE", "dest", "s", "k"},
+    } {
+        t.Run(command[0], func(t *testing.T) {
+            c := newTestClient(t)
+            run(c, "SET", "k", "v")
+            run(c, "SADD", "s", "a")
+
+            expectError(t, run(c, command...), "WRONGTYPE")
+            expectBulk(t, run(c, "GET", "k"), "v")
+            expectInteger(t, run(c, "TOUCH", "dest"), 0)
+        })
+    }
+}
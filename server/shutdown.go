@@ -0,0 +1,82 @@
+// Package server implements the SHUTDOWN command
+// A client asks the server to stop; Serve then shuts the server down the
+// same way Close does
+package server
+
+import (
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// shutdownRequests carries SHUTDOWN and Close requests to Serve
+// The value says whether the AOF should be synced to disk before exiting
+var shutdownRequests = make(chan bool, 1)
+
+// shuttingDown is set once the server has started to shut down, so the
+// health check can tell load balancers to stop sending clients here
+var shuttingDown atomic.Bool
+
+// shutdownDrainTimeout is how long shutdown waits for connected clients to
+// finish the command they are running before closing them anyway
+const shutdownDrainTimeout = time.Second
+
+// shutdown implements the Redis SHUTDOWN command
+// SAVE (the default) syncs the AOF to disk before exiting, NOSAVE skips it
+// Like Redis, a successful SHUTDOWN sends no reply: the client just sees
+// the connection close
+// The command format is: SHUTDOWN [NOSAVE|SAVE]
+func shutdown(c *Client, args []Value) Value {
+    save := true
+    if len(args) == 1 {
+        switch strings.ToUpper(args[0].bulk) {
+        case "SAVE":
+            save = true
+        case "NOSAVE":
+            save = false
+        default:
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+    }
+
+    // Only the first request counts if several clients ask at once
+    select {
+    case shutdownRequests <- save:
+    default:
+    }
+
+    c.closeAfterReply = true
+
+    // An empty Value marshals to nothing, so no reply is written
+    return Value{}
+}
+
+// drainClients waits up to timeout for every client to disconnect
+// Clients blocked waiting for their next command are woken up by expiring
+// their read deadline, so only a command that is already running holds us up
+func drainClients(timeout time.Duration) {
+    clientsMu.RLock()
+    for _, c := range clients {
+        c.conn.SetReadDeadline(time.Now())
+    }
+    clientsMu.RUnlock()
+
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        clientsMu.RLock()
+        remaining := len(clients)
+        clientsMu.RUnlock()
+
+        if remaining == 0 {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    // Whoever is still connected gets cut off
+    clientsMu.RLock()
+    for _, c := range clients {
+        c.conn.Close()
+    }
+    clientsMu.RUnlock()
+}
@@ -0,0 +1,172 @@
+// Package server implements the slow log (SLOWLOG)
+// Commands whose execution takes longer than a configurable threshold are
+// recorded in a fixed-size ring buffer so operators can find what's stalling
+package server
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Limits applied to the arguments we keep for each entry, like Redis
+// Long commands are cut short so the slow log can't use unbounded memory
+const (
+    slowlogMaxArgc   = 32   // Keep at most this many arguments (including the command name)
+    slowlogMaxArgLen = 128  // Keep at most this many bytes of each argument
+)
+
+// SlowlogEntry is one recorded slow command
+type SlowlogEntry struct {
+    id        int64          // Unique, increasing id
+    timestamp int64          // Unix time the command was run
+    duration  time.Duration  // How long the handler took
+    args      []string       // Command name and arguments, truncated
+    addr      string         // Address of the client that ran it
+}
+
+// Slowlog is a fixed-size ring buffer of the most recent slow commands
+type Slowlog struct {
+    mu      sync.Mutex
+    entries []SlowlogEntry  // Ring buffer storage
+    next    int             // Index the next entry will be written to
+    count   int             // Number of valid entries in the buffer
+    nextID  int64           // Id given to the next entry
+}
+
+// slowlog is the server's slow log
+var slowlog = &Slowlog{}
+
+// Record adds a command to the slow log if it ran longer than the configured threshold
+func (s *Slowlog) Record(command Value, duration time.Duration, addr string) {
+    threshold := config.SlowlogLogSlowerThan()
+    if threshold < 0 || duration < time.Duration(threshold)*time.Microsecond {
+        return
+    }
+
+    maxLen := config.SlowlogMaxLen()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    // Resize (and reset) the ring if slowlog-max-len was changed
+    if len(s.entries) != maxLen {
+        s.entries = make([]SlowlogEntry, maxLen)
+        s.next, s.count = 0, 0
+    }
+    if maxLen == 0 {
+        return
+    }
+
+    s.entries[s.next] = SlowlogEntry{
+        id:        s.nextID,
+        timestamp: time.Now().Unix(),
+        duration:  duration,
+        args:      slowlogArgs(command.array),
+        addr:      addr,
+    }
+    s.nextID++
+    s.next = (s.next + 1) % maxLen
+    if s.count < maxLen {
+        s.count++
+    }
+}
+
+// slowlogArgs copies a command's arguments, truncated to the slow log limits
+func slowlogArgs(array []Value) []string {
+    args := []string{}
+    for i, v := range array {
+        // Replace the tail of very long commands with a marker
+        if i == slowlogMaxArgc-1 && len(array) > slowlogMaxArgc {
+            more := len(array) - i
+            args = append(args, "... ("+strconv.Itoa(more)+" more arguments)")
+            break
+        }
+
+        arg := v.bulk
+        if len(arg) > slowlogMaxArgLen {
+            more := len(arg) - slowlogMaxArgLen
+            arg = arg[:slowlogMaxArgLen] + "... (" + strconv.Itoa(more) + " more bytes)"
+        }
+        args = append(args, arg)
+    }
+    return args
+}
+
+// Get returns up to count entries, most recent first
+func (s *Slowlog) Get(count int) []SlowlogEntry {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if count < 0 || count > s.count {
+        count = s.count
+    }
+
+    entries := make([]SlowlogEntry, 0, count)
+    for i := 1; i <= count; i++ {
+        index := (s.next - i + len(s.entries)) % len(s.entries)
+        entries = append(entries, s.entries[index])
+    }
+    return entries
+}
+
+// Len returns the number of entries currently in the slow log
+func (s *Slowlog) Len() int {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.count
+}
+
+// Reset removes every entry from the slow log
+func (s *Slowlog) Reset() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.next, s.count = 0, 0
+}
+
+// slowlogCommand implements the Redis SLOWLOG command family
+// The command format is: SLOWLOG <subcommand> [arguments ...]
+// Supported subcommands:
+//   SLOWLOG GET [count] - the most recent entries (10 by default, -1 for all)
+//   SLOWLOG LEN         - number of entries
+//   SLOWLOG RESET       - clear the slow log
+func slowlogCommand(c *Client, args []Value) Value {
+    subcommand := strings.ToUpper(args[0].bulk)
+    switch {
+    case subcommand == "GET" && len(args) <= 2:
+        count := 10
+        if len(args) == 2 {
+            n, err := strconv.Atoi(args[1].bulk)
+            if err != nil || n < -1 {
+                return Value{typ: "error", str: "ERR count should be greater than or equal to -1"}
+            }
+            count = n
+        }
+
+        // Each entry is [id, timestamp, duration in microseconds, [args...], client addr, client name]
+        values := []Value{}
+        for _, entry := range slowlog.Get(count) {
+            argv := []Value{}
+            for _, arg := range entry.args {
+                argv = append(argv, Value{typ: "bulk", bulk: arg})
+            }
+            values = append(values, Value{typ: "array", array: []Value{
+                {typ: "integer", num: int(entry.id)},
+                {typ: "integer", num: int(entry.timestamp)},
+                {typ: "integer", num: int(entry.duration / time.Microsecond)},
+                {typ: "array", array: argv},
+                {typ: "bulk", bulk: entry.addr},
+                {typ: "bulk", bulk: ""},
+            }})
+        }
+        return Value{typ: "array", array: values}
+    case subcommand == "LEN" && len(args) == 1:
+        return Value{typ: "integer", num: slowlog.Len()}
+    case subcommand == "RESET" && len(args) == 1:
+        slowlog.Reset()
+        return Value{typ: "string", str: "OK"}
+    default:
+        return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try SLOWLOG HELP."}
+    }
+}
@@ -0,0 +1,175 @@
+// Package server implements server-assisted client-side caching (CLIENT TRACKING)
+// A RESP3 client that turns tracking on may cache the values it reads. The
+// server remembers which keys each tracking client has read, and when one of
+// them changes it pushes ["invalidate", [key]] to those clients, which then
+// drop their copy. Like Redis's default mode, a key is forgotten once its
+// invalidation is sent, until the client reads it again
+package server
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// trackingTable maps each key read by a tracking client to those clients
+var trackingTable = map[string]map[*Client]bool{}
+
+// trackingMu protects trackingTable and each client's trackedKeys
+// It is a leaf lock, like pubsubMu; the two are never held together
+var trackingMu = sync.Mutex{}
+
+// trackedReads maps each read-only command that takes keys to the keys it reads
+// Commands that write aren't here: their own changes invalidate the key anyway
+var trackedReads = map[string]func(args []Value) []Value{
+    "GET":         firstKey,
+    "GETRANGE":    firstKey,
+    "GETBIT":      firstKey,
+    "BITCOUNT":    firstKey,
+    "DUMP":        firstKey,
+    "TTL":         firstKey,
+    "PTTL":        firstKey,
+    "HGET":        firstKey,
+    "HGETALL":     firstKey,
+    "HMGET":       firstKey,
+    "HRANDFIELD":  firstKey,
+    "ZSCORE":      firstKey,
+    "ZRANGE":      firstKey,
+    "ZRANK":       firstKey,
+    "ZCARD":       firstKey,
+    "ZCOUNT":      firstKey,
+    "LLEN":        firstKey,
+    "LRANGE":      firstKey,
+    "LINDEX":      firstKey,
+    "SMEMBERS":    firstKey,
+    "SISMEMBER":   firstKey,
+    "SMISMEMBER":  firstKey,
+    "SCARD":       firstKey,
+    "SRANDMEMBER": firstKey,
+    "SINTER":      everyKey,
+    "SUNION":      everyKey,
+    "SDIFF":       everyKey,
+    "SINTERCARD":  numkeysKeys,
+}
+
+// firstKey is the key of a command whose first argument is its only key
+func firstKey(args []Value) []Value {
+    return args[:1]
+}
+
+// everyKey is the keys of a command whose arguments are all keys
+func everyKey(args []Value) []Value {
+    return args
+}
+
+// numkeysKeys is the keys of a command of the form CMD numkeys key [key ...] ...
+func numkeysKeys(args []Value) []Value {
+    n, err := strconv.Atoi(args[0].bulk)
+    if err != nil || n < 1 || n >= len(args) {
+        return nil
+    }
+    return args[1 : 1+n]
+}
+
+// trackReads remembers the keys command reads, if c has tracking on
+// It runs before the command, so a change made while the command runs
+// still sends c an invalidation
+func trackReads(c *Client, command string, args []Value) {
+    if !c.tracking {
+        return
+    }
+    keysOf, ok := trackedReads[command]
+    if !ok {
+        return
+    }
+
+    trackingMu.Lock()
+    defer trackingMu.Unlock()
+    for _, key := range keysOf(args) {
+        if trackingTable[key.bulk] == nil {
+            trackingTable[key.bulk] = map[*Client]bool{}
+        }
+        trackingTable[key.bulk][c] = true
+        c.trackedKeys[key.bulk] = true
+    }
+}
+
+// invalidateKey tells every client that read key that it changed
+// The clients are forgotten for key, until they read it again
+func invalidateKey(key string) {
+    trackingMu.Lock()
+    clients := trackingTable[key]
+    delete(trackingTable, key)
+    for c := range clients {
+        delete(c.trackedKeys, key)
+    }
+    trackingMu.Unlock()
+
+    if len(clients) == 0 {
+        return
+    }
+
+    msg := Value{typ: "push", array: []Value{
+        {typ: "bulk", bulk: "invalidate"},
+        {typ: "array", array: []Value{{typ: "bulk", bulk: key}}},
+    }}
+    size := int64(messageOverhead + len(key))
+    limits := config.PubsubOutputLimits()
+
+    // Invalidations share the queue published messages go through, so they
+    // are written without holding up the command that made the change
+    pubsubMu.RLock()
+    defer pubsubMu.RUnlock()
+    for c := range clients {
+        // A client that disconnected since we took it from the table has
+        // already dropped its queue
+        if c.pushes == nil {
+            continue
+        }
+        deliver(c, msg, size, limits)
+    }
+}
+
+// stopTracking turns tracking off for c and forgets every key it read
+func stopTracking(c *Client) {
+    trackingMu.Lock()
+    defer trackingMu.Unlock()
+
+    for key := range c.trackedKeys {
+        if clients, ok := trackingTable[key]; ok {
+            delete(clients, c)
+            if len(clients) == 0 {
+                delete(trackingTable, key)
+            }
+        }
+    }
+    c.trackedKeys = map[string]bool{}
+    c.tracking = false
+}
+
+// clientTracking implements CLIENT TRACKING
+// Only the default mode is supported: no BCAST, PREFIX, OPTIN, OPTOUT, NOLOOP
+// or REDIRECT. Invalidations are pushed on the client's own connection, which
+// needs RESP3 to tell them apart from replies
+// The command format is: CLIENT TRACKING ON|OFF
+func clientTracking(c *Client, args []Value) Value {
+    if len(args) != 1 {
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+
+    switch strings.ToUpper(args[0].bulk) {
+    case "ON":
+        if c.writer.Protocol() < 3 {
+            return Value{typ: "error", str: "ERR CLIENT TRACKING without REDIRECT requires RESP3, switch with HELLO 3"}
+        }
+        pubsubMu.Lock()
+        c.startPushQueue()
+        pubsubMu.Unlock()
+        c.tracking = true
+    case "OFF":
+        stopTracking(c)
+    default:
+        return Value{typ: "error", str: "ERR syntax error"}
+    }
+    return Value{typ: "string", str: "OK"}
+}
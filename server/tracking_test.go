@@ -0,0 +1,26 @@
+// Package server tests client-side caching invalidations
+package server
+
+import "testing"
+
+// TestInvalidateDisconnectedClient checks that a change to a key read by a
+// tracking client that has since disconnected doesn't try to push to it
+func TestInvalidateDisconnectedClient(t *testing.T) {
+    c := newTestClient(t)
+    reader := newPeerClient(t, c.aof)
+    run(c, "SET", "k", "v")
+
+    if reply := run(reader, "HELLO", "3"); reply.typ == "error" {
+        t.Fatal(reply.str)
+    }
+    if reply := run(reader, "CLIENT", "TRACKING", "ON"); reply.typ == "error" {
+        t.Fatal(reply.str)
+    }
+    expectBulk(t, run(reader, "GET", "k"), "v")
+
+    // Disconnecting drops the push queue before the client leaves the
+    // tracking table, so a write in between still finds it there
+    unsubscribeAll(reader)
+    run(c, "SET", "k", "w")
+    stopTracking(reader)
+}
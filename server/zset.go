@@ -0,0 +1,493 @@
+// Package server implements the sorted set data type
+// A sorted set maps members to floating-point scores and keeps them ordered by score
+package server
+
+import (
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// zsetEntry is a single member of a sorted set together with its score
+type zsetEntry struct {
+    member string
+    score  float64
+}
+
+// SortedSet stores the members of one sorted set
+// scores gives O(1) lookup of a member's score, while entries keeps every
+// member sorted by (score, member) so ranges and ranks can be answered
+// with a binary search. A sorted slice is simple and fast enough for small
+// and medium sets; inserts are O(n) because elements have to be shifted.
+type SortedSet struct {
+    scores  map[string]float64
+    entries []zsetEntry
+}
+
+// NewSortedSet creates an empty sorted set
+func NewSortedSet() *SortedSet {
+    return &SortedSet{scores: map[string]float64{}}
+}
+
+// less reports whether entry a sorts before (score, member)
+// Members with equal scores are ordered lexicographically, like Redis
+func (a zsetEntry) less(score float64, member string) bool {
+    if a.score != score {
+        return a.score < score
+    }
+    return a.member < member
+}
+
+// search returns the index where (score, member) is or would be inserted
+func (z *SortedSet) search(score float64, member string) int {
+    return sort.Search(len(z.entries), func(i int) bool {
+        return !z.entries[i].less(score, member)
+    })
+}
+
+// Add sets the score of member, inserting it if needed
+// It returns true if the member was newly added
+func (z *SortedSet) Add(member string, score float64) bool {
+    old, exists := z.scores[member]
+    if exists {
+        if old == score {
+            return false
+        }
+        z.remove(member, old)
+    }
+
+    // Insert the entry at its sorted position
+    i := z.search(score, member)
+    z.entries = append(z.entries, zsetEntry{})
+    copy(z.entries[i+1:], z.entries[i:])
+    z.entries[i] = zsetEntry{member: member, score: score}
+    z.scores[member] = score
+
+    return !exists
+}
+
+// remove deletes member (currently at score) from the ordered entries and the score map
+func (z *SortedSet) remove(member string, score float64) {
+    i := z.search(score, member)
+    if i < len(z.entries) && z.entries[i].member == member {
+        z.entries = append(z.entries[:i], z.entries[i+1:]...)
+    }
+    delete(z.scores, member)
+}
+
+// Remove deletes member from the set
+// It returns true if the member was there
+func (z *SortedSet) Remove(member string) bool {
+    score, ok := z.scores[member]
+    if !ok {
+        return false
+    }
+    z.remove(member, score)
+    return true
+}
+
+// Count returns how many members have a score between min and max
+// Each bound is inclusive unless its exclusive flag is set
+func (z *SortedSet) Count(min float64, minExclusive bool, max float64, maxExclusive bool) int {
+    // entries is sorted by score, so both ends can be found with a binary search
+    start := sort.Search(len(z.entries), func(i int) bool {
+        if minExclusive {
+            return z.entries[i].score > min
+        }
+        return z.entries[i].score >= min
+    })
+    end := sort.Search(len(z.entries), func(i int) bool {
+        if maxExclusive {
+            return z.entries[i].score >= max
+        }
+        return z.entries[i].score > max
+    })
+    if end < start {
+        return 0
+    }
+    return end - start
+}
+
+// Rank returns the 0-based position of member in score order
+func (z *SortedSet) Rank(member string) (int, bool) {
+    score, ok := z.scores[member]
+    if !ok {
+        return 0, false
+    }
+    return z.search(score, member), true
+}
+
+// Len returns the number of members in the set
+func (z *SortedSet) Len() int {
+    return len(z.entries)
+}
+
+// ZSETs is our sorted set store
+// It maps each key to its sorted set
+var ZSETs = map[string]*SortedSet{}
+
+// ZSETsMu protects access to the ZSETs map and the sorted sets inside it
+var ZSETsMu = sync.RWMutex{}
+
+// parseScore parses a sorted set score
+// Like Redis, "inf", "+inf" and "-inf" are accepted, but NaN is not
+func parseScore(s string) (float64, bool) {
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil || math.IsNaN(f) {
+        return 0, false
+    }
+    return f, true
+}
+
+// parseScoreBound parses a ZCOUNT-style range bound
+// A leading "(" makes the bound exclusive, e.g. "(1.5"; "-inf" and "+inf" are accepted
+func parseScoreBound(s string) (float64, bool, bool) {
+    exclusive := strings.HasPrefix(s, "(")
+    if exclusive {
+        s = s[1:]
+    }
+    f, ok := parseScore(s)
+    return f, exclusive, ok
+}
+
+// formatScore formats a score the way Redis prints it in replies
+func formatScore(f float64) string {
+    switch {
+    case math.IsInf(f, 1):
+        return "inf"
+    case math.IsInf(f, -1):
+        return "-inf"
+    }
+    return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+// zadd implements the Redis ZADD command
+// It adds members with scores to a sorted set, updating the score of existing members
+// The command format is: ZADD key score member [score member ...]
+func zadd(c *Client, args []Value) Value {
+    // ZADD needs a key followed by one or more score/member pairs
+    if (len(args)-1)%2 != 0 {
+        return Value{typ: "error", str: "ERR wrong number of arguments for 'zadd' command"}
+    }
+
+    key := args[0].bulk
+
+    // Validate every score before touching the set so a bad pair
+    // doesn't leave the command half applied
+    scores := make([]float64, 0, (len(args)-1)/2)
+    for i := 1; i < len(args); i += 2 {
+        score, ok := parseScore(args[i].bulk)
+        if !ok {
+            return Value{typ: "error", str: "ERR value is not a valid float"}
+        }
+        scores = append(scores, score)
+    }
+
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    defer unlockAllStores()
+    if wrongTypeLocked(key, "zset") {
+        return wrongTypeError
+    }
+
+    // If this sorted set doesn't exist yet, create it
+    zset, ok := ZSETs[key]
+    if !ok {
+        zset = NewSortedSet()
+        ZSETs[key] = zset
+        trackMemory(int64(keyOverhead + len(key)))
+        indexKey(key)
+    }
+
+    // Count only the members that didn't exist before
+    added := 0
+    for i, score := range scores {
+        member := args[2+i*2].bulk
+        if zset.Add(member, score) {
+            trackMemory(zsetMemberMemory(member))
+            added++
+        }
+    }
+    touchKey(key)
+    notifyKeyspaceEvent(notifyZset, "zadd", key)
+
+    return Value{typ: "integer", num: added}
+}
+
+// zscore implements the Redis ZSCORE command
+// It returns the score of a member as a bulk string
+// The command format is: ZSCORE key member
+func zscore(c *Client, args []Value) Value {
+    expireIfNeeded(args[0].bulk)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(args[0].bulk, "zset") {
+        return wrongTypeError
+    }
+
+    zset, ok := ZSETs[args[0].bulk]
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(args[0].bulk)
+
+    score, ok := zset.scores[args[1].bulk]
+    if !ok {
+        return Value{typ: "null"}
+    }
+
+    return Value{typ: "bulk", bulk: formatScore(score)}
+}
+
+// zrange implements the Redis ZRANGE command
+// It returns the members between two ranks, ordered by ascending score
+// Negative indexes count from the end (-1 is the last member)
+// The command format is: ZRANGE key start stop [WITHSCORES]
+func zrange(c *Client, args []Value) Value {
+    start, err1 := strconv.Atoi(args[1].bulk)
+    stop, err2 := strconv.Atoi(args[2].bulk)
+    if err1 != nil || err2 != nil {
+        return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+    }
+
+    withScores := false
+    if len(args) == 4 {
+        if strings.ToUpper(args[3].bulk) != "WITHSCORES" {
+            return Value{typ: "error", str: "ERR syntax error"}
+        }
+        withScores = true
+    }
+
+    expireIfNeeded(args[0].bulk)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(args[0].bulk, "zset") {
+        return wrongTypeError
+    }
+
+    zset, ok := ZSETs[args[0].bulk]
+    if !ok {
+        return Value{typ: "array", array: []Value{}}
+    }
+    touchKey(args[0].bulk)
+
+    // Convert negative indexes and clamp the range to the set
+    n := zset.Len()
+    if start < 0 {
+        start += n
+    }
+    if stop < 0 {
+        stop += n
+    }
+    if start < 0 {
+        start = 0
+    }
+    if stop >= n {
+        stop = n - 1
+    }
+
+    values := []Value{}
+    for i := start; i <= stop; i++ {
+        entry := zset.entries[i]
+        values = append(values, Value{typ: "bulk", bulk: entry.member})
+        if withScores {
+            values = append(values, Value{typ: "bulk", bulk: formatScore(entry.score)})
+        }
+    }
+
+    return Value{typ: "array", array: values}
+}
+
+// zrank implements the Redis ZRANK command
+// It returns the 0-based rank of a member, ordered by ascending score
+// The command format is: ZRANK key member
+func zrank(c *Client, args []Value) Value {
+    expireIfNeeded(args[0].bulk)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(args[0].bulk, "zset") {
+        return wrongTypeError
+    }
+
+    zset, ok := ZSETs[args[0].bulk]
+    if !ok {
+        return Value{typ: "null"}
+    }
+    touchKey(args[0].bulk)
+
+    rank, ok := zset.Rank(args[1].bulk)
+    if !ok {
+        return Value{typ: "null"}
+    }
+
+    return Value{typ: "integer", num: rank}
+}
+
+// removeEmptyZsetLocked deletes key once its last member is gone
+// Like Redis, an empty sorted set doesn't exist, so it also loses its expiry
+// The caller must hold ZSETsMu for writing
+func removeEmptyZsetLocked(key string) bool {
+    if zset, ok := ZSETs[key]; !ok || zset.Len() > 0 {
+        return false
+    }
+    delete(ZSETs, key)
+    trackMemory(-int64(keyOverhead + len(key)))
+    unindexKey(key)
+    clearExpiration(key)
+    forgetKeyAccess(key)
+    return true
+}
+
+// zincrby implements the Redis ZINCRBY command
+// It adds increment to the score of member, adding the member at increment if it's missing
+// Returns the new score as a bulk string
+// The command format is: ZINCRBY key increment member
+func zincrby(c *Client, args []Value) Value {
+    key := args[0].bulk
+    member := args[2].bulk
+    increment, ok := parseScore(args[1].bulk)
+    if !ok {
+        return Value{typ: "error", str: "ERR value is not a valid float"}
+    }
+
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused, not shadowed
+    lockAllStores()
+    if wrongTypeLocked(key, "zset") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    zset, exists := ZSETs[key]
+    score := increment
+    if exists {
+        score += zset.scores[member]
+    }
+
+    // inf + -inf has no meaningful result
+    if math.IsNaN(score) {
+        unlockAllStores()
+        return Value{typ: "error", str: "ERR resulting score is not a number (NaN)"}
+    }
+
+    if !exists {
+        zset = NewSortedSet()
+        ZSETs[key] = zset
+        trackMemory(int64(keyOverhead + len(key)))
+        indexKey(key)
+    }
+    if zset.Add(member, score) {
+        trackMemory(zsetMemberMemory(member))
+    }
+    unlockAllStores()
+
+    touchKey(key)
+    notifyKeyspaceEvent(notifyZset, "zincr", key)
+
+    return Value{typ: "bulk", bulk: formatScore(score)}
+}
+
+// zcard implements the Redis ZCARD command
+// It returns the number of members in a sorted set, or 0 if it doesn't exist
+// The command format is: ZCARD key
+func zcard(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(key, "zset") {
+        return wrongTypeError
+    }
+
+    zset, ok := ZSETs[key]
+    if !ok {
+        return Value{typ: "integer", num: 0}
+    }
+    touchKey(key)
+
+    return Value{typ: "integer", num: zset.Len()}
+}
+
+// zcount implements the Redis ZCOUNT command
+// It returns the number of members with a score between min and max, both inclusive
+// by default; prefix a bound with "(" to exclude it, and use -inf/+inf for open ends
+// The command format is: ZCOUNT key min max
+func zcount(c *Client, args []Value) Value {
+    min, minExclusive, ok1 := parseScoreBound(args[1].bulk)
+    max, maxExclusive, ok2 := parseScoreBound(args[2].bulk)
+    if !ok1 || !ok2 {
+        return Value{typ: "error", str: "ERR min or max is not a float"}
+    }
+
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is read-locked so a key of another type is refused
+    rLockAllStores()
+    defer rUnlockAllStores()
+    if wrongTypeLocked(key, "zset") {
+        return wrongTypeError
+    }
+
+    zset, ok := ZSETs[key]
+    if !ok {
+        return Value{typ: "integer", num: 0}
+    }
+    touchKey(key)
+
+    return Value{typ: "integer", num: zset.Count(min, minExclusive, max, maxExclusive)}
+}
+
+// zrem implements the Redis ZREM command
+// It removes members from a sorted set and returns how many of them were there
+// The command format is: ZREM key member [member ...]
+func zrem(c *Client, args []Value) Value {
+    key := args[0].bulk
+    expireIfNeeded(key)
+
+    // Every store is locked so a key of another type is refused
+    lockAllStores()
+    if wrongTypeLocked(key, "zset") {
+        unlockAllStores()
+        return wrongTypeError
+    }
+    zset, ok := ZSETs[key]
+    if !ok {
+        unlockAllStores()
+        return Value{typ: "integer", num: 0}
+    }
+
+    removed := 0
+    for _, arg := range args[1:] {
+        if zset.Remove(arg.bulk) {
+            trackMemory(-zsetMemberMemory(arg.bulk))
+            removed++
+        }
+    }
+    deleted := removeEmptyZsetLocked(key)
+    unlockAllStores()
+
+    if removed > 0 {
+        if !deleted {
+            touchKey(key)
+        }
+        notifyKeyspaceEvent(notifyZset, "zrem", key)
+        if deleted {
+            notifyKeyspaceEvent(notifyGeneric, "del", key)
+        }
+    }
+
+    return Value{typ: "integer", num: removed}
+}
@@ -0,0 +1,67 @@
+// Package server tests the sorted set commands
+package server
+
+import "testing"
+
+// TestZaddOnOtherType checks that ZADD refuses a key holding another type
+func TestZaddOnOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+
+    expectError(t, run(c, "ZADD", "k", "1", "m"), "WRONGTYPE")
+    expectBulk(t, run(c, "GET", "k"), "v")
+
+    ZSETsMu.RLock()
+    _, inZSETs := ZSETs["k"]
+    ZSETsMu.RUnlock()
+    if inZSETs {
+        t.Fatal("ZADD created a sorted set next to the string")
+    }
+}
+
+// TestZaddOnSortedSet checks that ZADD still adds to an existing sorted set
+func TestZaddOnSortedSet(t *testing.T) {
+    c := newTestClient(t)
+
+    expectInteger(t, run(c, "ZADD", "k", "1", "a"), 1)
+    expectInteger(t, run(c, "ZADD", "k", "2", "a", "3", "b"), 1)
+    expectBulk(t, run(c, "ZSCORE", "k", "a"), "2")
+    expectInteger(t, run(c, "ZCARD", "k"), 2)
+}
+
+// TestZsetWritesOnOtherType checks that ZINCRBY and ZREM refuse a key holding
+// another type, instead of creating a sorted set next to it
+func TestZsetWritesOnOtherType(t *testing.T) {
+    c := newTestClient(t)
+    run(c, "SET", "k", "v")
+
+    expectError(t, run(c, "ZINCRBY", "k", "1", "m"), "WRONGTYPE")
+    expectError(t, run(c, "ZREM", "k", "m"), "WRONGTYPE")
+    expectBulk(t, run(c, "GET", "k"), "v")
+
+    ZSETsMu.RLock()
+    _, inZSETs := ZSETs["k"]
+    ZSETsMu.RUnlock()
+    if inZSETs {
+        t.Fatal("ZINCRBY created a sorted set next to the string")
+    }
+}
+
+// TestZsetReadsOnOtherType checks that the sorted set reads refuse a key
+// holding a string, instead of treating it as a missing sorted set
+func TestZsetReadsOnOtherType(t *testing.T) {
+    for _, command := range [][]string{
+        {"ZSCORE", "k", "m"},
+        {"ZRANGE", "k", "0", "-1"},
+        {"ZRANK", "k", "m"},
+        {"ZCARD", "k"},
+        {"ZCOUNT", "k", "-inf", "+inf"},
+    } {
+        t.Run(command[0], func(t *testing.T) {
+            c := newTestClient(t)
+            run(c, "SET", "k", "v")
+
+            expectError(t, run(c, command...), "WRONGTYPE")
+        })
+    }
+}